@@ -0,0 +1,59 @@
+// Package bots tells crawlers, link preview unfurlers and prefetches apart from people following a link.
+package bots
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+)
+
+//go:embed user-agents.txt
+var userAgentList string
+
+// Lowercase User-Agent substrings from user-agents.txt
+var patterns = parsePatterns(userAgentList)
+
+// IsBot reports whether the request was made by a bot rather than a person.
+//
+// HEAD requests only check where a link goes, browsers send Purpose or Sec-Purpose headers on
+// prefetches that the user may never see, and every browser sends a User-Agent.
+func IsBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for _, h := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		v := strings.ToLower(r.Header.Get(h))
+		if strings.Contains(v, "prefetch") || strings.Contains(v, "preview") {
+			return true
+		}
+	}
+	ua := r.UserAgent()
+	if len(strings.TrimSpace(ua)) == 0 {
+		return true
+	}
+	_, ok := MatchUserAgent(ua)
+	return ok
+}
+
+// MatchUserAgent returns the pattern from the list that ua matches.
+func MatchUserAgent(ua string) (string, bool) {
+	ua = strings.ToLower(ua)
+	for _, p := range patterns {
+		if strings.Contains(ua, p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func parsePatterns(list string) []string {
+	var out []string
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
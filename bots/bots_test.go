@@ -0,0 +1,51 @@
+package bots
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36"
+
+func TestIsBot(t *testing.T) {
+	t.Run("classifies requests", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			method  string
+			headers map[string]string
+			want    bool
+		}{
+			{"browser", http.MethodGet, map[string]string{"User-Agent": chrome}, false},
+			{"Android phone", http.MethodGet, map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 13; Cubot X19) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Mobile Safari/537.36"}, false},
+			{"Slack unfurler", http.MethodGet, map[string]string{"User-Agent": "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}, true},
+			{"iMessage preview", http.MethodGet, map[string]string{"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_1) AppleWebKit/601.2.4 (KHTML, like Gecko) Version/9.0.1 Safari/601.2.4 facebookexternalhit/1.1 Facebot Twitterbot/1.0"}, true},
+			{"Twitter card", http.MethodGet, map[string]string{"User-Agent": "Twitterbot/1.0"}, true},
+			{"curl", http.MethodGet, map[string]string{"User-Agent": "curl/8.1.2"}, true},
+			{"no User-Agent", http.MethodGet, map[string]string{}, true},
+			{"HEAD request", http.MethodHead, map[string]string{"User-Agent": chrome}, true},
+			{"Chrome prefetch", http.MethodGet, map[string]string{"User-Agent": chrome, "Sec-Purpose": "prefetch;prerender"}, true},
+			{"Safari prefetch", http.MethodGet, map[string]string{"User-Agent": chrome, "Purpose": "prefetch"}, true},
+		}
+
+		for _, c := range tests {
+			r := httptest.NewRequest(c.method, "/abc123", nil)
+			r.Header.Del("User-Agent")
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			if got := IsBot(r); got != c.want {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			}
+		}
+	})
+
+	t.Run("ignores comments in the list", func(t *testing.T) {
+		got := parsePatterns("# comment\n\nSlackbot\n  curl/  \n")
+		testutil.AssertEqual(t, len(got), 2)
+		testutil.AssertEqual(t, got[0], "slackbot")
+		testutil.AssertEqual(t, got[1], "curl/")
+	})
+}
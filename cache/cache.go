@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+)
+
+type (
+	// Store is a read-through cache for FindLink in front of another LinkStore.
+	// Writes made through the Store invalidate the affected codes.
+	Store struct {
+		next        handlers.LinkStore
+		ttl         time.Duration
+		negativeTTL time.Duration
+
+		// A mutex is used to synchronize access to the LRU and stats
+		lock  sync.Mutex
+		lru   *LRU[string, entry]
+		stats Stats
+		// Incremented on every invalidation so lookups that raced a write don't cache stale results
+		generation uint64
+
+		// Overridden in tests
+		now func() time.Time
+	}
+
+	Opts struct {
+		// Maximum number of codes to cache. Defaults to 1000.
+		Size int
+		// How long a found link is cached. Defaults to 1 minute.
+		TTL time.Duration
+		// How long an unknown code is cached. Defaults to 10 seconds.
+		NegativeTTL time.Duration
+	}
+
+	// Stats are cumulative counters describing how well the cache is performing.
+	Stats struct {
+		// Lookups answered with a cached link.
+		Hits uint64 `json:"hits"`
+		// Lookups answered with a cached "not found".
+		NegativeHits uint64 `json:"negativeHits"`
+		// Lookups that were passed to the underlying store.
+		Misses uint64 `json:"misses"`
+		// Entries removed to make room for new ones.
+		Evictions uint64 `json:"evictions"`
+		// Current number of cached codes.
+		Size int `json:"size"`
+	}
+
+	entry struct {
+		link    shorty.Link
+		found   bool
+		expires time.Time
+	}
+)
+
+// NewStore wraps a LinkStore with a read-through FindLink cache.
+func NewStore(next handlers.LinkStore, o Opts) *Store {
+	if o.Size <= 0 {
+		o.Size = 1000
+	}
+	if o.TTL <= 0 {
+		o.TTL = time.Minute
+	}
+	if o.NegativeTTL <= 0 {
+		o.NegativeTTL = 10 * time.Second
+	}
+
+	s := &Store{
+		next:        next,
+		ttl:         o.TTL,
+		negativeTTL: o.NegativeTTL,
+		lru:         NewLRU[string, entry](o.Size),
+		now:         time.Now,
+	}
+	s.lru.OnEvict = func(string, entry) { s.stats.Evictions++ }
+	return s
+}
+
+// Stats returns a snapshot of the cache counters.
+func (s *Store) Stats() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := s.stats
+	stats.Size = s.lru.Len()
+	return stats
+}
+
+// FindLink returns the cached link for code, or looks it up in the underlying store and caches the result.
+// Unknown codes are cached as well so repeated requests for them do not reach the store.
+func (s *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	s.lock.Lock()
+	e, ok := s.lru.Get(code)
+	if ok && s.now().Before(e.expires) {
+		if e.found {
+			s.stats.Hits++
+			s.lock.Unlock()
+			return e.link, nil
+		}
+		s.stats.NegativeHits++
+		s.lock.Unlock()
+		return shorty.Link{}, shorty.ErrLinkNotFound
+	}
+	s.stats.Misses++
+	generation := s.generation
+	s.lock.Unlock()
+
+	link, err := s.next.FindLink(ctx, code)
+	if err != nil {
+		if err == shorty.ErrLinkNotFound {
+			s.set(code, generation, entry{expires: s.now().Add(s.negativeTTL)})
+		}
+		return link, err
+	}
+	s.set(code, generation, entry{link: link, found: true, expires: s.now().Add(s.ttl)})
+	return link, nil
+}
+
+func (s *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	// Drop any cached "not found" for the new code
+	defer s.invalidate(newLink.Code)
+	return s.next.SaveLink(ctx, newLink)
+}
+
+func (s *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
+	return s.next.FindAllLinks(ctx)
+}
+
+func (s *Store) UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	// The link may move to a new code, so both the old and new codes are stale
+	defer s.invalidate(code, toUpdate.Code, toUpdate.CustomCode)
+	return s.next.UpdateLink(ctx, code, toUpdate, opts)
+}
+
+func (s *Store) DeleteLink(ctx context.Context, code string) (int, error) {
+	defer s.invalidate(code)
+	return s.next.DeleteLink(ctx, code)
+}
+
+// FindSimilarCodes looks up similar codes in the underlying store.
+func (s *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	return s.next.FindSimilarCodes(ctx, code, maxDistance)
+}
+
+func (s *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
+	return s.next.CheckCodeInUse(ctx, code)
+}
+
+// EachLink streams links from the underlying store without caching them.
+func (s *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	return s.next.EachLink(ctx, fn)
+}
+
+// Ping checks the underlying store. Cached links don't make an unreachable store ready.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// IncrementTotalClicks increments the click count in the underlying store.
+// A cached link's count is bumped as well rather than invalidated, since every redirect increments it.
+func (s *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
+	n, err := s.next.IncrementTotalClicks(ctx, code)
+	if err != nil {
+		return n, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.lru.Get(code); ok && e.found {
+		e.link.AddClicks(shorty.ClickCounts{Human: 1})
+		s.lru.Add(code, e)
+	}
+	return n, nil
+}
+
+// Set caches an entry unless the cache was invalidated since generation was read.
+func (s *Store) set(code string, generation uint64, e entry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if generation != s.generation {
+		return
+	}
+	s.lru.Add(code, e)
+}
+
+func (s *Store) invalidate(codes ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.generation++
+	for _, code := range codes {
+		if len(code) > 0 {
+			s.lru.Remove(code)
+		}
+	}
+}
+
+// IncrementTotalClicksBatch increments the click counts in the underlying store and bumps any cached counts.
+func (s *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	if err := s.next.IncrementTotalClicksBatch(ctx, counts); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for code, n := range counts {
+		if e, ok := s.lru.Get(code); ok && e.found {
+			e.link.AddClicks(n)
+			s.lru.Add(code, e)
+		}
+	}
+	return nil
+}
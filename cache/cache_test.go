@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
+)
+
+// CountingStore counts the FindLink calls that reach the underlying store.
+type countingStore struct {
+	handlers.LinkStore
+	finds int
+}
+
+func (c *countingStore) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	c.finds++
+	return c.LinkStore.FindLink(ctx, code)
+}
+
+func newTestStore(t *testing.T) (*Store, *countingStore) {
+	t.Helper()
+	backing := inmem.NewStore()
+	backing.Store = map[string]shorty.Link{
+		"abc123": {Code: "abc123", OriginalUrl: "https://operationspark.org"},
+	}
+	counting := &countingStore{LinkStore: backing}
+	return NewStore(counting, Opts{Size: 2, TTL: time.Minute, NegativeTTL: time.Second}), counting
+}
+
+func TestFindLink(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("serves repeated lookups from the cache", func(t *testing.T) {
+		store, counting := newTestStore(t)
+
+		for i := 0; i < 3; i++ {
+			link, err := store.FindLink(ctx, "abc123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AssertEqual(t, link.OriginalUrl, "https://operationspark.org")
+		}
+
+		testutil.AssertEqual(t, counting.finds, 1)
+		stats := store.Stats()
+		testutil.AssertEqual(t, stats.Hits, uint64(2))
+		testutil.AssertEqual(t, stats.Misses, uint64(1))
+	})
+
+	t.Run("caches unknown codes", func(t *testing.T) {
+		store, counting := newTestStore(t)
+
+		for i := 0; i < 2; i++ {
+			_, err := store.FindLink(ctx, "nope")
+			testutil.AssertEqual(t, err, shorty.ErrLinkNotFound)
+		}
+		testutil.AssertEqual(t, counting.finds, 1)
+		testutil.AssertEqual(t, store.Stats().NegativeHits, uint64(1))
+
+		// Creating the link replaces the negative entry
+		store.SaveLink(ctx, shorty.Link{Code: "nope", OriginalUrl: "https://ospk.org"})
+		link, err := store.FindLink(ctx, "nope")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, link.OriginalUrl, "https://ospk.org")
+	})
+
+	t.Run("expires entries after the TTL", func(t *testing.T) {
+		store, counting := newTestStore(t)
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		store.FindLink(ctx, "abc123")
+		now = now.Add(2 * time.Minute)
+		store.FindLink(ctx, "abc123")
+
+		testutil.AssertEqual(t, counting.finds, 2)
+	})
+
+	t.Run("invalidates updated and deleted codes", func(t *testing.T) {
+		store, _ := newTestStore(t)
+
+		store.FindLink(ctx, "abc123")
+		store.UpdateLink(ctx, "abc123", shorty.Link{OriginalUrl: "https://changelog.com"}, shorty.UpdateOpts{})
+		link, _ := store.FindLink(ctx, "abc123")
+		testutil.AssertEqual(t, link.OriginalUrl, "https://changelog.com")
+
+		store.DeleteLink(ctx, "abc123")
+		_, err := store.FindLink(ctx, "abc123")
+		testutil.AssertEqual(t, err, shorty.ErrLinkNotFound)
+	})
+
+	t.Run("evicts the least recently used code", func(t *testing.T) {
+		store, _ := newTestStore(t)
+
+		store.FindLink(ctx, "abc123")
+		store.FindLink(ctx, "one")
+		store.FindLink(ctx, "two")
+
+		stats := store.Stats()
+		testutil.AssertEqual(t, stats.Evictions, uint64(1))
+		testutil.AssertEqual(t, stats.Size, 2)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+		return NewStore(inmem.NewStore(), Opts{})
+	})
+}
@@ -0,0 +1,75 @@
+package cache
+
+import "container/list"
+
+type (
+	// LRU is a fixed-size least-recently-used cache. It is not safe for concurrent use.
+	LRU[K comparable, V any] struct {
+		size  int
+		ll    *list.List
+		items map[K]*list.Element
+		// Called with the key and value of entries evicted to make room for new ones.
+		OnEvict func(key K, value V)
+	}
+
+	lruItem[K comparable, V any] struct {
+		key   K
+		value V
+	}
+)
+
+// NewLRU creates an LRU that holds at most size entries.
+func NewLRU[K comparable, V any](size int) *LRU[K, V] {
+	if size < 1 {
+		size = 1
+	}
+	return &LRU[K, V]{
+		size:  size,
+		ll:    list.New(),
+		items: map[K]*list.Element{},
+	}
+}
+
+// Get returns the value for key and marks it as recently used.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruItem[K, V]).value, true
+}
+
+// Add inserts or replaces the value for key, evicting the least recently used entry if the cache is full.
+func (c *LRU[K, V]) Add(key K, value V) {
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*lruItem[K, V]).value = value
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruItem[K, V]{key, value})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		item := oldest.Value.(*lruItem[K, V])
+		delete(c.items, item.key)
+		if c.OnEvict != nil {
+			c.OnEvict(item.key, item.value)
+		}
+	}
+}
+
+// Remove deletes the entry for key, if any.
+func (c *LRU[K, V]) Remove(key K) {
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *LRU[K, V]) Len() int {
+	return c.ll.Len()
+}
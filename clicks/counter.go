@@ -0,0 +1,184 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+	"github.com/operationspark/shorty/resilient"
+	"github.com/operationspark/shorty/shorty"
+)
+
+type (
+	// BatchStore persists aggregated click counts.
+	BatchStore interface {
+		IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error
+	}
+
+	// Counter buffers click counts in memory and writes them to the store in batches.
+	//
+	// Clicks are flushed every FlushInterval, as soon as MaxPending clicks are buffered, and on Close.
+	// If the process crashes, the clicks since the last flush are lost: at most MaxPending, or FlushInterval worth.
+	// A batch whose write fails is dropped rather than retried, since the store may have applied it before the error.
+	// The exception is a write the circuit breaker rejected without sending, which is kept for the next flush.
+	// Early flushes stop while the store is failing, so during an outage every click since the last
+	// successful write is at risk: FlushInterval worth per failed write, and all of them on a crash.
+	Counter struct {
+		store        BatchStore
+		maxPending   int
+		flushTimeout time.Duration
+
+		// A mutex is used to synchronize access to the pending counts
+		lock    sync.Mutex
+		pending map[string]shorty.ClickCounts
+		total   int
+		// Set after a failed flush so a store outage doesn't turn every click into a write attempt
+		failing bool
+
+		full chan struct{}
+		stop chan struct{}
+		done chan struct{}
+		once sync.Once
+	}
+
+	Opts struct {
+		// How often buffered clicks are written to the store. Defaults to 5 seconds.
+		FlushInterval time.Duration
+		// Number of buffered clicks that triggers an early flush. Defaults to 1000.
+		MaxPending int
+		// Number of click events a Recorder keeps while the store is failing, and of code and referrer pairs
+		// a MissRecorder keeps between flushes. Defaults to 10 times MaxPending.
+		MaxBuffered int
+		// Timeout for each batch write. Defaults to 10 seconds.
+		FlushTimeout time.Duration
+	}
+)
+
+// NewCounter creates a Counter and starts its background flush loop. Call Close to stop it.
+func NewCounter(store BatchStore, o Opts) *Counter {
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 5 * time.Second
+	}
+	if o.MaxPending <= 0 {
+		o.MaxPending = 1000
+	}
+	if o.FlushTimeout <= 0 {
+		o.FlushTimeout = 10 * time.Second
+	}
+
+	c := &Counter{
+		store:        store,
+		maxPending:   o.MaxPending,
+		flushTimeout: o.FlushTimeout,
+		pending:      map[string]shorty.ClickCounts{},
+		full:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go c.flushLoop(o.FlushInterval)
+	return c
+}
+
+// Count buffers a click for code, by a bot or a person. It never blocks on the store.
+func (c *Counter) Count(code string, bot bool) {
+	c.lock.Lock()
+	counts := c.pending[code]
+	if bot {
+		counts.Bot++
+	} else {
+		counts.Human++
+	}
+	c.pending[code] = counts
+	c.total++
+	full := c.total >= c.maxPending && !c.failing
+	c.lock.Unlock()
+
+	if full {
+		// Non-blocking: a flush is already requested if the channel is full
+		select {
+		case c.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all buffered clicks to the store.
+// On failure the clicks are dropped, unless the circuit breaker kept the write from being sent.
+func (c *Counter) Flush(ctx context.Context) error {
+	c.lock.Lock()
+	batch := c.pending
+	c.pending = map[string]shorty.ClickCounts{}
+	c.total = 0
+	c.lock.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := c.store.IncrementTotalClicksBatch(ctx, batch)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failing = err != nil
+	if err == nil {
+		return nil
+	}
+	// Retrying a write that may have been applied would count its clicks twice
+	if !errors.Is(err, resilient.ErrCircuitOpen) {
+		dropped := 0
+		for _, n := range batch {
+			dropped += n.Total()
+		}
+		return fmt.Errorf("dropped %d clicks: %v", dropped, err)
+	}
+	// Put the batch back so it's retried with the next one
+	for code, n := range batch {
+		counts := c.pending[code]
+		counts.Human += n.Human
+		counts.Bot += n.Bot
+		c.pending[code] = counts
+		c.total += n.Total()
+	}
+	return err
+}
+
+// Close stops the flush loop and writes any remaining clicks.
+func (c *Counter) Close(ctx context.Context) error {
+	c.once.Do(func() { close(c.stop) })
+	<-c.done
+	return c.Flush(ctx)
+}
+
+func (c *Counter) flushLoop(interval time.Duration) {
+	runFlushLoop(interval, c.flushTimeout, c.full, c.stop, c.done, c.Flush, "flush click counts")
+}
+
+// RunFlushLoop calls flush every interval, and whenever full receives, until stop is closed. It closes done when it returns.
+func runFlushLoop(interval, timeout time.Duration, full, stop <-chan struct{}, done chan<- struct{}, flush func(context.Context) error, what string) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-full:
+		case <-stop:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := flush(ctx); err != nil {
+			log.Println(gcp.LogEntry{
+				Severity:  "WARNING",
+				Message:   what + ": " + err.Error(),
+				Component: "clicks",
+			})
+		}
+		cancel()
+	}
+}
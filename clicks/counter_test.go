@@ -0,0 +1,124 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/resilient"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+// FlakyStore fails every batch with err until it's set to nil.
+type flakyStore struct {
+	lock    sync.Mutex
+	err     error
+	batches []map[string]shorty.ClickCounts
+}
+
+func (f *flakyStore) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.batches = append(f.batches, counts)
+	return nil
+}
+
+func (f *flakyStore) batchCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.batches)
+}
+
+func TestCounter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("writes buffered clicks on close", func(t *testing.T) {
+		store := inmem.NewStore()
+		store.Store = map[string]shorty.Link{
+			"abc123": {Code: "abc123"},
+			"xyz789": {Code: "xyz789"},
+		}
+		counter := NewCounter(store, Opts{FlushInterval: time.Hour})
+
+		counter.Count("abc123", false)
+		counter.Count("abc123", false)
+		counter.Count("xyz789", true)
+		// Unknown codes are ignored by the store
+		counter.Count("deleted", false)
+
+		link, _ := store.FindLink(ctx, "abc123")
+		testutil.AssertEqual(t, link.TotalClicks, 0)
+
+		if err := counter.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// Closing again is harmless and writes nothing more
+		if err := counter.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		link, _ = store.FindLink(ctx, "abc123")
+		testutil.AssertEqual(t, link.TotalClicks, 2)
+		testutil.AssertEqual(t, link.HumanClicks, 2)
+		link, _ = store.FindLink(ctx, "xyz789")
+		testutil.AssertEqual(t, link.TotalClicks, 0)
+		testutil.AssertEqual(t, link.BotClicks, 1)
+	})
+
+	t.Run("flushes early once MaxPending clicks are buffered", func(t *testing.T) {
+		store := &flakyStore{}
+		counter := NewCounter(store, Opts{FlushInterval: time.Hour, MaxPending: 3})
+		defer counter.Close(ctx)
+
+		for i := 0; i < 3; i++ {
+			counter.Count("abc123", false)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for store.batchCount() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		testutil.AssertEqual(t, store.batchCount(), 1)
+	})
+
+	t.Run("drops clicks when a flush fails", func(t *testing.T) {
+		// The write may have been applied before the error, so retrying it could count clicks twice
+		store := &flakyStore{err: errors.New("context deadline exceeded")}
+		counter := NewCounter(store, Opts{FlushInterval: time.Hour})
+
+		counter.Count("abc123", false)
+		if err := counter.Flush(ctx); err == nil {
+			t.Fatal("want error from failing store")
+		}
+
+		store.err = nil
+		counter.Count("abc123", false)
+		if err := counter.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, store.batches[0]["abc123"], shorty.ClickCounts{Human: 1})
+	})
+
+	t.Run("keeps clicks the circuit breaker didn't send", func(t *testing.T) {
+		store := &flakyStore{err: resilient.ErrCircuitOpen}
+		counter := NewCounter(store, Opts{FlushInterval: time.Hour})
+
+		counter.Count("abc123", false)
+		if err := counter.Flush(ctx); err == nil {
+			t.Fatal("want error from failing store")
+		}
+
+		store.err = nil
+		counter.Count("abc123", false)
+		if err := counter.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, store.batches[0]["abc123"], shorty.ClickCounts{Human: 2})
+	})
+}
@@ -0,0 +1,141 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+	"github.com/operationspark/shorty/resilient"
+	"github.com/operationspark/shorty/shorty"
+)
+
+type (
+	// EventStore persists click events.
+	EventStore interface {
+		SaveClicks(ctx context.Context, clicks []shorty.Click) error
+	}
+
+	// Recorder buffers click events in memory and writes them to the store in batches, like Counter.
+	//
+	// A batch whose write fails is dropped, since the store may have saved part of it. Batches the circuit
+	// breaker rejected are kept, but at most MaxBuffered events, so an outage can't exhaust memory.
+	Recorder struct {
+		store        EventStore
+		maxPending   int
+		maxBuffered  int
+		flushTimeout time.Duration
+
+		lock    sync.Mutex
+		pending []shorty.Click
+		// Events dropped since the last successful flush
+		dropped int
+		failing bool
+
+		full chan struct{}
+		stop chan struct{}
+		done chan struct{}
+		once sync.Once
+	}
+)
+
+// NewRecorder creates a Recorder and starts its background flush loop. Call Close to stop it.
+// o.MaxBuffered defaults to 10 times MaxPending.
+func NewRecorder(store EventStore, o Opts) *Recorder {
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 5 * time.Second
+	}
+	if o.MaxPending <= 0 {
+		o.MaxPending = 1000
+	}
+	if o.MaxBuffered < o.MaxPending {
+		o.MaxBuffered = 10 * o.MaxPending
+	}
+	if o.FlushTimeout <= 0 {
+		o.FlushTimeout = 10 * time.Second
+	}
+
+	r := &Recorder{
+		store:        store,
+		maxPending:   o.MaxPending,
+		maxBuffered:  o.MaxBuffered,
+		flushTimeout: o.FlushTimeout,
+		full:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go runFlushLoop(o.FlushInterval, o.FlushTimeout, r.full, r.stop, r.done, r.Flush, "flush click events")
+	return r
+}
+
+// Record buffers a click event. It never blocks on the store.
+func (r *Recorder) Record(c shorty.Click) {
+	r.lock.Lock()
+	if len(r.pending) >= r.maxBuffered {
+		r.dropped++
+		r.lock.Unlock()
+		return
+	}
+	r.pending = append(r.pending, c)
+	full := len(r.pending) >= r.maxPending && !r.failing
+	r.lock.Unlock()
+
+	if full {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all buffered events to the store.
+// On failure the events are dropped, unless the circuit breaker rejected the write without sending it.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.lock.Lock()
+	batch := r.pending
+	r.pending = nil
+	r.lock.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := r.store.SaveClicks(ctx, batch)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failing = err != nil
+	// Retrying a write that may have been applied would save its events twice
+	if err != nil && !errors.Is(err, resilient.ErrCircuitOpen) {
+		return fmt.Errorf("dropped %d click events: %v", len(batch), err)
+	}
+	if err != nil {
+		// Put the batch back ahead of newer events, dropping the newest beyond the limit
+		batch = append(batch, r.pending...)
+		if len(batch) > r.maxBuffered {
+			r.dropped += len(batch) - r.maxBuffered
+			batch = batch[:r.maxBuffered]
+		}
+		r.pending = batch
+		return fmt.Errorf("saveClicks: %v", err)
+	}
+	if r.dropped > 0 {
+		log.Println(gcp.LogEntry{
+			Severity:  "WARNING",
+			Message:   fmt.Sprintf("dropped %d click events while the store was failing", r.dropped),
+			Component: "clicks",
+		})
+		r.dropped = 0
+	}
+	return nil
+}
+
+// Close stops the flush loop and writes any remaining events.
+func (r *Recorder) Close(ctx context.Context) error {
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+	return r.Flush(ctx)
+}
@@ -0,0 +1,97 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/resilient"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+// FlakyEventStore fails every save with err while it's set.
+type flakyEventStore struct {
+	lock   sync.Mutex
+	err    error
+	clicks []shorty.Click
+}
+
+func (f *flakyEventStore) SaveClicks(ctx context.Context, clicks []shorty.Click) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.clicks = append(f.clicks, clicks...)
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("writes buffered events on close", func(t *testing.T) {
+		store := inmem.NewStore()
+		recorder := NewRecorder(store, Opts{FlushInterval: time.Hour})
+
+		recorder.Record(shorty.Click{Code: "abc123"})
+		recorder.Record(shorty.Click{Code: "xyz789"})
+		testutil.AssertEqual(t, len(store.Clicks), 0)
+
+		if err := recorder.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// Closing again is harmless
+		if err := recorder.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(store.Clicks), 2)
+		testutil.AssertEqual(t, store.Clicks[1].Code, "xyz789")
+	})
+
+	t.Run("drops a batch whose write fails", func(t *testing.T) {
+		store := &flakyEventStore{err: errors.New("store unavailable")}
+		recorder := NewRecorder(store, Opts{FlushInterval: time.Hour})
+
+		recorder.Record(shorty.Click{Code: "a"})
+		recorder.Record(shorty.Click{Code: "b"})
+		if err := recorder.Flush(ctx); err == nil {
+			t.Fatal("want error from failing store")
+		}
+
+		store.lock.Lock()
+		store.err = nil
+		store.lock.Unlock()
+		recorder.Record(shorty.Click{Code: "c"})
+		if err := recorder.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(store.clicks), 1)
+		testutil.AssertEqual(t, store.clicks[0].Code, "c")
+	})
+
+	t.Run("keeps at most MaxBuffered events while the circuit is open", func(t *testing.T) {
+		store := &flakyEventStore{err: resilient.ErrCircuitOpen}
+		recorder := NewRecorder(store, Opts{FlushInterval: time.Hour, MaxPending: 2, MaxBuffered: 3})
+
+		for _, code := range []string{"a", "b", "c", "d"} {
+			recorder.Record(shorty.Click{Code: code})
+		}
+		if err := recorder.Flush(ctx); err == nil {
+			t.Fatal("want error from failing store")
+		}
+
+		store.lock.Lock()
+		store.err = nil
+		store.lock.Unlock()
+		if err := recorder.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(store.clicks), 3)
+		// The newest events are dropped
+		testutil.AssertEqual(t, store.clicks[2].Code, "c")
+	})
+}
@@ -0,0 +1,146 @@
+package clicks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+	"github.com/operationspark/shorty/shorty"
+)
+
+type (
+	// MissStore persists tallies of requests for unknown codes.
+	MissStore interface {
+		RecordMisses(ctx context.Context, tallies []shorty.MissTally) error
+	}
+
+	// MissRecorder tallies requests for unknown codes by code and referrer, and writes the tallies
+	// to the store in batches, like Counter. A scan for made up codes is a handful of bulk writes, not one per request.
+	//
+	// At most MaxBuffered code and referrer pairs are kept between flushes. Misses of new pairs beyond that are dropped.
+	// A batch whose write fails is dropped as well, since retrying it could count its misses twice.
+	MissRecorder struct {
+		store        MissStore
+		maxPending   int
+		maxBuffered  int
+		flushTimeout time.Duration
+
+		lock    sync.Mutex
+		pending map[missKey]*shorty.MissTally
+		// Misses since the last flush
+		total int
+		// Misses dropped since the last successful flush
+		dropped int
+		failing bool
+
+		full chan struct{}
+		stop chan struct{}
+		done chan struct{}
+		once sync.Once
+	}
+
+	missKey struct {
+		code, referrer string
+	}
+)
+
+// NewMissRecorder creates a MissRecorder and starts its background flush loop. Call Close to stop it.
+// o.MaxBuffered defaults to 10 times MaxPending.
+func NewMissRecorder(store MissStore, o Opts) *MissRecorder {
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 5 * time.Second
+	}
+	if o.MaxPending <= 0 {
+		o.MaxPending = 1000
+	}
+	if o.MaxBuffered < o.MaxPending {
+		o.MaxBuffered = 10 * o.MaxPending
+	}
+	if o.FlushTimeout <= 0 {
+		o.FlushTimeout = 10 * time.Second
+	}
+
+	r := &MissRecorder{
+		store:        store,
+		maxPending:   o.MaxPending,
+		maxBuffered:  o.MaxBuffered,
+		flushTimeout: o.FlushTimeout,
+		pending:      map[missKey]*shorty.MissTally{},
+		full:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go runFlushLoop(o.FlushInterval, o.FlushTimeout, r.full, r.stop, r.done, r.Flush, "flush misses")
+	return r
+}
+
+// Record buffers a miss. It never blocks on the store.
+func (r *MissRecorder) Record(m shorty.Miss) {
+	r.lock.Lock()
+	key := missKey{m.Code, m.Referrer}
+	if tally, ok := r.pending[key]; ok {
+		tally.Add(shorty.NewMissTally(m))
+	} else if len(r.pending) < r.maxBuffered {
+		tally := shorty.NewMissTally(m)
+		r.pending[key] = &tally
+	} else {
+		r.dropped++
+		r.lock.Unlock()
+		return
+	}
+	r.total++
+	full := r.total >= r.maxPending && !r.failing
+	r.lock.Unlock()
+
+	if full {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all buffered misses to the store. On failure they are dropped.
+func (r *MissRecorder) Flush(ctx context.Context) error {
+	r.lock.Lock()
+	batch := make([]shorty.MissTally, 0, len(r.pending))
+	for _, tally := range r.pending {
+		batch = append(batch, *tally)
+	}
+	total := r.total
+	r.pending = map[missKey]*shorty.MissTally{}
+	r.total = 0
+	r.lock.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := r.store.RecordMisses(ctx, batch)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failing = err != nil
+	if err != nil {
+		return fmt.Errorf("dropped %d misses: %v", total, err)
+	}
+	if r.dropped > 0 {
+		log.Println(gcp.LogEntry{
+			Severity:  "WARNING",
+			Message:   fmt.Sprintf("dropped %d misses of codes beyond the buffer limit", r.dropped),
+			Component: "clicks",
+		})
+		r.dropped = 0
+	}
+	return nil
+}
+
+// Close stops the flush loop and writes any remaining misses.
+func (r *MissRecorder) Close(ctx context.Context) error {
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+	return r.Flush(ctx)
+}
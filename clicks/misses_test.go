@@ -0,0 +1,79 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+// FlakyMissStore fails every write while fail is set.
+type flakyMissStore struct {
+	*inmem.Store
+	lock sync.Mutex
+	fail bool
+}
+
+func (f *flakyMissStore) RecordMisses(ctx context.Context, tallies []shorty.MissTally) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.fail {
+		return errors.New("store unavailable")
+	}
+	return f.Store.RecordMisses(ctx, tallies)
+}
+
+func TestMissRecorder(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2023, 10, 18, 15, 4, 5, 0, time.UTC)
+
+	t.Run("writes tallies by code and referrer on close", func(t *testing.T) {
+		store := inmem.NewStore()
+		recorder := NewMissRecorder(store, Opts{FlushInterval: time.Hour})
+
+		recorder.Record(shorty.Miss{Code: "fal-cohort", At: at})
+		recorder.Record(shorty.Miss{Code: "fal-cohort", At: at.Add(time.Hour)})
+		recorder.Record(shorty.Miss{Code: "fal-cohort", Referrer: "https://operationspark.org/events", At: at})
+
+		if err := recorder.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		top, _ := store.TopMisses(ctx, shorty.MissQuery{Since: at})
+		testutil.AssertEqual(t, len(top), 1)
+		testutil.AssertEqual(t, top[0].Misses, 3)
+		testutil.AssertEqual(t, top[0].LastSeen, at.Add(time.Hour))
+		testutil.AssertEqual(t, top[0].Referrers[0], shorty.StatsCount{Value: "", Clicks: 2})
+	})
+
+	t.Run("keeps at most MaxBuffered codes and referrers", func(t *testing.T) {
+		store := &flakyMissStore{Store: inmem.NewStore(), fail: true}
+		recorder := NewMissRecorder(store, Opts{FlushInterval: time.Hour, MaxPending: 2, MaxBuffered: 2})
+
+		// A failed flush is dropped, and stops early flushes until the store recovers
+		recorder.Record(shorty.Miss{Code: "lost", At: at})
+		if err := recorder.Flush(ctx); err == nil {
+			t.Fatal("want error from failing store")
+		}
+		for _, code := range []string{"a", "b", "c", "a"} {
+			recorder.Record(shorty.Miss{Code: code, At: at})
+		}
+
+		store.lock.Lock()
+		store.fail = false
+		store.lock.Unlock()
+		if err := recorder.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		top, _ := store.TopMisses(ctx, shorty.MissQuery{Since: at})
+		testutil.AssertEqual(t, len(top), 2)
+		// Known pairs are still counted once the buffer is full
+		testutil.AssertEqual(t, top[0].Code, "a")
+		testutil.AssertEqual(t, top[0].Misses, 2)
+		testutil.AssertEqual(t, top[1].Code, "b")
+	})
+}
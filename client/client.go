@@ -0,0 +1,362 @@
+// Package client is a Go client for the shortener's HTTP API.
+//
+//	c := client.New("https://ospk.org", os.Getenv("SHORTY_API_KEY"))
+//	link, err := c.CreateLink(ctx, shorty.Link{OriginalUrl: "https://operationspark.org"})
+//	if errors.Is(err, shorty.ErrCodeInUse) {
+//		...
+//	}
+//
+// Error responses are returned as *Error, which matches the sentinel errors in the shorty package with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// ErrUnauthorized is returned when the service rejects the API key.
+var ErrUnauthorized = errors.New("invalid API key")
+
+type (
+	// Client calls the shortener API. The zero value isn't usable; set BaseURL, or use New.
+	Client struct {
+		// Service URL, ex: https://ospk.org
+		BaseURL string
+		// Sent in the "key" header of every request
+		APIKey string
+		// Defaults to http.DefaultClient
+		HTTPClient *http.Client
+	}
+
+	// Error is an error response from the service.
+	Error struct {
+		StatusCode int
+		// Response body sent by the service
+		Message string
+		// Sentinel error matching the response, ex: shorty.ErrLinkNotFound for a 404. May be nil.
+		Err error
+	}
+
+	// StatsOpts select the range and interval of ClickStats. Empty values use the service's defaults.
+	StatsOpts struct {
+		From time.Time
+		To   time.Time
+		// "hour", "day", or "week". Defaults to "day".
+		Interval string
+		// Count clicks from bots and link previews too.
+		IncludeBots bool
+	}
+
+	// MissOpts select the misses listed by TopMisses. Empty values use the service's defaults.
+	MissOpts struct {
+		// Only codes missed since then. Defaults to 30 days ago.
+		Since time.Time
+		// Defaults to 50.
+		Limit int
+	}
+
+	// ImportOpts are the query parameters of an import. Empty values use the service's defaults.
+	ImportOpts struct {
+		// linkio format, ex: "csv". Defaults to "jsonl".
+		Format string
+		// Shortener the links were exported from, ex: "bitly". Defaults to "shorty".
+		Source string
+		// "fail", "skip" or "upsert". Defaults to "fail".
+		Conflict string
+		// Report what the import would do without writing anything.
+		DryRun bool
+	}
+
+	// ImportResult is the outcome of an import, counting each row's status.
+	ImportResult struct {
+		DryRun  bool `json:"dryRun,omitempty"`
+		Created int  `json:"created"`
+		Updated int  `json:"updated"`
+		Skipped int  `json:"skipped"`
+		Failed  int  `json:"failed"`
+		// Rows whose code was already in use
+		Conflicts int         `json:"conflicts"`
+		Rows      []ImportRow `json:"rows"`
+		// Rows left out of Rows because the service lists at most 1000.
+		OmittedRows int `json:"omittedRows,omitempty"`
+	}
+
+	// ImportRow is the outcome of one imported row.
+	ImportRow struct {
+		Row  int    `json:"row"`
+		Code string `json:"code,omitempty"`
+		// One of "created", "updated", "skipped", "conflict", or "error".
+		Status   string `json:"status"`
+		Error    string `json:"error,omitempty"`
+		Conflict bool   `json:"conflict,omitempty"`
+	}
+)
+
+// New returns a Client for the service at baseURL.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: baseURL, APIKey: apiKey}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CreateLink shortens link.OriginalUrl, using link.CustomCode as the code when set.
+func (c *Client) CreateLink(ctx context.Context, link shorty.Link) (shorty.Link, error) {
+	var created shorty.Link
+	err := c.doJSON(ctx, http.MethodPost, "/api/urls", link, &created)
+	return created, err
+}
+
+// GetLink returns the link with code.
+func (c *Client) GetLink(ctx context.Context, code string) (shorty.Link, error) {
+	var link shorty.Link
+	err := c.doJSON(ctx, http.MethodGet, linkPath(code), nil, &link)
+	return link, err
+}
+
+// ListLinks returns every link.
+func (c *Client) ListLinks(ctx context.Context) (shorty.Links, error) {
+	var links shorty.Links
+	err := c.doJSON(ctx, http.MethodGet, "/api/urls", nil, &links)
+	return links, err
+}
+
+// UpdateLink changes the link with code. Only CustomCode and OriginalUrl are updated, when set, and Private when opts.Private is set.
+func (c *Client) UpdateLink(ctx context.Context, code string, link shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	// Private is only sent when it's changing, so it isn't reset by every update
+	body := struct {
+		CustomCode  string `json:"customCode,omitempty"`
+		OriginalUrl string `json:"originalUrl,omitempty"`
+		Private     *bool  `json:"private,omitempty"`
+	}{link.CustomCode, link.OriginalUrl, opts.Private}
+	var updated shorty.Link
+	err := c.doJSON(ctx, http.MethodPut, linkPath(code), body, &updated)
+	return updated, err
+}
+
+// DeleteLink deletes the link with code and returns the number of links deleted.
+func (c *Client) DeleteLink(ctx context.Context, code string) (int, error) {
+	res, err := c.do(ctx, http.MethodDelete, linkPath(code), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("read: %v", err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected response %q", b)
+	}
+	return count, nil
+}
+
+// ClickStats returns the clicks on the link with code over time, and their top sources.
+func (c *Client) ClickStats(ctx context.Context, code string, opts StatsOpts) (shorty.ClickStats, error) {
+	var stats shorty.ClickStats
+	query := url.Values{}
+	if !opts.From.IsZero() {
+		query.Set("from", opts.From.Format(time.RFC3339))
+	}
+	if !opts.To.IsZero() {
+		query.Set("to", opts.To.Format(time.RFC3339))
+	}
+	if len(opts.Interval) > 0 {
+		query.Set("interval", opts.Interval)
+	}
+	if opts.IncludeBots {
+		query.Set("bots", "true")
+	}
+	res, err := c.do(ctx, http.MethodGet, linkPath(code)+"/stats", query, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		return stats, fmt.Errorf("decode: %v", err)
+	}
+	return stats, nil
+}
+
+// TopMisses returns the most requested codes that have no link.
+func (c *Client) TopMisses(ctx context.Context, opts MissOpts) ([]shorty.MissCount, error) {
+	var misses []shorty.MissCount
+	query := url.Values{}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	res, err := c.do(ctx, http.MethodGet, "/api/misses", query, nil)
+	if err != nil {
+		return misses, err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(&misses); err != nil {
+		return misses, fmt.Errorf("decode: %v", err)
+	}
+	return misses, nil
+}
+
+// Export streams every link in format. The caller must close the returned body.
+func (c *Client) Export(ctx context.Context, format string) (io.ReadCloser, error) {
+	query := url.Values{}
+	if len(format) > 0 {
+		query.Set("format", format)
+	}
+	res, err := c.do(ctx, http.MethodGet, "/api/urls/export", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Import creates a link for each row read from r.
+// When the service stops the import, at a conflict, an unreadable row or the body size limit, the result is returned with an *Error.
+func (c *Client) Import(ctx context.Context, r io.Reader, opts ImportOpts) (ImportResult, error) {
+	var result ImportResult
+	query := url.Values{}
+	for k, v := range map[string]string{"format": opts.Format, "source": opts.Source, "conflict": opts.Conflict} {
+		if len(v) > 0 {
+			query.Set(k, v)
+		}
+	}
+	if opts.DryRun {
+		query.Set("dryRun", "true")
+	}
+
+	res, err := c.send(ctx, http.MethodPost, "/api/urls/import", query, r)
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+	// Stopped imports still report the rows before the stop
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge:
+	default:
+		return result, errorResponse(res)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return result, fmt.Errorf("read: %v", err)
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		// A 400 for bad options is plain text
+		if res.StatusCode != http.StatusOK {
+			return result, newError(res.StatusCode, string(b))
+		}
+		return result, fmt.Errorf("decode: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return result, newError(res.StatusCode, "import stopped")
+	}
+	return result, nil
+}
+
+// DoJSON sends in as the JSON body, when not nil, and decodes the response into out.
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("marshal: %v", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	res, err := c.do(ctx, method, path, nil, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+	return nil
+}
+
+// Do sends a request and returns the response if its status is 2xx.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	res, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, errorResponse(res)
+	}
+	return res, nil
+}
+
+// Send sends a request whatever the response status.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := strings.TrimRight(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, fmt.Errorf("newRequest: %v", err)
+	}
+	req.Header.Set("key", c.APIKey)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+// ErrorResponse reads and closes an error response's body.
+func errorResponse(res *http.Response) error {
+	defer res.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	return newError(res.StatusCode, string(b))
+}
+
+// NewError matches a response to a sentinel error by its status, and for 400s, its message.
+func newError(status int, message string) *Error {
+	e := &Error{StatusCode: status, Message: strings.TrimSpace(message)}
+	switch status {
+	case http.StatusUnauthorized:
+		e.Err = ErrUnauthorized
+	case http.StatusNotFound:
+		e.Err = shorty.ErrLinkNotFound
+	case http.StatusConflict:
+		e.Err = shorty.ErrCodeInUse
+	case http.StatusUnprocessableEntity:
+		e.Err = shorty.ErrInvalidLink
+	case http.StatusBadRequest:
+		switch {
+		case strings.Contains(e.Message, shorty.ErrJSONUnmarshal.Error()):
+			e.Err = shorty.ErrJSONUnmarshal
+		case strings.Contains(e.Message, "is relative"):
+			e.Err = shorty.ErrRelativeURL
+		case strings.Contains(e.Message, "Invalid URL"):
+			e.Err = shorty.ErrInvalidURL
+		case strings.Contains(e.Message, shorty.ErrInvalidStatsQuery.Error()):
+			e.Err = shorty.ErrInvalidStatsQuery
+		}
+	}
+	return e
+}
+
+func linkPath(code string) string {
+	return "/api/urls/" + url.PathEscape(code)
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	store := inmem.NewStore()
+	server := httptest.NewServer(handlers.NewServer(handlers.NewAPIService(handlers.ServiceConfig{
+		Store:      store,
+		ClickStore: store,
+		MissStore:  store,
+		BaseURL:    "https://ospk.org",
+		APIkey:     "test-api-key",
+	})))
+	t.Cleanup(server.Close)
+	return New(server.URL, "test-api-key")
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("creates, reads, updates, and deletes links", func(t *testing.T) {
+		c := newTestClient(t)
+
+		created, err := c.CreateLink(ctx, shorty.Link{OriginalUrl: "https://operationspark.org", CustomCode: "abc123"})
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, created.ShortURL, "https://ospk.org/abc123")
+
+		got, err := c.GetLink(ctx, "abc123")
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, got.OriginalUrl, "https://operationspark.org")
+
+		updated, err := c.UpdateLink(ctx, "abc123", shorty.Link{OriginalUrl: "https://ospk.org"}, shorty.UpdateOpts{})
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, updated.OriginalUrl, "https://ospk.org")
+
+		links, err := c.ListLinks(ctx)
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, len(links), 1)
+
+		count, err := c.DeleteLink(ctx, "abc123")
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, count, 1)
+	})
+
+	t.Run("maps error responses to sentinel errors", func(t *testing.T) {
+		c := newTestClient(t)
+		if _, err := c.CreateLink(ctx, shorty.Link{OriginalUrl: "https://operationspark.org", CustomCode: "abc123"}); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := c.GetLink(ctx, "missing")
+		assertIs(t, err, shorty.ErrLinkNotFound)
+
+		_, err = c.DeleteLink(ctx, "missing")
+		assertIs(t, err, shorty.ErrLinkNotFound)
+
+		_, err = c.CreateLink(ctx, shorty.Link{OriginalUrl: "https://ospk.org", CustomCode: "abc123"})
+		assertIs(t, err, shorty.ErrCodeInUse)
+
+		_, err = c.CreateLink(ctx, shorty.Link{OriginalUrl: "ospk.org"})
+		assertIs(t, err, shorty.ErrRelativeURL)
+
+		_, err = New(c.BaseURL, "wrong-key").ListLinks(ctx)
+		assertIs(t, err, ErrUnauthorized)
+
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("got %T, want *Error", err)
+		}
+		testutil.AssertEqual(t, apiErr.StatusCode, 401)
+		testutil.AssertEqual(t, apiErr.Message, "Invalid API key")
+	})
+
+	t.Run("gets click stats", func(t *testing.T) {
+		c := newTestClient(t)
+		if _, err := c.CreateLink(ctx, shorty.Link{OriginalUrl: "https://operationspark.org", CustomCode: "abc123"}); err != nil {
+			t.Fatal(err)
+		}
+		from := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+
+		stats, err := c.ClickStats(ctx, "abc123", StatsOpts{From: from, To: from.AddDate(0, 0, 7)})
+
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, stats.Interval, "day")
+		testutil.AssertEqual(t, len(stats.Series), 7)
+
+		_, err = c.ClickStats(ctx, "abc123", StatsOpts{Interval: "month"})
+		assertIs(t, err, shorty.ErrInvalidStatsQuery)
+	})
+
+	t.Run("lists missed codes", func(t *testing.T) {
+		c := newTestClient(t)
+		request, _ := http.NewRequest(http.MethodGet, c.BaseURL+"/fal-cohort", nil)
+		request.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0")
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		misses, err := c.TopMisses(ctx, MissOpts{Limit: 10})
+
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, len(misses), 1)
+		testutil.AssertEqual(t, misses[0].Code, "fal-cohort")
+	})
+
+	t.Run("imports and exports links", func(t *testing.T) {
+		c := newTestClient(t)
+		body := "code,originalUrl\nabc123,https://operationspark.org\nabc123,https://ospk.org\n"
+
+		result, err := c.Import(ctx, strings.NewReader(body), ImportOpts{Format: "csv"})
+
+		assertIs(t, err, shorty.ErrCodeInUse)
+		testutil.AssertEqual(t, result.Created, 1)
+		testutil.AssertEqual(t, result.Rows[1].Status, "conflict")
+
+		export, err := c.Export(ctx, "csv")
+		testutil.AssertEqual(t, err, nil)
+		defer export.Close()
+		b, _ := io.ReadAll(export)
+		testutil.AssertContains(t, string(b), "abc123,abc123,https://ospk.org/abc123,https://operationspark.org")
+	})
+
+	t.Run("counts the rows the import result leaves out", func(t *testing.T) {
+		c := newTestClient(t)
+		body := "originalUrl\n" + strings.Repeat("https://operationspark.org\n", 1005)
+
+		result, err := c.Import(ctx, strings.NewReader(body), ImportOpts{Format: "csv", DryRun: true})
+
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, result.Created, 1005)
+		testutil.AssertEqual(t, len(result.Rows), 1000)
+		testutil.AssertEqual(t, result.OmittedRows, 5)
+	})
+
+	t.Run("returns the import result when the body is too large", func(t *testing.T) {
+		c := newTestClient(t)
+		body := "code,originalUrl\nabc123,https://operationspark.org/" + strings.Repeat("a", 33<<20) + "\n"
+
+		result, err := c.Import(ctx, strings.NewReader(body), ImportOpts{Format: "csv"})
+
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("want *Error, got %v", err)
+		}
+		testutil.AssertEqual(t, apiErr.StatusCode, http.StatusRequestEntityTooLarge)
+		testutil.AssertEqual(t, result.Failed, 1)
+	})
+}
+
+func assertIs(t testing.TB, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("got %v, want %v", err, target)
+	}
+}
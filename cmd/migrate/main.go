@@ -0,0 +1,93 @@
+// Command migrate lists and applies MongoDB schema migrations.
+//
+//	go run ./cmd/migrate            apply pending migrations
+//	go run ./cmd/migrate -list      show every migration and whether it has been applied
+//	go run ./cmd/migrate -dry-run   show the migrations that would be applied
+//	go run ./cmd/migrate -fold-click-shards   move sharded click counts back into links, before turning MONGO_CLICK_SHARDS off
+//
+// The database is configured with the same MONGO_* environment variables as the service.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/operationspark/shorty/mongodb"
+)
+
+func main() {
+	list := flag.Bool("list", false, "list migrations and their status")
+	dryRun := flag.Bool("dry-run", false, "show pending migrations without applying them")
+	fold := flag.Bool("fold-click-shards", false, "move sharded click counts back into links. Safe to re-run if interrupted")
+	timeout := flag.Duration("timeout", 10*time.Minute, "time allowed for all migrations")
+	flag.Parse()
+
+	opts, err := mongodb.OptsFromEnv()
+	if err != nil {
+		log.Fatalf("mongodb.OptsFromEnv: %v\n", err)
+	}
+	store, err := mongodb.NewStore(opts)
+	if err != nil {
+		log.Fatalf("mongodb.NewStore: %v\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	defer store.Client.Disconnect(context.Background())
+
+	if *fold {
+		folded, err := store.FoldClickShards(ctx)
+		if err != nil {
+			log.Fatalf("store.FoldClickShards: %v\n", err)
+		}
+		fmt.Printf("Folded %d click(s).\n", folded)
+		return
+	}
+
+	if *list {
+		statuses, err := store.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("store.MigrationStatus: %v\n", err)
+		}
+		printStatuses(statuses)
+		return
+	}
+
+	ran, err := store.Migrate(ctx, *dryRun)
+	printStatuses(ran)
+	if err != nil {
+		log.Fatalf("store.Migrate: %v\n", err)
+	}
+	switch {
+	case len(ran) == 0:
+		fmt.Println("Database is up to date.")
+	case *dryRun:
+		fmt.Printf("%d migration(s) pending.\n", len(ran))
+	default:
+		fmt.Printf("Applied %d migration(s).\n", len(ran))
+	}
+}
+
+func printStatuses(statuses []mongodb.MigrationStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		switch {
+		case s.Unknown:
+			applied = s.AppliedAt.Format(time.RFC3339) + " (unknown to this version)"
+		case !s.AppliedAt.IsZero():
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+}
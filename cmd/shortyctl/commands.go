@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/client"
+	"github.com/operationspark/shorty/shorty"
+)
+
+func runCreate(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("create [-code CODE] [-private] URL")
+	code := fs.String("code", "", "custom code. Generated when not set")
+	private := fs.Bool("private", false, "never suggest the link on the not found page")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	link, err := c.api.CreateLink(ctx, shorty.Link{OriginalUrl: fs.Arg(0), CustomCode: *code, Private: *private})
+	if err != nil {
+		return err
+	}
+	return c.printLink(link)
+}
+
+func runGet(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("get CODE")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	link, err := c.api.GetLink(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.printLink(link)
+}
+
+func runList(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("list")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	links, err := c.api.ListLinks(ctx)
+	if err != nil {
+		return err
+	}
+	all := make([]shorty.Link, len(links))
+	for i, l := range links {
+		all[i] = *l
+	}
+	return c.printLinks(all)
+}
+
+func runUpdate(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("update [-code NEW] [-url URL] [-private=true|false] CODE")
+	code := fs.String("code", "", "new code")
+	originalURL := fs.String("url", "", "new URL")
+	private := fs.Bool("private", false, "whether to keep the link off the not found page. Unchanged when not set")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	// Only a -private given on the command line changes the link
+	var opts shorty.UpdateOpts
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "private" {
+			opts.Private = private
+		}
+	})
+	if len(*code) == 0 && len(*originalURL) == 0 && opts.Private == nil {
+		fs.Usage()
+		return errUsage
+	}
+
+	link, err := c.api.UpdateLink(ctx, fs.Arg(0), shorty.Link{CustomCode: *code, OriginalUrl: *originalURL}, opts)
+	if err != nil {
+		return err
+	}
+	return c.printLink(link)
+}
+
+func runDelete(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("delete CODE")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	count, err := c.api.DeleteLink(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if c.output == outputJSON {
+		return c.printJSON(map[string]int{"deleted": count})
+	}
+	fmt.Fprintf(c.stdout, "Deleted %q.\n", fs.Arg(0))
+	return nil
+}
+
+func runStats(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("stats [-from TIME] [-to TIME] [-interval hour|day|week] [-bots] CODE")
+	from := fs.String("from", "", "start of the range, as a date or RFC 3339 time. Defaults to a range that suits the interval")
+	to := fs.String("to", "", "end of the range. Defaults to now")
+	interval := fs.String("interval", "", "bucket size: hour, day, or week. Defaults to day")
+	includeBots := fs.Bool("bots", false, "count clicks from bots and link previews too")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	opts := client.StatsOpts{Interval: *interval, IncludeBots: *includeBots}
+	var err error
+	if opts.From, err = parseTimeFlag(*from); err != nil {
+		return fmt.Errorf("-from: %v", err)
+	}
+	if opts.To, err = parseTimeFlag(*to); err != nil {
+		return fmt.Errorf("-to: %v", err)
+	}
+	stats, err := c.api.ClickStats(ctx, fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	return c.printStats(stats)
+}
+
+func runMisses(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("misses [-since TIME] [-limit N]")
+	since := fs.String("since", "", "only codes missed since then, as a date or RFC 3339 time. Defaults to 30 days ago")
+	limit := fs.Int("limit", 0, "number of codes to list. Defaults to 50")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	opts := client.MissOpts{Limit: *limit}
+	var err error
+	if opts.Since, err = parseTimeFlag(*since); err != nil {
+		return fmt.Errorf("-since: %v", err)
+	}
+	misses, err := c.api.TopMisses(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return c.printMisses(misses)
+}
+
+func runExport(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("export [-format jsonl|csv|netlify|nginx|html] [-file PATH]")
+	format := fs.String("format", "jsonl", "export format")
+	file := fs.String("file", "", "file to write. Defaults to stdout")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	body, err := c.api.Export(ctx, *format)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if len(*file) == 0 {
+		_, err = io.Copy(c.stdout, body)
+		return err
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func runImport(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("import [-format jsonl|csv|json] [-source shorty|bitly|yourls] [-conflict fail|skip|upsert] [-dry-run] FILE")
+	format := fs.String("format", "", "file format. Defaults to the file extension, then jsonl")
+	source := fs.String("source", "", "shortener the file was exported from. Defaults to shorty")
+	conflict := fs.String("conflict", "", "what to do with codes already in use. Defaults to fail")
+	dryRun := fs.Bool("dry-run", false, "report what the import would do without writing anything")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	name := fs.Arg(0)
+	var body io.Reader = c.stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	if len(*format) == 0 {
+		*format = formatFromExt(name, *source)
+	}
+	result, err := c.api.Import(ctx, body, client.ImportOpts{Format: *format, Source: *source, Conflict: *conflict, DryRun: *dryRun})
+	// Stopped imports still report the rows before the stop
+	if err == nil || len(result.Rows) > 0 {
+		if perr := c.printImport(result); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+func runConfig(ctx context.Context, c *cli, args []string) error {
+	usage := "config list | set NAME -base-url URL [-api-key KEY] [-default] | use NAME"
+	if len(args) == 0 {
+		fmt.Fprintf(c.stderr, "usage: shortyctl %s\n", usage)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return c.printProfiles()
+	case "set":
+		fs := c.flags("config set NAME -base-url URL [-api-key KEY] [-default]")
+		baseURL := fs.String("base-url", "", "service URL, ex: https://ospk.org")
+		apiKey := fs.String("api-key", "", "API key. Can be left out and set with SHORTY_API_KEY instead")
+		isDefault := fs.Bool("default", false, "use this profile when -profile isn't given")
+		// The name comes first, so flags are parsed after it
+		if len(args) < 2 {
+			fs.Usage()
+			return errUsage
+		}
+		if err := parse(fs, args[2:], 0, 0); err != nil {
+			return err
+		}
+		if len(*baseURL) == 0 {
+			fs.Usage()
+			return errUsage
+		}
+		c.config.Profiles[args[1]] = Profile{BaseURL: *baseURL, APIKey: *apiKey}
+		if *isDefault || len(c.config.Profiles) == 1 {
+			c.config.DefaultProfile = args[1]
+		}
+	case "use":
+		if len(args) != 2 {
+			fmt.Fprintf(c.stderr, "usage: shortyctl config use NAME\n")
+			return errUsage
+		}
+		if _, ok := c.config.Profiles[args[1]]; !ok {
+			return fmt.Errorf("profile %q not found. Profiles: %v", args[1], c.config.profileNames())
+		}
+		c.config.DefaultProfile = args[1]
+	default:
+		fmt.Fprintf(c.stderr, "usage: shortyctl %s\n", usage)
+		return errUsage
+	}
+	return saveConfig(c.configPath, c.config)
+}
+
+// ParseTimeFlag parses a date, which is midnight local time, or an RFC 3339 time.
+func parseTimeFlag(v string) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// FormatFromExt guesses an import format from a file name, leaving the default to the service.
+// Only other shorteners' exports can be JSON documents.
+func formatFromExt(name, source string) string {
+	switch {
+	case strings.HasSuffix(name, ".csv"):
+		return "csv"
+	case strings.HasSuffix(name, ".json") && len(source) > 0 && source != "shorty":
+		return "json"
+	default:
+		return ""
+	}
+}
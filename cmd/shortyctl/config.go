@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type (
+	// Config is the shortyctl config file.
+	Config struct {
+		// Profile used when -profile and SHORTYCTL_PROFILE aren't set
+		DefaultProfile string             `json:"defaultProfile,omitempty"`
+		Profiles       map[string]Profile `json:"profiles"`
+	}
+
+	// Profile is one deployment of the service, ex: staging or production.
+	Profile struct {
+		BaseURL string `json:"baseUrl"`
+		APIKey  string `json:"apiKey,omitempty"`
+	}
+)
+
+// ConfigPath returns SHORTYCTL_CONFIG, or config.json in the user's config directory.
+func configPath(getenv func(string) string) (string, error) {
+	if p := getenv("SHORTYCTL_CONFIG"); len(p) > 0 {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("userConfigDir: %v", err)
+	}
+	return filepath.Join(dir, "shortyctl", "config.json"), nil
+}
+
+// LoadConfig reads the config file. A missing file is an empty config.
+func loadConfig(path string) (Config, error) {
+	c := Config{Profiles: map[string]Profile{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("read config: %v", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("parse %s: %v", path, err)
+	}
+	if c.Profiles == nil {
+		c.Profiles = map[string]Profile{}
+	}
+	return c, nil
+}
+
+// SaveConfig writes the config file, readable only by the user since it holds API keys.
+func saveConfig(path string, c Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create config dir: %v", err)
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config: %v", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write config: %v", err)
+	}
+	return nil
+}
+
+// Resolve returns the profile to use. name is the -profile flag.
+// SHORTY_BASE_URL and SHORTY_API_KEY override the profile's values.
+func (c Config) resolve(name string, getenv func(string) string) (Profile, error) {
+	if len(name) == 0 {
+		name = getenv("SHORTYCTL_PROFILE")
+	}
+	if len(name) == 0 {
+		name = c.DefaultProfile
+	}
+
+	var p Profile
+	if len(name) > 0 {
+		var ok bool
+		if p, ok = c.Profiles[name]; !ok {
+			return p, fmt.Errorf("profile %q not found. Profiles: %v", name, c.profileNames())
+		}
+	}
+	if v := getenv("SHORTY_BASE_URL"); len(v) > 0 {
+		p.BaseURL = v
+	}
+	if v := getenv("SHORTY_API_KEY"); len(v) > 0 {
+		p.APIKey = v
+	}
+	if len(p.BaseURL) == 0 {
+		return p, errors.New("no base URL. Set SHORTY_BASE_URL or add a profile with \"shortyctl config set\"")
+	}
+	return p, nil
+}
+
+func (c Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
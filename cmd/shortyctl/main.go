@@ -0,0 +1,185 @@
+// Command shortyctl manages short links through the service's HTTP API.
+//
+//	shortyctl [-profile NAME] [-output table|json] COMMAND [ARGS]
+//
+//	create [-code CODE] [-private] URL       create a link
+//	get CODE                                 show a link
+//	list                                     show every link
+//	update [-code NEW] [-url URL] [-private=B] CODE   change a link's code, URL, or privacy
+//	delete CODE                              delete a link
+//	stats [-from T] [-to T] [-interval I] [-bots] CODE   show a link's clicks over time
+//	misses [-since TIME] [-limit N]          show the most requested codes that have no link
+//	export [-format F] [-file PATH]          export every link, to stdout by default
+//	import [-format F] [-source S] [-conflict C] [-dry-run] FILE   import links. FILE may be "-" for stdin
+//	config list|set|use                      manage profiles
+//
+// The base URL and API key come from a profile in the config file, ~/.config/shortyctl/config.json by default,
+// or from the SHORTY_BASE_URL and SHORTY_API_KEY environment variables, which override the profile.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/operationspark/shorty/client"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// ErrUsage is returned for bad arguments, after printing usage.
+var errUsage = errors.New("usage")
+
+type (
+	// Env is everything a command needs from the process, so commands can run in tests.
+	env struct {
+		stdin  io.Reader
+		stdout io.Writer
+		stderr io.Writer
+		getenv func(string) string
+	}
+
+	cli struct {
+		env
+		api    *client.Client
+		output string
+		// Only set for the config command
+		configPath string
+		config     Config
+	}
+
+	command struct {
+		name  string
+		usage string
+		run   func(ctx context.Context, c *cli, args []string) error
+		// Commands that work without a profile
+		noProfile bool
+	}
+)
+
+var commands = []command{
+	{name: "create", usage: "create [-code CODE] [-private] URL", run: runCreate},
+	{name: "get", usage: "get CODE", run: runGet},
+	{name: "list", usage: "list", run: runList},
+	{name: "update", usage: "update [-code NEW] [-url URL] [-private=true|false] CODE", run: runUpdate},
+	{name: "delete", usage: "delete CODE", run: runDelete},
+	{name: "stats", usage: "stats [-from TIME] [-to TIME] [-interval hour|day|week] [-bots] CODE", run: runStats},
+	{name: "misses", usage: "misses [-since TIME] [-limit N]", run: runMisses},
+	{name: "export", usage: "export [-format jsonl|csv|netlify|nginx|html] [-file PATH]", run: runExport},
+	{name: "import", usage: "import [-format jsonl|csv|json] [-source shorty|bitly|yourls] [-conflict fail|skip|upsert] [-dry-run] FILE", run: runImport},
+	{name: "config", usage: "config list | set NAME -base-url URL [-api-key KEY] [-default] | use NAME", run: runConfig, noProfile: true},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	e := env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv}
+	err := run(ctx, e, os.Args[1:])
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shortyctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Run parses the global flags and runs the command named in args.
+func run(ctx context.Context, e env, args []string) error {
+	fs := flag.NewFlagSet("shortyctl", flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	profile := fs.String("profile", "", "config profile. Defaults to SHORTYCTL_PROFILE, then the config's defaultProfile")
+	output := fs.String("output", outputTable, "output format: table or json")
+	timeout := fs.Duration("timeout", 30*time.Second, "HTTP request timeout. Imports and exports aren't limited")
+	fs.Usage = func() { printUsage(e.stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintf(e.stderr, "-output: want %q or %q\n", outputTable, outputJSON)
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(e.stderr, "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+
+	path, err := configPath(e.getenv)
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	c := &cli{env: e, output: *output, configPath: path, config: config}
+	if !cmd.noProfile {
+		p, err := config.resolve(*profile, e.getenv)
+		if err != nil {
+			return err
+		}
+		httpClient := &http.Client{Timeout: *timeout}
+		if cmd.name == "import" || cmd.name == "export" {
+			httpClient.Timeout = 0
+		}
+		c.api = &client.Client{BaseURL: p.BaseURL, APIKey: p.APIKey, HTTPClient: httpClient}
+	}
+	return cmd.run(ctx, c, fs.Args()[1:])
+}
+
+// Flags returns a flag set for a command that prints the command's usage on errors.
+func (c *cli) flags(usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(usage, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: shortyctl %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parse parses a command's flags and checks it was given between min and max arguments. max < 0 means no limit.
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: shortyctl [flags] COMMAND [ARGS]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n", cmd.usage)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\nEnvironment: SHORTY_BASE_URL and SHORTY_API_KEY override the profile. SHORTYCTL_CONFIG sets the config file path.")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+// NewTestCLI returns a function that runs shortyctl against a service backed by an in-memory store.
+func newTestCLI(t *testing.T) func(args ...string) (string, error) {
+	t.Helper()
+	server := httptest.NewServer(handlers.NewServer(handlers.NewAPIService(handlers.ServiceConfig{
+		Store:  inmem.NewStore(),
+		APIkey: "test-api-key",
+	})))
+	t.Cleanup(server.Close)
+
+	vars := map[string]string{
+		"SHORTYCTL_CONFIG": filepath.Join(t.TempDir(), "config.json"),
+		"SHORTY_BASE_URL":  server.URL,
+		"SHORTY_API_KEY":   "test-api-key",
+	}
+	return func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		e := env{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr, getenv: func(k string) string { return vars[k] }}
+		err := run(context.Background(), e, args)
+		return stdout.String(), err
+	}
+}
+
+func TestCommands(t *testing.T) {
+	t.Run("creates, updates, and deletes a link", func(t *testing.T) {
+		shortyctl := newTestCLI(t)
+
+		out, err := shortyctl("-output", "json", "create", "-code", "abc123", "https://operationspark.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var link shorty.Link
+		if err := json.Unmarshal([]byte(out), &link); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, link.Code, "abc123")
+
+		out, err = shortyctl("update", "-url", "https://ospk.org", "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertContains(t, out, "https://ospk.org")
+
+		out, err = shortyctl("list")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertContains(t, out, "CODE")
+		testutil.AssertContains(t, out, "abc123")
+
+		if _, err := shortyctl("delete", "abc123"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = shortyctl("get", "abc123")
+		if !errors.Is(err, shorty.ErrLinkNotFound) {
+			t.Errorf("got %v, want %v", err, shorty.ErrLinkNotFound)
+		}
+	})
+
+	t.Run("changes private only when the flag is given", func(t *testing.T) {
+		shortyctl := newTestCLI(t)
+		if _, err := shortyctl("create", "-code", "abc123", "-private", "https://operationspark.org"); err != nil {
+			t.Fatal(err)
+		}
+		update := func(args ...string) shorty.Link {
+			out, err := shortyctl(append([]string{"-output", "json", "update"}, args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var link shorty.Link
+			if err := json.Unmarshal([]byte(out), &link); err != nil {
+				t.Fatal(err)
+			}
+			return link
+		}
+
+		testutil.AssertEqual(t, update("-url", "https://ospk.org", "abc123").Private, true)
+		testutil.AssertEqual(t, update("-private=false", "abc123").Private, false)
+	})
+
+	t.Run("reports import conflicts", func(t *testing.T) {
+		shortyctl := newTestCLI(t)
+		if _, err := shortyctl("create", "-code", "abc123", "https://operationspark.org"); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(t.TempDir(), "links.csv")
+		os.WriteFile(file, []byte("code,originalUrl\nabc123,https://ospk.org\ndef456,https://ospk.org\n"), 0o600)
+
+		out, err := shortyctl("import", "-dry-run", file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertContains(t, out, "Created 1, updated 0, skipped 0, failed 1. 1 code(s) already in use.")
+		testutil.AssertContains(t, out, "abc123  conflict")
+	})
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("uses the default profile, overridden by the environment", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		e := env{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}, getenv: func(k string) string {
+			return map[string]string{"SHORTYCTL_CONFIG": path}[k]
+		}}
+		for _, args := range [][]string{
+			{"config", "set", "staging", "-base-url", "https://staging.ospk.org", "-api-key", "staging-key"},
+			{"config", "set", "production", "-base-url", "https://ospk.org"},
+		} {
+			if err := run(context.Background(), e, args); err != nil {
+				t.Fatal(err)
+			}
+		}
+		config, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The first profile becomes the default
+		p, err := config.resolve("", func(string) string { return "" })
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, p, Profile{BaseURL: "https://staging.ospk.org", APIKey: "staging-key"})
+
+		p, err = config.resolve("production", func(k string) string {
+			return map[string]string{"SHORTY_API_KEY": "production-key"}[k]
+		})
+		testutil.AssertEqual(t, err, nil)
+		testutil.AssertEqual(t, p, Profile{BaseURL: "https://ospk.org", APIKey: "production-key"})
+
+		_, err = config.resolve("dev", func(string) string { return "" })
+		testutil.AssertContains(t, err.Error(), `profile "dev" not found`)
+	})
+}
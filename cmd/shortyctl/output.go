@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/operationspark/shorty/client"
+	"github.com/operationspark/shorty/shorty"
+)
+
+func (c *cli) printLink(l shorty.Link) error {
+	if c.output == outputJSON {
+		return c.printJSON(l)
+	}
+	return c.printLinks([]shorty.Link{l})
+}
+
+func (c *cli) printLinks(links []shorty.Link) error {
+	if c.output == outputJSON {
+		return c.printJSON(links)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tCLICKS\tCREATED\tSHORT URL\tORIGINAL URL")
+	for _, l := range links {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", l.Code, l.TotalClicks, formatDate(l.CreatedAt), l.ShortURL, l.OriginalUrl)
+	}
+	return w.Flush()
+}
+
+// PrintStats shows the clicks in each interval, then the top sources of the clicks.
+func (c *cli) printStats(s shorty.ClickStats) error {
+	if c.output == outputJSON {
+		return c.printJSON(s)
+	}
+
+	fmt.Fprintf(c.stdout, "%d clicks by about %d visitors on %s from %s to %s.\n\n", s.Total, s.UniqueVisitors, s.Code, formatDate(s.From), formatDate(s.To))
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tCLICKS\n", strings.ToUpper(s.Interval))
+	for _, b := range s.Series {
+		fmt.Fprintf(w, "%s\t%d\n", formatDate(b.Start), b.Clicks)
+	}
+	for _, top := range []struct {
+		name   string
+		counts []shorty.StatsCount
+	}{
+		{"REFERRER", s.Referrers},
+		{"BROWSER", s.Browsers},
+		{"OS", s.OS},
+		{"DEVICE", s.Devices},
+	} {
+		if len(top.counts) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\tCLICKS\n", top.name)
+		for _, count := range top.counts {
+			value := count.Value
+			if len(value) == 0 {
+				value = "(none)"
+			}
+			fmt.Fprintf(w, "%s\t%d\n", value, count.Clicks)
+		}
+	}
+	return w.Flush()
+}
+
+// PrintMisses shows each missed code with its most common referrer.
+func (c *cli) printMisses(misses []shorty.MissCount) error {
+	if c.output == outputJSON {
+		return c.printJSON(misses)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tMISSES\tLAST SEEN\tTOP REFERRER")
+	for _, m := range misses {
+		referrer := "(none)"
+		if len(m.Referrers) > 0 && len(m.Referrers[0].Value) > 0 {
+			referrer = m.Referrers[0].Value
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", m.Code, m.Misses, formatDate(m.LastSeen), referrer)
+	}
+	return w.Flush()
+}
+
+// PrintImport shows the import counts and the rows that weren't created.
+func (c *cli) printImport(r client.ImportResult) error {
+	if c.output == outputJSON {
+		return c.printJSON(r)
+	}
+
+	if r.DryRun {
+		fmt.Fprintln(c.stdout, "Dry run. Nothing was written.")
+	}
+	fmt.Fprintf(c.stdout, "Created %d, updated %d, skipped %d, failed %d. %d code(s) already in use.\n",
+		r.Created, r.Updated, r.Skipped, r.Failed, r.Conflicts)
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	header := false
+	for _, row := range r.Rows {
+		if row.Status == "created" {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\nROW\tCODE\tSTATUS\tERROR")
+			header = true
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", row.Row, row.Code, row.Status, row.Error)
+	}
+	return w.Flush()
+}
+
+// PrintProfiles lists the configured profiles without their API keys.
+func (c *cli) printProfiles() error {
+	type profile struct {
+		Name    string `json:"name"`
+		BaseURL string `json:"baseUrl"`
+		Default bool   `json:"default"`
+		HasKey  bool   `json:"hasApiKey"`
+	}
+	names := c.config.profileNames()
+	all := make([]profile, len(names))
+	for i, name := range names {
+		p := c.config.Profiles[name]
+		all[i] = profile{Name: name, BaseURL: p.BaseURL, Default: name == c.config.DefaultProfile, HasKey: len(p.APIKey) > 0}
+	}
+	if c.output == outputJSON {
+		return c.printJSON(all)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tBASE URL\tAPI KEY")
+	for _, p := range all {
+		name, key := p.Name, "-"
+		if p.Default {
+			name += " (default)"
+		}
+		if p.HasKey {
+			key = "set"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, p.BaseURL, key)
+	}
+	return w.Flush()
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
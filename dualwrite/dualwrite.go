@@ -0,0 +1,337 @@
+// Package dualwrite composes two LinkStores so links can be moved to a new backend without downtime.
+//
+// Writes go to the primary and then the secondary. Reads are served by whichever store is configured,
+// so cutting over is a config change: backfill the secondary, read from it, then make it the primary.
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+)
+
+// ReadFrom values
+const (
+	ReadPrimary   = "primary"
+	ReadSecondary = "secondary"
+)
+
+type (
+	// Store writes to Primary and Secondary, and reads from one of them.
+	// A failed primary write fails the call. A failed or mismatched secondary write is logged as a divergence.
+	Store struct {
+		Primary   handlers.LinkStore
+		Secondary handlers.LinkStore
+		read      handlers.LinkStore
+		// Set when reads come from the secondary, so writes return the secondary's results when it has them
+		readSecondary bool
+
+		stop chan struct{}
+		done chan struct{}
+		once sync.Once
+	}
+
+	Opts struct {
+		// Store that serves reads, ReadPrimary or ReadSecondary. Defaults to ReadPrimary.
+		ReadFrom string
+		// How often to compare the stores in the background. The verifier doesn't run when 0.
+		VerifyInterval time.Duration
+		// Time allowed for each background comparison. Defaults to 1 minute.
+		VerifyTimeout time.Duration
+	}
+
+	// Report describes the differences found by Verify.
+	Report struct {
+		// Number of distinct codes compared.
+		Checked int `json:"checked"`
+		// Codes only found in the secondary.
+		MissingFromPrimary []string `json:"missingFromPrimary"`
+		// Codes only found in the primary.
+		MissingFromSecondary []string `json:"missingFromSecondary"`
+		// Codes found in both stores with different fields.
+		Mismatched []string `json:"mismatched"`
+	}
+)
+
+// NewStore composes primary and secondary, and starts the background verifier if VerifyInterval is set.
+func NewStore(primary, secondary handlers.LinkStore, o Opts) (*Store, error) {
+	s := &Store{
+		Primary:   primary,
+		Secondary: secondary,
+		read:      primary,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	switch o.ReadFrom {
+	case "", ReadPrimary:
+	case ReadSecondary:
+		s.read = secondary
+		s.readSecondary = true
+	default:
+		return nil, fmt.Errorf("ReadFrom: want %q or %q, got %q", ReadPrimary, ReadSecondary, o.ReadFrom)
+	}
+
+	if o.VerifyInterval <= 0 {
+		close(s.done)
+		return s, nil
+	}
+	if o.VerifyTimeout <= 0 {
+		o.VerifyTimeout = time.Minute
+	}
+	go s.verifyLoop(o.VerifyInterval, o.VerifyTimeout)
+	return s, nil
+}
+
+func (s *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	return s.read.FindLink(ctx, code)
+}
+
+func (s *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
+	return s.read.FindAllLinks(ctx)
+}
+
+func (s *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	return s.read.EachLink(ctx, fn)
+}
+
+func (s *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
+	return s.read.CheckCodeInUse(ctx, code)
+}
+
+func (s *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	return s.read.FindSimilarCodes(ctx, code, maxDistance)
+}
+
+func (s *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	saved, err := s.Primary.SaveLink(ctx, newLink)
+	if err != nil {
+		return saved, err
+	}
+	_, err = s.Secondary.SaveLink(ctx, newLink)
+	if err != nil {
+		logDivergence("SaveLink", newLink.Code, err)
+	}
+	return saved, nil
+}
+
+func (s *Store) UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	updated, err := s.Primary.UpdateLink(ctx, code, toUpdate, opts)
+	if err != nil {
+		return updated, err
+	}
+	secondary, err := s.Secondary.UpdateLink(ctx, code, toUpdate, opts)
+	switch {
+	case err != nil:
+		logDivergence("UpdateLink", code, err)
+	case !Equal(updated, secondary):
+		logDivergence("UpdateLink", code, errors.New("updated links differ"))
+		fallthrough
+	default:
+		if s.readSecondary {
+			return secondary, nil
+		}
+	}
+	return updated, nil
+}
+
+func (s *Store) DeleteLink(ctx context.Context, code string) (int, error) {
+	n, err := s.Primary.DeleteLink(ctx, code)
+	if err != nil {
+		return n, err
+	}
+	secondaryN, err := s.Secondary.DeleteLink(ctx, code)
+	switch {
+	case err != nil:
+		logDivergence("DeleteLink", code, err)
+	case n != secondaryN:
+		logDivergence("DeleteLink", code, fmt.Errorf("primary deleted %d, secondary deleted %d", n, secondaryN))
+	}
+	return n, nil
+}
+
+func (s *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
+	n, err := s.Primary.IncrementTotalClicks(ctx, code)
+	if err != nil {
+		return n, err
+	}
+	secondaryN, err := s.Secondary.IncrementTotalClicks(ctx, code)
+	switch {
+	case err != nil:
+		logDivergence("IncrementTotalClicks", code, err)
+	case n != secondaryN:
+		logDivergence("IncrementTotalClicks", code, fmt.Errorf("primary total %d, secondary total %d", n, secondaryN))
+		fallthrough
+	default:
+		if s.readSecondary {
+			return secondaryN, nil
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	if err := s.Primary.IncrementTotalClicksBatch(ctx, counts); err != nil {
+		return err
+	}
+	if err := s.Secondary.IncrementTotalClicksBatch(ctx, counts); err != nil {
+		logDivergence("IncrementTotalClicksBatch", fmt.Sprintf("%d codes", len(counts)), err)
+	}
+	return nil
+}
+
+// Ping checks both stores, since writes need both to be reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	var errs []error
+	if err := s.Primary.Ping(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("primary: %v", err))
+	}
+	if err := s.Secondary.Ping(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("secondary: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Verify compares every link in the two stores. It merges their EachLink streams, which are both in order of code,
+// so neither store's links are loaded into memory.
+func (s *Store) Verify(ctx context.Context) (Report, error) {
+	var r Report
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	secondary := make(chan shorty.Link)
+	secondaryErr := make(chan error, 1)
+	go func() {
+		defer close(secondary)
+		secondaryErr <- s.Secondary.EachLink(ctx, func(l shorty.Link) error {
+			select {
+			case secondary <- l:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	next, more := <-secondary
+	err := s.Primary.EachLink(ctx, func(l shorty.Link) error {
+		for more && next.Code < l.Code {
+			r.Checked++
+			r.MissingFromPrimary = append(r.MissingFromPrimary, next.Code)
+			next, more = <-secondary
+		}
+		r.Checked++
+		if !more || next.Code != l.Code {
+			r.MissingFromSecondary = append(r.MissingFromSecondary, l.Code)
+			return nil
+		}
+		if !Equal(l, next) {
+			r.Mismatched = append(r.Mismatched, l.Code)
+		}
+		next, more = <-secondary
+		return nil
+	})
+	if err != nil {
+		return r, fmt.Errorf("primary: %v", err)
+	}
+	for more {
+		r.Checked++
+		r.MissingFromPrimary = append(r.MissingFromPrimary, next.Code)
+		next, more = <-secondary
+	}
+	if err := <-secondaryErr; err != nil {
+		return r, fmt.Errorf("secondary: %v", err)
+	}
+	return r, nil
+}
+
+// Consistent reports whether Verify found no differences.
+func (r Report) Consistent() bool {
+	return len(r.MissingFromPrimary) == 0 && len(r.MissingFromSecondary) == 0 && len(r.Mismatched) == 0
+}
+
+// Close stops the background verifier.
+func (s *Store) Close(ctx context.Context) error {
+	s.once.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Equal compares the fields both stores are expected to agree on.
+// UpdatedAt is ignored, since each store sets it with its own clock, and so are the click counts,
+// since the secondary's increments are best effort and clicks keep landing while Verify runs.
+func Equal(a, b shorty.Link) bool {
+	return a.Code == b.Code &&
+		a.CustomCode == b.CustomCode &&
+		a.ShortURL == b.ShortURL &&
+		a.OriginalUrl == b.OriginalUrl &&
+		a.Private == b.Private &&
+		a.CreatedBy == b.CreatedBy &&
+		a.CreatedAt.Equal(b.CreatedAt)
+}
+
+func (s *Store) verifyLoop(interval, timeout time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		r, err := s.Verify(ctx)
+		cancel()
+		switch {
+		case err != nil:
+			log.Println(gcp.LogEntry{
+				Severity:  "WARNING",
+				Message:   "verify stores: " + err.Error(),
+				Component: "dualwrite",
+			})
+		case !r.Consistent():
+			log.Println(gcp.LogEntry{
+				Severity: "WARNING",
+				Message: fmt.Sprintf("stores diverged: %d checked, %d missing from primary, %d missing from secondary, %d mismatched: %v",
+					r.Checked, len(r.MissingFromPrimary), len(r.MissingFromSecondary), len(r.Mismatched), sample(r)),
+				Component: "dualwrite",
+			})
+		default:
+			log.Println(gcp.LogEntry{
+				Message:   fmt.Sprintf("stores consistent: %d checked", r.Checked),
+				Component: "dualwrite",
+			})
+		}
+	}
+}
+
+// Sample returns up to 10 diverged codes for the log.
+func sample(r Report) []string {
+	codes := append(append(append([]string{}, r.MissingFromPrimary...), r.MissingFromSecondary...), r.Mismatched...)
+	if len(codes) > 10 {
+		codes = codes[:10]
+	}
+	return codes
+}
+
+func logDivergence(op, code string, err error) {
+	log.Println(gcp.LogEntry{
+		Severity:  "WARNING",
+		Message:   fmt.Sprintf("divergence: %s %s: secondary: %v", op, code, err),
+		Component: "dualwrite",
+	})
+}
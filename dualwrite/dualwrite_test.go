@@ -0,0 +1,139 @@
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
+)
+
+// DownStore fails every write, like a secondary that can't be reached.
+type downStore struct {
+	*inmem.Store
+}
+
+func (d downStore) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	return shorty.Link{}, errors.New("server selection timeout")
+}
+
+func TestDualWrite(t *testing.T) {
+	ctx := context.Background()
+	link := shorty.Link{Code: "abc123", CustomCode: "abc123", OriginalUrl: "https://operationspark.org"}
+
+	t.Run("writes to both stores", func(t *testing.T) {
+		primary, secondary := inmem.NewStore(), inmem.NewStore()
+		store, err := NewStore(primary, secondary, Opts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.SaveLink(ctx, link); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.IncrementTotalClicks(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range []*inmem.Store{primary, secondary} {
+			got, err := s.FindLink(ctx, "abc123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AssertEqual(t, got.TotalClicks, 1)
+		}
+	})
+
+	t.Run("reads from the configured store", func(t *testing.T) {
+		primary, secondary := inmem.NewStore(), inmem.NewStore()
+		secondary.SaveLink(ctx, link)
+		store, err := NewStore(primary, secondary, Opts{ReadFrom: ReadSecondary})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.FindLink(ctx, "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, got.OriginalUrl, link.OriginalUrl)
+	})
+
+	t.Run("rejects unknown read sides", func(t *testing.T) {
+		_, err := NewStore(inmem.NewStore(), inmem.NewStore(), Opts{ReadFrom: "tertiary"})
+		if err == nil {
+			t.Fatal("want error")
+		}
+	})
+
+	t.Run("succeeds when only the secondary write fails", func(t *testing.T) {
+		primary := inmem.NewStore()
+		store, _ := NewStore(primary, downStore{inmem.NewStore()}, Opts{})
+
+		if _, err := store.SaveLink(ctx, link); err != nil {
+			t.Fatalf("want primary result, got %v", err)
+		}
+		if _, err := primary.FindLink(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("skips the secondary when the primary write fails", func(t *testing.T) {
+		secondary := inmem.NewStore()
+		store, _ := NewStore(downStore{inmem.NewStore()}, secondary, Opts{})
+
+		if _, err := store.SaveLink(ctx, link); err == nil {
+			t.Fatal("want error")
+		}
+		_, err := secondary.FindLink(ctx, "abc123")
+		testutil.AssertEqual(t, err, shorty.ErrLinkNotFound)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := inmem.NewStore(), inmem.NewStore()
+	for _, code := range []string{"aPrimaryOnly", "same", "changed", "private"} {
+		primary.SaveLink(ctx, shorty.Link{Code: code, OriginalUrl: "https://operationspark.org"})
+	}
+	for _, code := range []string{"same", "changed", "private", "secondaryOnly"} {
+		secondary.SaveLink(ctx, shorty.Link{Code: code, OriginalUrl: "https://operationspark.org", Private: code == "private"})
+	}
+	secondary.UpdateLink(ctx, "changed", shorty.Link{OriginalUrl: "https://example.com"}, shorty.UpdateOpts{})
+	// Click counts drift while clicks land, so they aren't compared
+	secondary.IncrementTotalClicks(ctx, "same")
+
+	store, _ := NewStore(primary, secondary, Opts{})
+	r, err := store.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertEqual(t, r.Consistent(), false)
+	testutil.AssertEqual(t, r.Checked, 5)
+	testutil.AssertEqual(t, len(r.Mismatched), 2)
+	testutil.AssertEqual(t, r.Mismatched[0], "changed")
+	testutil.AssertEqual(t, r.Mismatched[1], "private")
+	testutil.AssertEqual(t, len(r.MissingFromSecondary), 1)
+	testutil.AssertEqual(t, r.MissingFromSecondary[0], "aPrimaryOnly")
+	testutil.AssertEqual(t, len(r.MissingFromPrimary), 1)
+	testutil.AssertEqual(t, r.MissingFromPrimary[0], "secondaryOnly")
+}
+
+func TestConformance(t *testing.T) {
+	for _, readFrom := range []string{ReadPrimary, ReadSecondary} {
+		t.Run("reading from "+readFrom, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+				store, err := NewStore(inmem.NewStore(), inmem.NewStore(), Opts{ReadFrom: readFrom})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			})
+		})
+	}
+}
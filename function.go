@@ -2,16 +2,29 @@ package function
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"cloud.google.com/go/errorreporting"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/operationspark/shorty/cache"
+	"github.com/operationspark/shorty/clicks"
+	"github.com/operationspark/shorty/dualwrite"
 	"github.com/operationspark/shorty/handlers"
 	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/metrics"
 	"github.com/operationspark/shorty/mongodb"
+	"github.com/operationspark/shorty/resilient"
+	"github.com/operationspark/shorty/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func init() {
@@ -20,6 +33,10 @@ func init() {
 	// https://cloud.google.com/functions/docs/writing/write-http-functions
 	functions.HTTP("ServeShorty", NewApp().ServeHTTP)
 
+	// Flush buffered clicks when the platform stops the instance
+	signal.Notify(stopSignals, os.Interrupt, syscall.SIGTERM)
+	go shutdownOnSignal(stopSignals)
+
 	// Disable log prefixes such as the default timestamp.
 	// Prefix text prevents the message from being parsed as JSON.
 	// A timestamp is added when shipping logs to Cloud Logging.
@@ -29,7 +46,20 @@ func init() {
 var store handlers.LinkStore
 var errorClient *errorreporting.Client
 
-func NewApp() *http.ServeMux {
+// ShutdownFuncs are called in reverse order by Shutdown to flush and release resources.
+var shutdownFuncs []func(context.Context) error
+var shutdownLock sync.Mutex
+
+// ShutdownTimeout bounds Shutdown after a stop signal.
+// Cloud Functions (2nd gen) send SIGTERM and allow 10 seconds before the instance is killed.
+const shutdownTimeout = 8 * time.Second
+
+var stopSignals = make(chan os.Signal, 1)
+
+// Exit is replaced in tests so a signal doesn't end the test binary.
+var exit = os.Exit
+
+func NewApp() http.Handler {
 	// Avoid variable shadow for errorClient
 	var err error
 	errorClient, err = initErrorReporting()
@@ -37,7 +67,12 @@ func NewApp() *http.ServeMux {
 		log.Fatalf("initErrorReporting: %v", err)
 	}
 
-	store, err := initStore()
+	tracerProvider, err := initTracing()
+	if err != nil {
+		log.Fatalf("initTracing: %v", err)
+	}
+
+	store, events, err := initStore(tracerProvider)
 	if err != nil {
 		errorClient.Report(errorreporting.Entry{
 			Error: fmt.Errorf("initStore: %v", err),
@@ -45,35 +80,208 @@ func NewApp() *http.ServeMux {
 		log.Fatalf("Could not start: %v", err)
 	}
 
+	// Trace and time store calls beneath the cache, so the spans and latencies are the database's
+	if tracerProvider != nil {
+		store = tracing.NewStore(store, tracerProvider)
+	}
+	reg := metrics.NewRegistry()
+	store = metrics.NewStore(store, reg)
+
+	// Cache link lookups when CACHE_SIZE is set. This is off by default because writes on
+	// one instance don't invalidate other instances' caches until CACHE_TTL expires.
+	if cacheSize, _ := strconv.Atoi(os.Getenv("CACHE_SIZE")); cacheSize > 0 {
+		cacheTTL, _ := time.ParseDuration(os.Getenv("CACHE_TTL"))
+		cached := cache.NewStore(store, cache.Opts{Size: cacheSize, TTL: cacheTTL})
+		metrics.RegisterCache(reg, cached)
+		store = cached
+	}
+
 	baseURL := os.Getenv("HOST_BASE_URL")
 	apiKey := os.Getenv("API_KEY")
 
+	// Buffer click counts so redirects don't wait on a database write
+	flushInterval, _ := time.ParseDuration(os.Getenv("CLICK_FLUSH_INTERVAL"))
+	clickCounter := clicks.NewCounter(store, clicks.Opts{FlushInterval: flushInterval})
+	addShutdownFunc(clickCounter.Close)
+
+	// Click events and misses are buffered the same way. Added after the counter so they're flushed first on shutdown.
+	clickRecorder := clicks.NewRecorder(events, clicks.Opts{FlushInterval: flushInterval})
+	addShutdownFunc(clickRecorder.Close)
+	missRecorder := clicks.NewMissRecorder(events, clicks.Opts{FlushInterval: flushInterval})
+	addShutdownFunc(missRecorder.Close)
+
+	// Suggest codes within one edit on the not found page, unless SUGGEST_MAX_DISTANCE says otherwise
+	suggestDistance := 1
+	if v := os.Getenv("SUGGEST_MAX_DISTANCE"); v == "off" {
+		suggestDistance = -1
+	} else if n, err := strconv.Atoi(v); err == nil {
+		suggestDistance = n
+	}
+
 	service := handlers.NewAPIService(handlers.ServiceConfig{
-		Store:       store,
-		BaseURL:     baseURL,
-		APIkey:      apiKey,
-		ErrorClient: errorClient,
+		Store:           store,
+		ClickCounter:    clickCounter,
+		ClickRecorder:   clickRecorder,
+		ClickStore:      events,
+		MissStore:       events,
+		MissRecorder:    missRecorder,
+		SuggestDistance: suggestDistance,
+		IPSalt:          os.Getenv("CLICK_IP_SALT"),
+		CountryHeader:   os.Getenv("CLICK_COUNTRY_HEADER"),
+		BaseURL:         baseURL,
+		APIkey:          apiKey,
+		ErrorClient:     errorClient,
+		TracerProvider:  tracerProvider,
 	})
-	return handlers.NewServer(service)
+
+	// Scrapes need the API key when METRICS_REQUIRE_KEY is true
+	metricsOpts := metrics.HTTPOpts{}
+	if os.Getenv("METRICS_REQUIRE_KEY") == "true" {
+		metricsOpts.APIKey = apiKey
+	}
+	handler := metrics.Middleware(handlers.NewServer(service), reg, metricsOpts)
+
+	// Log every request unless ACCESS_LOG_SAMPLE_RATE says otherwise. Server errors are always logged.
+	sampleRate, err := strconv.ParseFloat(os.Getenv("ACCESS_LOG_SAMPLE_RATE"), 64)
+	if err != nil {
+		sampleRate = 1
+	}
+	return service.LogRequests(handler, sampleRate)
 }
 
-// InitStore initializes the ShortyStore to either a MongoDB or an in-memory implementation.
-func initStore() (handlers.LinkStore, error) {
+// Shutdown flushes any buffered state, such as click counts and in-memory snapshots, before the process exits.
+// It's called on SIGTERM or SIGINT, so callers only need it when they stop the app some other way.
+func Shutdown(ctx context.Context) error {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+
+	var errs []error
+	for i := len(shutdownFuncs) - 1; i >= 0; i-- {
+		if err := shutdownFuncs[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	shutdownFuncs = nil
+	return errors.Join(errs...)
+}
+
+// ShutdownOnSignal runs Shutdown when a signal arrives, then exits.
+func shutdownOnSignal(stop <-chan os.Signal) {
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	exit(0)
+}
+
+func addShutdownFunc(f func(context.Context) error) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	shutdownFuncs = append(shutdownFuncs, f)
+}
+
+// EventStore holds click events and misses. It's always the primary store, never a decorator.
+type eventStore interface {
+	handlers.ClickStore
+	handlers.MissStore
+}
+
+// InitStore initializes the ShortyStore to either a MongoDB or an in-memory implementation,
+// along with the store for click events and misses. MongoDB commands are traced when tp isn't nil.
+func initStore(tp trace.TracerProvider) (handlers.LinkStore, eventStore, error) {
 	if os.Getenv("CI") == "true" {
-		return inmem.NewStore(), nil
+		store := inmem.NewStore()
+		return store, store, nil
 	}
-	mongoURI := os.Getenv("MONGO_URI")
-	if len(mongoURI) == 0 {
-		mongoURI = "mongodb://localhost:27017/url-shortener"
+
+	// Use a snapshot-backed in-memory store for demos and local development without MongoDB
+	if snapshotPath := os.Getenv("INMEM_SNAPSHOT_PATH"); len(snapshotPath) > 0 {
+		store, err := inmem.NewPersistentStore(inmem.SnapshotOpts{Path: snapshotPath})
+		if err != nil {
+			return nil, nil, err
+		}
+		addShutdownFunc(func(context.Context) error { return store.Close() })
+		return store, store, nil
 	}
 
-	store, err := mongodb.NewStore(mongodb.StoreOpts{URI: mongoURI})
+	opts, err := mongodb.OptsFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tp != nil {
+		opts.Monitor = tracing.CommandMonitor(tp)
+	}
+	primary, err := initMongoStore(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Retry transient errors, and keep redirecting recently resolved links during an outage
+	resilientPrimary := resilient.NewStore(primary, resilient.Opts{})
+
+	// Write to a second cluster as well while moving links to it. Click events and misses only go to the primary.
+	secondaryURI := os.Getenv("MONGO_SECONDARY_URI")
+	if len(secondaryURI) == 0 {
+		return resilientPrimary, primary, nil
+	}
+	opts.URI = secondaryURI
+	opts.DBName = os.Getenv("MONGO_SECONDARY_DB_NAME")
+	secondary, err := initMongoStore(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("secondary: %v", err)
+	}
+	verifyInterval, _ := time.ParseDuration(os.Getenv("DUALWRITE_VERIFY_INTERVAL"))
+	store, err := dualwrite.NewStore(resilientPrimary, resilient.NewStore(secondary, resilient.Opts{}), dualwrite.Opts{
+		ReadFrom:       os.Getenv("DUALWRITE_READ_FROM"),
+		VerifyInterval: verifyInterval,
+	})
 	if err != nil {
+		return nil, nil, err
+	}
+	addShutdownFunc(store.Close)
+	return store, primary, nil
+}
+
+// InitMongoStore connects to MongoDB and checks that the database has been migrated.
+func initMongoStore(opts mongodb.StoreOpts) (*mongodb.Store, error) {
+	store, err := mongodb.NewStore(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to serve until the database has been migrated to the schema this version expects
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.CheckSchema(ctx); err != nil {
 		return nil, err
 	}
 	return store, nil
 }
 
+// InitTracing returns a TracerProvider that exports spans to TRACE_EXPORTER, or nil when it isn't set.
+// Without one, requests are still logged with the trace from their traceparent or X-Cloud-Trace-Context header.
+func initTracing() (trace.TracerProvider, error) {
+	exporter := os.Getenv("TRACE_EXPORTER")
+	if len(exporter) == 0 {
+		return nil, nil
+	}
+	sampleRatio, _ := strconv.ParseFloat(os.Getenv("TRACE_SAMPLE_RATIO"), 64)
+	tp, err := tracing.NewProvider(tracing.Opts{
+		Exporter:    exporter,
+		Path:        os.Getenv("TRACE_FILE"),
+		SampleRatio: sampleRatio,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Added first, so spans from flushing clicks on shutdown are exported too
+	addShutdownFunc(tp.Shutdown)
+	return tp, nil
+}
+
 func initErrorReporting() (*errorreporting.Client, error) {
 	if os.Getenv("CI") == "true" {
 		return &errorreporting.Client{}, nil
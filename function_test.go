@@ -2,11 +2,15 @@ package function
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/operationspark/shorty/handlers"
 	"github.com/operationspark/shorty/inmem"
@@ -62,7 +66,7 @@ func TestGETLink(t *testing.T) {
 		{
 			name:       "GET abc123",
 			endpoint:   "/abc123",
-			wantBody:   `{"shortUrl":"","code":"abc123","customCode":"","originalUrl":"","totalClicks":0,"createdBy":"","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}` + "\n",
+			wantBody:   `{"shortUrl":"","code":"abc123","customCode":"","originalUrl":"","private":false,"totalClicks":0,"humanClicks":0,"botClicks":0,"createdBy":"","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}` + "\n",
 			statusCode: http.StatusOK,
 		},
 		{
@@ -99,7 +103,7 @@ func TestGETLinks(t *testing.T) {
 		})
 		server := handlers.NewServer(service)
 
-		wantBody := `[{"shortUrl":"","code":"abc123","customCode":"","originalUrl":"","totalClicks":0,"createdBy":"","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}]` + "\n"
+		wantBody := `[{"shortUrl":"","code":"abc123","customCode":"","originalUrl":"","private":false,"totalClicks":0,"humanClicks":0,"botClicks":0,"createdBy":"","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}]` + "\n"
 
 		request := NewRequestWithAPIKey(http.MethodGet, "/api/urls/", nil)
 		response := httptest.NewRecorder()
@@ -129,3 +133,28 @@ func TestGETLinks(t *testing.T) {
 		testutil.AssertResponseBody(t, response.Body.String(), wantBody)
 	})
 }
+
+func TestShutdownOnSignal(t *testing.T) {
+	t.Run("init registers a SIGTERM handler that runs Shutdown", func(t *testing.T) {
+		exited := make(chan int, 1)
+		exit = func(code int) { exited <- code }
+		defer func() { exit = os.Exit }()
+
+		flushed := make(chan struct{})
+		addShutdownFunc(func(context.Context) error {
+			close(flushed)
+			return nil
+		})
+
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-flushed:
+		case <-time.After(time.Second):
+			t.Fatal("expected Shutdown to run after SIGTERM")
+		}
+		testutil.AssertEqual(t, <-exited, 0)
+	})
+}
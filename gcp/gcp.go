@@ -15,6 +15,29 @@ type (
 
 		// Logs Explorer allows filtering and display of this as `jsonPayload.component`.
 		Component string `json:"component,omitempty"`
+
+		// Request the entry is about. Logs Explorer shows it like a load balancer's request log.
+		HTTPRequest *HTTPRequest `json:"httpRequest,omitempty"`
+		// Allowed request headers, with credentials redacted.
+		RequestHeaders map[string]string `json:"requestHeaders,omitempty"`
+		// Indexed labels, filtered on as `labels.name`.
+		Labels map[string]string `json:"logging.googleapis.com/labels,omitempty"`
+	}
+
+	// HTTPRequest is the subset of Cloud Logging's HttpRequest the service knows about.
+	// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+	HTTPRequest struct {
+		RequestMethod string `json:"requestMethod"`
+		RequestURL    string `json:"requestUrl"`
+		RequestSize   int64  `json:"requestSize,omitempty,string"`
+		Status        int    `json:"status"`
+		ResponseSize  int64  `json:"responseSize,string"`
+		UserAgent     string `json:"userAgent,omitempty"`
+		RemoteIP      string `json:"remoteIp,omitempty"`
+		Referer       string `json:"referer,omitempty"`
+		// Duration with an "s" suffix, ex: "0.012s"
+		Latency  string `json:"latency"`
+		Protocol string `json:"protocol,omitempty"`
 	}
 )
 
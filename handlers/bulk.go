@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/operationspark/shorty/linkio"
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Conflict modes for imported links whose code is already in use
+const (
+	// Replace the existing link's originalUrl.
+	ConflictUpsert = "upsert"
+	// Leave the existing link alone.
+	ConflictSkip = "skip"
+	// Stop the import at the first conflict.
+	ConflictFail = "fail"
+)
+
+// Largest import request body, about 100,000 links in CSV
+const maxImportBytes = 32 << 20
+
+// Most rows listed in an import response. Rows past it are still counted.
+const maxImportRows = 1000
+
+type (
+	importResult struct {
+		// Set when nothing was written. The counts and rows are what the import would do.
+		DryRun  bool `json:"dryRun,omitempty"`
+		Created int  `json:"created"`
+		Updated int  `json:"updated"`
+		Skipped int  `json:"skipped"`
+		Failed  int  `json:"failed"`
+		// Rows whose code was already in use, however they were resolved.
+		Conflicts int         `json:"conflicts"`
+		Rows      []importRow `json:"rows"`
+		// Rows left out of Rows once it reached maxImportRows.
+		OmittedRows int `json:"omittedRows,omitempty"`
+	}
+
+	importRow struct {
+		Row  int    `json:"row"`
+		Code string `json:"code,omitempty"`
+		// One of "created", "updated", "skipped", "conflict", or "error".
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+		// Set when the code was already in use.
+		Conflict bool `json:"conflict,omitempty"`
+	}
+
+	// Importer saves imported links, or with dryRun set, works out what saving them would do.
+	importer struct {
+		s        *ShortyService
+		r        *http.Request
+		conflict string
+		dryRun   bool
+		// Codes of earlier rows, so a dry run catches duplicates within the import
+		seen map[string]bool
+	}
+)
+
+// ExportLinks streams every link in the requested format.
+func (s *ShortyService) exportLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := formatParam(r)
+	lw, err := linkio.NewWriter(w, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", linkio.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, linkio.Filename(format)))
+	err = s.store.EachLink(r.Context(), lw.Write)
+	if err == nil {
+		err = lw.Flush()
+	}
+	if err != nil {
+		// The status has already been sent, so the client sees a truncated file
+		s.logError(fmt.Errorf("exportLinks: %v", err), s.getTrace(r))
+	}
+}
+
+// ImportLinks creates a link for each row of the request body and reports the result of each row, up to maxImportRows.
+func (s *ShortyService) importLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conflict := r.URL.Query().Get("conflict")
+	switch conflict {
+	case "":
+		conflict = ConflictFail
+	case ConflictUpsert, ConflictSkip, ConflictFail:
+	default:
+		http.Error(w, fmt.Sprintf("conflict: want %q, %q, or %q", ConflictUpsert, ConflictSkip, ConflictFail), http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	lr, err := linkio.NewSourceReader(body, r.URL.Query().Get("source"), formatParam(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imp := importer{
+		s:        s,
+		r:        r,
+		conflict: conflict,
+		dryRun:   r.URL.Query().Get("dryRun") == "true",
+		seen:     map[string]bool{},
+	}
+	res := importResult{DryRun: imp.dryRun, Rows: []importRow{}}
+	status := http.StatusOK
+	for row := 1; ; row++ {
+		link, err := lr.Read()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *linkio.RowError
+		if errors.As(err, &rowErr) {
+			res.Failed++
+			res.addRow(importRow{Row: row, Status: "error", Error: rowErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			// The rest of the body can't be read. Rows before this one were imported.
+			res.Failed++
+			status = http.StatusBadRequest
+			// The readers don't wrap errors, but the limit's error is returned again by any later read
+			var tooLarge *http.MaxBytesError
+			if _, bodyErr := body.Read(nil); errors.As(bodyErr, &tooLarge) {
+				err = fmt.Errorf("body is larger than %d bytes", tooLarge.Limit)
+				status = http.StatusRequestEntityTooLarge
+			}
+			res.addRow(importRow{Row: row, Status: "error", Error: err.Error()})
+			break
+		}
+
+		result := imp.importLink(row, link)
+		res.addRow(result)
+		if result.Conflict {
+			res.Conflicts++
+		}
+		switch result.Status {
+		case "created":
+			res.Created++
+		case "updated":
+			res.Updated++
+		case "skipped":
+			res.Skipped++
+		default:
+			res.Failed++
+		}
+		// A dry run keeps going to report every conflict
+		if result.Status == "conflict" && !imp.dryRun {
+			status = http.StatusConflict
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		s.logError(fmt.Errorf("importLinks: encode: %v", err), s.getTrace(r))
+	}
+}
+
+// AddRow lists row in the response, or counts it as omitted once maxImportRows are listed.
+func (res *importResult) addRow(row importRow) {
+	if len(res.Rows) >= maxImportRows {
+		res.OmittedRows++
+		return
+	}
+	res.Rows = append(res.Rows, row)
+}
+
+// ImportLink saves one imported link, resolving a code conflict with the conflict mode.
+func (imp *importer) importLink(row int, link shorty.Link) importRow {
+	s, r := imp.s, imp.r
+	if len(link.CustomCode) == 0 {
+		link.CustomCode = link.Code
+	}
+	// Generates a code when neither is set, and points the short URL at this service
+	link.GenCode(s.baseURL)
+	result := importRow{Row: row, Code: link.Code}
+
+	if len(link.OriginalUrl) == 0 {
+		result.Status, result.Error = "error", `"originalUrl" field required`
+		return result
+	}
+	if err := validateURL(link.OriginalUrl); err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+
+	now := time.Now()
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = now
+	}
+	if link.UpdatedAt.IsZero() {
+		link.UpdatedAt = now
+	}
+	if len(link.CreatedBy) == 0 {
+		link.CreatedBy = s.serviceName
+	}
+
+	if imp.dryRun {
+		return imp.plan(result)
+	}
+
+	_, err := s.store.SaveLink(r.Context(), link)
+	if errors.Is(err, shorty.ErrCodeInUse) {
+		result.Conflict = true
+		switch imp.conflict {
+		case ConflictSkip:
+			result.Status = "skipped"
+			return result
+		case ConflictUpsert:
+			_, err = s.store.UpdateLink(r.Context(), link.Code, shorty.Link{OriginalUrl: link.OriginalUrl}, shorty.UpdateOpts{})
+			if err == nil {
+				result.Status = "updated"
+				return result
+			}
+		default:
+			result.Status, result.Error = "conflict", shorty.ErrCodeInUse.Error()
+			return result
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, shorty.ErrInvalidLink) {
+			s.logError(fmt.Errorf("importLink: %v", err), s.getTrace(r))
+		}
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+	result.Status = "created"
+	return result
+}
+
+// Plan reports what importing a valid row would do without writing it.
+func (imp *importer) plan(result importRow) importRow {
+	inUse := imp.seen[result.Code]
+	if !inUse {
+		var err error
+		inUse, err = imp.s.store.CheckCodeInUse(imp.r.Context(), result.Code)
+		if err != nil {
+			imp.s.logError(fmt.Errorf("importLink: checkCodeInUse: %v", err), imp.s.getTrace(imp.r))
+			result.Status, result.Error = "error", "could not check code"
+			return result
+		}
+	}
+	imp.seen[result.Code] = true
+
+	if !inUse {
+		result.Status = "created"
+		return result
+	}
+	result.Conflict = true
+	switch imp.conflict {
+	case ConflictSkip:
+		result.Status = "skipped"
+	case ConflictUpsert:
+		result.Status = "updated"
+	default:
+		result.Status, result.Error = "conflict", shorty.ErrCodeInUse.Error()
+	}
+	return result
+}
+
+// FormatParam returns the "format" query parameter, defaulting to JSON lines.
+func formatParam(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); len(format) > 0 {
+		return format
+	}
+	return linkio.JSONL
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Longest referrer kept in a click event
+const maxReferrerLen = 1024
+
+// RecordClick hands a click event for the redirect to the ClickRecorder, if one is configured.
+func (s *ShortyService) recordClick(r *http.Request, code string, bot bool) {
+	if s.clickRecorder == nil {
+		return
+	}
+	s.clickRecorder.Record(shorty.Click{
+		Code:      code,
+		At:        time.Now().UTC(),
+		Referrer:  referrer(r),
+		UserAgent: shorty.ParseUserAgent(r.UserAgent()),
+		IPHash:    shorty.HashIP(clientIP(r), s.ipSalt),
+		Country:   s.country(r),
+		Bot:       bot,
+	})
+}
+
+// ClientIP returns the last address in X-Forwarded-For, the one the platform's load balancer appended,
+// or the connection's address when the header isn't there. Earlier addresses come from the client and can be forged.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		last := fwd[len(fwd)-1]
+		if n := strings.LastIndex(last, ","); n >= 0 {
+			last = last[n+1:]
+		}
+		return strings.TrimSpace(last)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Referrer returns the Referer header without its query string or fragment, which may hold tokens or personal data.
+func referrer(r *http.Request) string {
+	ref := r.Referer()
+	if len(ref) == 0 {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || !u.IsAbs() {
+		return ""
+	}
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	ref = u.String()
+	if len(ref) > maxReferrerLen {
+		ref = ref[:maxReferrerLen]
+	}
+	return ref
+}
+
+// Country returns the two letter country code from the configured header.
+func (s *ShortyService) country(r *http.Request) string {
+	if len(s.countryHeader) == 0 {
+		return ""
+	}
+	c := strings.ToUpper(strings.TrimSpace(r.Header.Get(s.countryHeader)))
+	// "ZZ" and "XX" mean unknown on App Engine and Cloudflare
+	if len(c) != 2 || c == "ZZ" || c == "XX" {
+		return ""
+	}
+	return c
+}
+
+func randomSalt() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -13,8 +14,11 @@ import (
 	"time"
 
 	"cloud.google.com/go/errorreporting"
+	"github.com/operationspark/shorty/bots"
 	"github.com/operationspark/shorty/gcp"
 	"github.com/operationspark/shorty/shorty"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type (
@@ -22,26 +26,102 @@ type (
 		SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error)
 		FindLink(ctx context.Context, code string) (shorty.Link, error)
 		FindAllLinks(ctx context.Context) (shorty.Links, error)
-		UpdateLink(ctx context.Context, code string, toUpdate shorty.Link) (shorty.Link, error)
+		UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error)
 		DeleteLink(ctx context.Context, code string) (int, error)
 		CheckCodeInUse(ctx context.Context, code string) (bool, error)
+		// FindSimilarCodes returns up to shorty.MaxSuggestions codes of public links whose skeletons are
+		// within maxDistance edits of code's, closest first.
+		FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error)
+		// IncrementTotalClicks counts a human click and returns the new "totalClicks".
 		IncrementTotalClicks(ctx context.Context, code string) (int, error)
+		// IncrementTotalClicksBatch adds each count to the click totals of the link with the matching code.
+		// Codes that no longer exist are ignored.
+		IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error
+		// EachLink calls fn with every link in order of code without loading them all into memory.
+		// Iteration stops at the first error returned by fn.
+		EachLink(ctx context.Context, fn func(shorty.Link) error) error
+		// Ping returns an error if the store can't currently serve requests.
+		Ping(ctx context.Context) error
+	}
+
+	// ClickCounter records a redirect for a code without waiting on the store.
+	ClickCounter interface {
+		Count(code string, bot bool)
+	}
+
+	// ClickStore persists click events.
+	ClickStore interface {
+		SaveClicks(ctx context.Context, clicks []shorty.Click) error
+		// ClickStats counts the events of q.Code in [q.From, q.To), with a zero-filled bucket for every q.Interval.
+		ClickStats(ctx context.Context, q shorty.StatsQuery) (shorty.ClickStats, error)
+		// UniqueVisitors estimates the distinct visitors of code over the UTC days from from's day until to.
+		// A zero from or to leaves that end of the range open.
+		UniqueVisitors(ctx context.Context, code string, from, to time.Time) (int, error)
+	}
+
+	// MissStore counts requests for unknown codes.
+	MissStore interface {
+		RecordMisses(ctx context.Context, tallies []shorty.MissTally) error
+		// TopMisses returns the codes missed at or after q.Since that still have no link, most missed first.
+		TopMisses(ctx context.Context, q shorty.MissQuery) ([]shorty.MissCount, error)
+	}
+
+	// ClickRecorder records a click event without waiting on the store.
+	ClickRecorder interface {
+		Record(shorty.Click)
+	}
+
+	// MissRecorder records a request for an unknown code without waiting on the store.
+	MissRecorder interface {
+		Record(shorty.Miss)
 	}
 
 	ShortyService struct {
 		store LinkStore
+		// Optional. Click counts are written synchronously when nil.
+		clickCounter ClickCounter
+		// Optional. Click events aren't recorded when nil.
+		clickRecorder ClickRecorder
+		// Optional. Serves click stats.
+		clickStore ClickStore
+		// Optional. Misses aren't recorded when nil.
+		missStore MissStore
+		// Optional. Misses are written synchronously when nil.
+		missRecorder MissRecorder
+		// Edit distance of codes suggested on the not found page. Negative when suggestions are off.
+		suggestDistance int
+		// Key for hashing client IPs in click events
+		ipSalt string
+		// Request header with the client's country code, ex: "X-Appengine-Country". Optional.
+		countryHeader string
 		// Base service URL. Defaults to https://ospk.org
 		baseURL     string
 		serviceName string
 		apiKey      string
 		errorClient *errorreporting.Client
+		tracer      trace.Tracer
+		// Last readiness result
+		ready readyCache
 	}
 
 	ServiceConfig struct {
-		Store       LinkStore
-		BaseURL     string
-		APIkey      string
-		ErrorClient *errorreporting.Client
+		Store         LinkStore
+		ClickCounter  ClickCounter
+		ClickRecorder ClickRecorder
+		ClickStore    ClickStore
+		MissStore     MissStore
+		MissRecorder  MissRecorder
+		// Edit distance of codes suggested on the not found page, up to shorty.MaxSimilarDistance.
+		// 0 only suggests case and confusable variants, ex: "Fall-C0hort" for "fall-cohort". Negative turns suggestions off.
+		SuggestDistance int
+		// Key for hashing client IPs. Hashes can't be compared across instances and restarts unless it is set.
+		IPSalt        string
+		CountryHeader string
+		BaseURL       string
+		APIkey        string
+		ErrorClient   *errorreporting.Client
+		// Optional. Spans aren't recorded when nil, but log entries still get the trace from the request headers.
+		TracerProvider trace.TracerProvider
 	}
 )
 
@@ -56,12 +136,31 @@ func NewAPIService(c ServiceConfig) *ShortyService {
 		_apiKey = c.APIkey
 	}
 
+	_ipSalt := c.IPSalt
+	if len(_ipSalt) == 0 {
+		_ipSalt = randomSalt()
+	}
+
+	tp := c.TracerProvider
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+
 	return &ShortyService{
-		store:       c.Store,
-		baseURL:     strings.TrimSuffix(_baseURL, "/"),
-		serviceName: "system",
-		apiKey:      _apiKey,
-		errorClient: c.ErrorClient,
+		store:           c.Store,
+		clickCounter:    c.ClickCounter,
+		clickRecorder:   c.ClickRecorder,
+		clickStore:      c.ClickStore,
+		missStore:       c.MissStore,
+		missRecorder:    c.MissRecorder,
+		suggestDistance: min(c.SuggestDistance, shorty.MaxSimilarDistance),
+		ipSalt:          _ipSalt,
+		countryHeader:   c.CountryHeader,
+		baseURL:         strings.TrimSuffix(_baseURL, "/"),
+		serviceName:     "system",
+		apiKey:          _apiKey,
+		errorClient:     c.ErrorClient,
+		tracer:          tp.Tracer(tracerName),
 	}
 }
 
@@ -73,13 +172,22 @@ func NewServer(apiService *ShortyService) *http.ServeMux {
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/test-logging/", apiService.testLogging)
+	// Every route is served in a span named after it
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, apiService.traced(pattern, h))
+	}
+	handle("/test-logging/", apiService.testLogging)
+	handle("/healthz", apiService.ServeHealth)
+	handle("/readyz", apiService.ServeReady)
 	// Find better way to ignore trailing "/"
-	mux.HandleFunc("/api/urls", apiService.verifyAuth(apiService.ServeAPI))
-	mux.HandleFunc("/api/urls/", apiService.verifyAuth(apiService.ServeAPI))
+	handle("/api/urls", apiService.verifyAuth(apiService.ServeAPI))
+	handle("/api/urls/", apiService.verifyAuth(apiService.ServeAPI))
+	handle("/api/urls/export", apiService.verifyAuth(apiService.exportLinks))
+	handle("/api/urls/import", apiService.verifyAuth(apiService.importLinks))
+	handle("/api/misses", apiService.verifyAuth(apiService.getMisses))
 
-	mux.Handle("/favicon.ico", http.FileServer(http.FS(html)))
-	mux.HandleFunc("/", apiService.ServeResolver)
+	handle("/favicon.ico", http.FileServer(http.FS(html)).ServeHTTP)
+	handle("/", apiService.ServeResolver)
 
 	return mux
 }
@@ -98,9 +206,12 @@ func (s *ShortyService) verifyAuth(h http.HandlerFunc) http.HandlerFunc {
 }
 
 func (s *ShortyService) logError(err error, trace string) {
-	s.errorClient.Report(errorreporting.Entry{
-		Error: err,
-	})
+	// Error reporting is optional, ex: in tests
+	if s.errorClient != nil {
+		s.errorClient.Report(errorreporting.Entry{
+			Error: err,
+		})
+	}
 	log.Println(gcp.LogEntry{
 		Severity:  "ERROR",
 		Message:   err.Error(),
@@ -109,20 +220,29 @@ func (s *ShortyService) logError(err error, trace string) {
 	})
 }
 
-// GetTrace derives the traceID associated with the current request.
+// GetTrace derives the Cloud Logging trace of the current request, so log entries are grouped with it.
+// The W3C trace context is preferred over the X-Cloud-Trace-Context header. Without a project ID the bare trace ID
+// is returned, which still groups a request's entries and matches its exported spans.
 func (s *ShortyService) getTrace(r *http.Request) string {
+	var traceID string
+	if sc := traceSpanContext(r); sc.IsValid() {
+		traceID = sc.TraceID().String()
+	} else {
+		traceID, _, _ = strings.Cut(r.Header.Get("X-Cloud-Trace-Context"), "/")
+	}
+	if len(traceID) == 0 {
+		return ""
+	}
+
 	projectID := os.Getenv("GCP_PROJECT_ID")
-	var trace string
 	if len(projectID) == 0 {
-		return trace
+		// Set by some Google Cloud runtimes
+		projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
 	}
-
-	traceHeader := r.Header.Get("X-Cloud-Trace-Context")
-	traceParts := strings.Split(traceHeader, "/")
-	if len(traceParts) > 0 && len(traceParts[0]) > 0 {
-		trace = fmt.Sprintf("projects/%s/traces/%s", projectID, traceParts[0])
+	if len(projectID) == 0 {
+		return traceID
 	}
-	return trace
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, traceID)
 }
 
 func (s *ShortyService) testLogging(w http.ResponseWriter, r *http.Request) {
@@ -130,7 +250,7 @@ func (s *ShortyService) testLogging(w http.ResponseWriter, r *http.Request) {
 		fmt.Errorf("log test request:\n-> %s %s", r.Method, r.URL.Path),
 		s.getTrace(r),
 	)
-	s.renderNotFound(w, r)
+	s.renderNotFound(w, r, nil)
 }
 
 func (s *ShortyService) ServeAPI(w http.ResponseWriter, r *http.Request) {
@@ -143,6 +263,10 @@ func (s *ShortyService) ServeAPI(w http.ResponseWriter, r *http.Request) {
 		return
 
 	case http.MethodGet:
+		if code, ok := parseStatsPath(r.URL.Path); ok {
+			s.getStats(w, r, code)
+			return
+		}
 		code := parseLinkCode(r.URL.Path)
 		if len(code) == 0 {
 			s.getLinks(w, r)
@@ -161,28 +285,51 @@ func (s *ShortyService) ServeAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *ShortyService) ServeResolver(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	// HEAD requests are redirected too, and counted as bots
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Only GET requests are accepted\n", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Unfurlers and prefetches still get the redirect, but are counted separately from people
+	bot := bots.IsBot(r)
+
 	code := parseLinkCode(r.URL.Path)
+	setLoggedCode(r.Context(), code)
 	link, err := s.store.FindLink(r.Context(), code)
 	if err != nil {
 		if err == shorty.ErrLinkNotFound {
-			s.renderNotFound(w, r)
+			s.recordMiss(r, code, bot)
+			s.renderNotFound(w, r, s.suggestCodes(r, code, bot))
 			return
 		}
 		s.renderServerError(w, r, "Could not resolve link")
 		s.logError(fmt.Errorf("findLink: %v", err), s.getTrace(r))
+		return
 	}
 
-	_, err = s.store.IncrementTotalClicks(r.Context(), code)
+	s.countClick(r.Context(), code, bot)
+	s.recordClick(r, code, bot)
+	http.Redirect(w, r, link.OriginalUrl, http.StatusTemporaryRedirect)
+}
+
+// CountClick records a redirect, using the buffered ClickCounter if one is configured.
+func (s *ShortyService) countClick(ctx context.Context, code string, bot bool) {
+	if s.clickCounter != nil {
+		s.clickCounter.Count(code, bot)
+		return
+	}
+
+	var err error
+	if bot {
+		err = s.store.IncrementTotalClicksBatch(ctx, map[string]shorty.ClickCounts{code: {Bot: 1}})
+	} else {
+		_, err = s.store.IncrementTotalClicks(ctx, code)
+	}
 	if err != nil {
 		// Redirect even if there is an error. Client should not suffer if the clicks can't be updated.
 		fmt.Fprintf(os.Stderr, "could not update TotalClick count: %v", err)
 	}
-	http.Redirect(w, r, link.OriginalUrl, http.StatusTemporaryRedirect)
 }
 
 func (s *ShortyService) createLink(w http.ResponseWriter, r *http.Request) {
@@ -229,6 +376,15 @@ func (s *ShortyService) createLink(w http.ResponseWriter, r *http.Request) {
 
 	newLink, err := s.store.SaveLink(r.Context(), linkInput)
 	if err != nil {
+		// Another request can take the code between the check above and the save
+		if errors.Is(err, shorty.ErrCodeInUse) {
+			http.Error(w, fmt.Sprintf(`code: %q already in use.`, linkInput.Code), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, shorty.ErrInvalidLink) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		s.logError(fmt.Errorf("createLink: SaveLink: %v", err), s.getTrace(r))
 		http.Error(w, "Problem creating short link", http.StatusInternalServerError)
 		return
@@ -245,6 +401,7 @@ func (s *ShortyService) createLink(w http.ResponseWriter, r *http.Request) {
 
 func (s *ShortyService) getLink(w http.ResponseWriter, r *http.Request) {
 	code := parseLinkCode(r.URL.Path)
+	setLoggedCode(r.Context(), code)
 	link, err := s.store.FindLink(r.Context(), code)
 	if err != nil {
 		if err == shorty.ErrLinkNotFound {
@@ -265,6 +422,14 @@ func (s *ShortyService) getLink(w http.ResponseWriter, r *http.Request) {
 		)
 		return
 	}
+
+	// The link is still useful without its visitor count
+	if s.clickStore != nil {
+		link.UniqueVisitors, err = s.clickStore.UniqueVisitors(r.Context(), code, time.Time{}, time.Time{})
+		if err != nil {
+			s.logError(fmt.Errorf("getLink: UniqueVisitors: %v", err), s.getTrace(r))
+		}
+	}
 	link.ToJSON(w)
 }
 
@@ -284,13 +449,17 @@ func (s *ShortyService) getLinks(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *ShortyService) updateLink(w http.ResponseWriter, r *http.Request) {
-	var link shorty.Link
-	err := link.FromJSON(r.Body)
-	if err != nil {
-		s.logError(fmt.Errorf("fromJSON: %v", err), s.getTrace(r))
+	// Private is only changed when the body has it
+	var body struct {
+		shorty.Link
+		Private *bool `json:"private"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.logError(fmt.Errorf("decode: %v", err), s.getTrace(r))
 		http.Error(w, shorty.ErrJSONUnmarshal.Error(), http.StatusBadRequest)
 		return
 	}
+	link := body.Link
 
 	if len(link.CustomCode) > 0 {
 		isUsed, err := s.store.CheckCodeInUse(r.Context(), link.CustomCode)
@@ -306,12 +475,20 @@ func (s *ShortyService) updateLink(w http.ResponseWriter, r *http.Request) {
 		link.GenCode(s.baseURL)
 	}
 	code := parseLinkCode(r.URL.Path)
-	updated, err := s.store.UpdateLink(r.Context(), code, link)
+	updated, err := s.store.UpdateLink(r.Context(), code, link, shorty.UpdateOpts{Private: body.Private})
 	if err != nil {
 		if err == shorty.ErrLinkNotFound {
 			http.Error(w, shorty.ErrLinkNotFound.Error(), http.StatusNotFound)
 			return
 		}
+		if errors.Is(err, shorty.ErrCodeInUse) {
+			http.Error(w, shorty.ErrCodeInUse.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, shorty.ErrInvalidLink) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		s.logError(fmt.Errorf("updateLink: %v", err), s.getTrace(r))
 		http.Error(w, "Could not update link", http.StatusInternalServerError)
 		return
@@ -334,6 +511,10 @@ func (s *ShortyService) deleteLink(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Could not delete link", http.StatusInternalServerError)
 		return
 	}
+	if count == 0 {
+		http.Error(w, shorty.ErrLinkNotFound.Error(), http.StatusNotFound)
+		return
+	}
 	fmt.Fprint(w, count)
 }
 
@@ -1,9 +1,24 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/operationspark/shorty/gcp"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
 	"github.com/operationspark/shorty/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestParseLinkCode(t *testing.T) {
@@ -24,3 +39,718 @@ func TestParseLinkCode(t *testing.T) {
 		}
 	})
 }
+
+// RejectingStore fails every write the way a store with schema validation would.
+type rejectingStore struct {
+	*inmem.Store
+}
+
+func (r rejectingStore) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	return shorty.Link{}, &shorty.ValidationError{Details: "code must not be empty"}
+}
+
+// NewRequestWithAPIKey returns a request authenticated with the key the test services are configured with.
+func newRequestWithAPIKey(method, target, body string) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("key", "test-api-key")
+	return request
+}
+
+// ServeWithAPIKey serves an authenticated request to service and returns the response.
+func serveWithAPIKey(service *ShortyService, method, target, body string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	NewServer(service).ServeHTTP(response, newRequestWithAPIKey(method, target, body))
+	return response
+}
+
+func TestCreateLinkValidation(t *testing.T) {
+	t.Run("responds with 422 when the store rejects the link", func(t *testing.T) {
+		service := NewAPIService(ServiceConfig{
+			Store:  rejectingStore{inmem.NewStore()},
+			APIkey: "test-api-key",
+		})
+		response := serveWithAPIKey(service, http.MethodPost, "/api/urls", `{"originalUrl":"https://ospk.org"}`)
+
+		testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		testutil.AssertContains(t, response.Body.String(), "code must not be empty")
+	})
+}
+
+// RacingStore reports codes as free, then fails the write as if another request took the code first.
+type racingStore struct {
+	*inmem.Store
+}
+
+func (racingStore) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	return shorty.Link{}, shorty.ErrCodeInUse
+}
+
+func (racingStore) UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	return shorty.Link{}, shorty.ErrCodeInUse
+}
+
+func TestCodeTakenDuringWrite(t *testing.T) {
+	service := NewAPIService(ServiceConfig{
+		Store:  racingStore{inmem.NewStore()},
+		APIkey: "test-api-key",
+	})
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"create", http.MethodPost, "/api/urls"},
+		{"update", http.MethodPut, "/api/urls/abc123"},
+	}
+	for _, c := range tests {
+		t.Run("responds with 409 on "+c.name, func(t *testing.T) {
+			response := serveWithAPIKey(service, c.method, c.path, `{"originalUrl":"https://ospk.org","customCode":"taken"}`)
+
+			testutil.AssertStatus(t, response.Code, http.StatusConflict)
+		})
+	}
+}
+
+func TestDeleteLink(t *testing.T) {
+	t.Run("responds with 404 when the code is unknown", func(t *testing.T) {
+		service := NewAPIService(ServiceConfig{
+			Store:  inmem.NewStore(),
+			APIkey: "test-api-key",
+		})
+		response := serveWithAPIKey(service, http.MethodDelete, "/api/urls/nope", "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+// UnreachableStore fails Ping the way a store that lost its database connection would.
+type unreachableStore struct {
+	*inmem.Store
+}
+
+func (u unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+// PingCounter counts Pings.
+type pingCounter struct {
+	*inmem.Store
+	pings int
+}
+
+func (p *pingCounter) Ping(ctx context.Context) error {
+	p.pings++
+	return nil
+}
+
+// SlowPinger blocks each Ping until release is closed, failing if ctx ends first.
+type slowPinger struct {
+	*inmem.Store
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s slowPinger) Ping(ctx context.Context) error {
+	close(s.started)
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	serve := func(store LinkStore, path string) *httptest.ResponseRecorder {
+		service := NewAPIService(ServiceConfig{Store: store})
+		response := httptest.NewRecorder()
+		NewServer(service).ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response
+	}
+
+	t.Run("responds to liveness checks without checking the store", func(t *testing.T) {
+		response := serve(unreachableStore{inmem.NewStore()}, "/healthz")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContains(t, response.Body.String(), `"status":"ok"`)
+	})
+
+	t.Run("reports ready when the store is reachable", func(t *testing.T) {
+		response := serve(inmem.NewStore(), "/readyz")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		var body readiness
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, body.Status, "ok")
+		testutil.AssertEqual(t, body.Checks["store"].Status, "ok")
+		testutil.AssertEqual(t, body.Checks["templates"].Status, "ok")
+	})
+
+	t.Run("responds with 503 and the failing check when the store is unreachable", func(t *testing.T) {
+		response := serve(unreachableStore{inmem.NewStore()}, "/readyz")
+
+		testutil.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+		// The store's error is logged, not served
+		if strings.Contains(response.Body.String(), "server selection") {
+			t.Errorf("response should not include the store error, got %s", response.Body)
+		}
+		var body readiness
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, body.Status, "unavailable")
+		testutil.AssertEqual(t, body.Checks["store"].Status, "error")
+		testutil.AssertEqual(t, body.Checks["templates"].Status, "ok")
+	})
+
+	t.Run("reuses a recent result", func(t *testing.T) {
+		store := &pingCounter{Store: inmem.NewStore()}
+		server := NewServer(NewAPIService(ServiceConfig{Store: store}))
+		for n := 0; n < 3; n++ {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			testutil.AssertStatus(t, response.Code, http.StatusOK)
+		}
+		testutil.AssertEqual(t, store.pings, 1)
+	})
+
+	t.Run("doesn't hold other probes behind a slow check", func(t *testing.T) {
+		store := slowPinger{Store: inmem.NewStore(), started: make(chan struct{}), release: make(chan struct{})}
+		server := NewServer(NewAPIService(ServiceConfig{Store: store}))
+		first := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		}()
+		<-store.started
+
+		// A probe that gives up returns without waiting for the running check
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx))
+		testutil.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+
+		close(store.release)
+		<-done
+		testutil.AssertStatus(t, first.Code, http.StatusOK)
+	})
+
+	t.Run("doesn't fail the check when the probe is cancelled", func(t *testing.T) {
+		store := slowPinger{Store: inmem.NewStore(), started: make(chan struct{}), release: make(chan struct{})}
+		server := NewServer(NewAPIService(ServiceConfig{Store: store}))
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-store.started
+			cancel()
+			close(store.release)
+		}()
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx))
+
+		// The cached result is the store's, not the cancellation's
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+	})
+}
+
+// FailingStore fails lookups the way a store that lost its database connection would.
+type failingStore struct {
+	*inmem.Store
+}
+
+func (f failingStore) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	return shorty.Link{}, errors.New("server selection timeout")
+}
+
+func TestServeResolver(t *testing.T) {
+	t.Run("renders the error page without redirecting when the store fails", func(t *testing.T) {
+		// Without an error reporting client, the error is only logged
+		service := NewAPIService(ServiceConfig{Store: failingStore{inmem.NewStore()}})
+		response := httptest.NewRecorder()
+
+		NewServer(service).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+
+		testutil.AssertStatus(t, response.Code, http.StatusInternalServerError)
+		testutil.AssertEqual(t, response.Header().Get("Location"), "")
+		testutil.AssertContains(t, response.Body.String(), "<title>system</title>")
+	})
+
+	t.Run("records a click event for the redirect", func(t *testing.T) {
+		store := inmem.NewStore()
+		store.Store["abc123"] = shorty.Link{Code: "abc123", OriginalUrl: "https://operationspark.org"}
+		recorder := &sliceRecorder{}
+		service := NewAPIService(ServiceConfig{
+			Store:         store,
+			ClickRecorder: recorder,
+			IPSalt:        "test-salt",
+			CountryHeader: "X-Appengine-Country",
+		})
+		request := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		request.Header.Set("Referer", "https://mail.example.com/inbox?token=secret")
+		request.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1")
+		// The client can send any X-Forwarded-For. The load balancer appends the address it saw.
+		request.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+		request.Header.Set("X-Appengine-Country", "us")
+		response := httptest.NewRecorder()
+
+		NewServer(service).ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusTemporaryRedirect)
+		testutil.AssertEqual(t, len(recorder.clicks), 1)
+		click := recorder.clicks[0]
+		testutil.AssertEqual(t, click.Code, "abc123")
+		testutil.AssertEqual(t, click.Referrer, "https://mail.example.com/inbox")
+		testutil.AssertEqual(t, click.UserAgent.Browser, "Safari")
+		testutil.AssertEqual(t, click.UserAgent.Device, "mobile")
+		testutil.AssertEqual(t, click.IPHash, shorty.HashIP("203.0.113.7", "test-salt"))
+		testutil.AssertEqual(t, click.Country, "US")
+		testutil.AssertEqual(t, click.Bot, false)
+	})
+
+	t.Run("suggests similar public codes on the not found page", func(t *testing.T) {
+		store := inmem.NewStore()
+		store.Store["fall-cohort"] = shorty.Link{Code: "fall-cohort", OriginalUrl: "https://operationspark.org"}
+		store.Store["fall-cohorts"] = shorty.Link{Code: "fall-cohorts", OriginalUrl: "https://operationspark.org", Private: true}
+		render := func(config ServiceConfig) string {
+			request := httptest.NewRequest(http.MethodGet, "/fal-cohort", nil)
+			request.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0")
+			response := httptest.NewRecorder()
+			NewServer(NewAPIService(config)).ServeHTTP(response, request)
+			testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+			return response.Body.String()
+		}
+
+		page := render(ServiceConfig{Store: store, SuggestDistance: 2})
+		testutil.AssertContains(t, page, `Did you mean`)
+		testutil.AssertContains(t, page, `<a href="/fall-cohort"><code>fall-cohort</code></a>?`)
+		if strings.Contains(page, "fall-cohorts") {
+			t.Error("suggested a private link")
+		}
+
+		if page := render(ServiceConfig{Store: store, SuggestDistance: -1}); strings.Contains(page, "Did you mean") {
+			t.Error("suggested codes with suggestions off")
+		}
+	})
+
+	t.Run("redirects link previews but counts them as bots", func(t *testing.T) {
+		store := inmem.NewStore()
+		store.Store["abc123"] = shorty.Link{Code: "abc123", OriginalUrl: "https://operationspark.org"}
+		recorder := &sliceRecorder{}
+		service := NewAPIService(ServiceConfig{Store: store, ClickRecorder: recorder})
+
+		get := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		get.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+		head := httptest.NewRequest(http.MethodHead, "/abc123", nil)
+		head.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36")
+		for _, request := range []*http.Request{get, head} {
+			response := httptest.NewRecorder()
+			NewServer(service).ServeHTTP(response, request)
+			testutil.AssertStatus(t, response.Code, http.StatusTemporaryRedirect)
+		}
+
+		link := store.Store["abc123"]
+		testutil.AssertEqual(t, link.TotalClicks, 0)
+		testutil.AssertEqual(t, link.BotClicks, 2)
+		testutil.AssertEqual(t, link.HumanClicks, 0)
+		testutil.AssertEqual(t, len(recorder.clicks), 2)
+		testutil.AssertEqual(t, recorder.clicks[0].Bot, true)
+	})
+}
+
+// SliceRecorder keeps click events in memory.
+type sliceRecorder struct {
+	clicks []shorty.Click
+}
+
+func (s *sliceRecorder) Record(c shorty.Click) {
+	s.clicks = append(s.clicks, c)
+}
+
+func TestBulkLinks(t *testing.T) {
+	newServer := func(store LinkStore) http.Handler {
+		return NewServer(NewAPIService(ServiceConfig{Store: store, APIkey: "test-api-key", BaseURL: "https://ospk.org"}))
+	}
+	decode := func(t *testing.T, response *httptest.ResponseRecorder) importResult {
+		t.Helper()
+		var res importResult
+		if err := json.NewDecoder(response.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	t.Run("exports every link as CSV", func(t *testing.T) {
+		store := inmem.NewStore()
+		store.Store = map[string]shorty.Link{
+			"abc123": {Code: "abc123", OriginalUrl: "https://operationspark.org"},
+			"def456": {Code: "def456", OriginalUrl: "https://ospk.org"},
+		}
+		response := httptest.NewRecorder()
+
+		newServer(store).ServeHTTP(response, newRequestWithAPIKey(http.MethodGet, "/api/urls/export?format=csv", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, response.Header().Get("Content-Type"), "text/csv; charset=utf-8")
+		lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+		testutil.AssertEqual(t, len(lines), 3)
+		testutil.AssertContains(t, lines[1], "abc123")
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		newServer(inmem.NewStore()).ServeHTTP(response, newRequestWithAPIKey(http.MethodGet, "/api/urls/export?format=xml", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("imports JSON lines and reports each row", func(t *testing.T) {
+		store := inmem.NewStore()
+		body := `{"code":"abc123","originalUrl":"https://operationspark.org","totalClicks":4}
+{"originalUrl":"not a url"}
+{"originalUrl":"https://ospk.org"}
+`
+		response := httptest.NewRecorder()
+
+		newServer(store).ServeHTTP(response, newRequestWithAPIKey(http.MethodPost, "/api/urls/import", body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		res := decode(t, response)
+		testutil.AssertEqual(t, res.Created, 2)
+		testutil.AssertEqual(t, res.Failed, 1)
+		testutil.AssertEqual(t, res.Rows[1].Status, "error")
+
+		link, err := store.FindLink(context.Background(), "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, link.TotalClicks, 4)
+		testutil.AssertEqual(t, link.ShortURL, "https://ospk.org/abc123")
+	})
+
+	t.Run("resolves conflicts with the conflict mode", func(t *testing.T) {
+		body := "code,originalUrl\nabc123,https://example.com\ndef456,https://ospk.org\n"
+		tests := []struct {
+			conflict   string
+			wantStatus int
+			wantRow    string
+			wantURL    string
+			wantSaved  bool
+		}{
+			{ConflictSkip, http.StatusOK, "skipped", "https://operationspark.org", true},
+			{ConflictUpsert, http.StatusOK, "updated", "https://example.com", true},
+			{ConflictFail, http.StatusConflict, "conflict", "https://operationspark.org", false},
+		}
+
+		for _, c := range tests {
+			store := inmem.NewStore()
+			store.Store = map[string]shorty.Link{
+				"abc123": {Code: "abc123", OriginalUrl: "https://operationspark.org"},
+			}
+			response := httptest.NewRecorder()
+
+			newServer(store).ServeHTTP(response, newRequestWithAPIKey(http.MethodPost, "/api/urls/import?format=csv&conflict="+c.conflict, body))
+
+			testutil.AssertStatus(t, response.Code, c.wantStatus)
+			res := decode(t, response)
+			testutil.AssertEqual(t, res.Rows[0].Status, c.wantRow)
+			link, _ := store.FindLink(context.Background(), "abc123")
+			testutil.AssertEqual(t, link.OriginalUrl, c.wantURL)
+			// Fail stops at the conflict, so the second row isn't imported
+			inUse, _ := store.CheckCodeInUse(context.Background(), "def456")
+			testutil.AssertEqual(t, inUse, c.wantSaved)
+		}
+	})
+
+	t.Run("reports conflicts from another shortener's export without importing", func(t *testing.T) {
+		store := inmem.NewStore()
+		store.Store = map[string]shorty.Link{
+			"taken": {Code: "taken", OriginalUrl: "https://operationspark.org"},
+		}
+		body := "keyword,url,timestamp,clicks\ntaken,https://example.com,2020-03-04 18:15:25,3\nfree,https://ospk.org,2020-03-04 18:15:25,5\nfree,https://ospk.org/again,,0\n"
+		response := httptest.NewRecorder()
+
+		newServer(store).ServeHTTP(response, newRequestWithAPIKey(http.MethodPost, "/api/urls/import?source=yourls&format=csv&dryRun=true", body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		res := decode(t, response)
+		testutil.AssertEqual(t, res.DryRun, true)
+		testutil.AssertEqual(t, res.Created, 1)
+		testutil.AssertEqual(t, res.Conflicts, 2)
+		testutil.AssertEqual(t, res.Rows[0].Status, "conflict")
+		testutil.AssertEqual(t, res.Rows[2].Status, "conflict")
+
+		inUse, _ := store.CheckCodeInUse(context.Background(), "free")
+		testutil.AssertEqual(t, inUse, false)
+	})
+
+	t.Run("counts rows past the listed ones", func(t *testing.T) {
+		var body strings.Builder
+		body.WriteString("originalUrl\n")
+		for n := 0; n < maxImportRows+5; n++ {
+			body.WriteString("https://operationspark.org\n")
+		}
+		response := httptest.NewRecorder()
+
+		newServer(inmem.NewStore()).ServeHTTP(response, newRequestWithAPIKey(http.MethodPost, "/api/urls/import?format=csv&dryRun=true", body.String()))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		res := decode(t, response)
+		testutil.AssertEqual(t, res.Created, maxImportRows+5)
+		testutil.AssertEqual(t, len(res.Rows), maxImportRows)
+		testutil.AssertEqual(t, res.OmittedRows, 5)
+	})
+
+	t.Run("responds with 413 when the body is too large", func(t *testing.T) {
+		body := "code,originalUrl\nabc123,https://operationspark.org/" + strings.Repeat("a", maxImportBytes) + "\n"
+		response := httptest.NewRecorder()
+
+		newServer(inmem.NewStore()).ServeHTTP(response, newRequestWithAPIKey(http.MethodPost, "/api/urls/import?format=csv", body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusRequestEntityTooLarge)
+		res := decode(t, response)
+		testutil.AssertEqual(t, res.Failed, 1)
+	})
+}
+
+func TestClickStats(t *testing.T) {
+	store := inmem.NewStore()
+	store.Store["abc123"] = shorty.Link{Code: "abc123", OriginalUrl: "https://operationspark.org"}
+	day := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+	chrome := shorty.UserAgent{Browser: "Chrome", OS: "Windows", Device: "desktop"}
+	safari := shorty.UserAgent{Browser: "Safari", OS: "iOS", Device: "mobile"}
+	err := store.SaveClicks(context.Background(), []shorty.Click{
+		// Two visitors, one of them clicking twice
+		{Code: "abc123", At: day.Add(time.Hour), UserAgent: chrome, Referrer: "https://mail.google.com/", IPHash: "visitor1"},
+		{Code: "abc123", At: day.Add(2 * time.Hour), UserAgent: safari, IPHash: "visitor2"},
+		{Code: "abc123", At: day.Add(50 * time.Hour), UserAgent: chrome, Referrer: "https://mail.google.com/", IPHash: "visitor1"},
+		// Outside the range, and another link
+		{Code: "abc123", At: day.Add(-time.Hour), UserAgent: chrome},
+		{Code: "xyz789", At: day.Add(time.Hour), UserAgent: chrome},
+		// A link preview
+		{Code: "abc123", At: day.Add(3 * time.Hour), UserAgent: shorty.UserAgent{Browser: "Other"}, IPHash: "crawler", Bot: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewAPIService(ServiceConfig{Store: store, ClickStore: store, APIkey: "test-api-key"})
+
+	t.Run("counts clicks per interval with zero-filled buckets", func(t *testing.T) {
+		response := serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123/stats?from=2023-10-16&to=2023-10-19&interval=day", "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		var stats shorty.ClickStats
+		if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, stats.Total, 3)
+		testutil.AssertEqual(t, stats.UniqueVisitors, 2)
+		testutil.AssertEqual(t, len(stats.Series), 3)
+		testutil.AssertEqual(t, stats.Series[0], shorty.StatsBucket{Start: day, Clicks: 2})
+		testutil.AssertEqual(t, stats.Series[1], shorty.StatsBucket{Start: day.AddDate(0, 0, 1), Clicks: 0})
+		testutil.AssertEqual(t, stats.Series[2], shorty.StatsBucket{Start: day.AddDate(0, 0, 2), Clicks: 1})
+		testutil.AssertEqual(t, len(stats.Browsers), 2)
+		testutil.AssertEqual(t, stats.Browsers[0], shorty.StatsCount{Value: "Chrome", Clicks: 2})
+		testutil.AssertEqual(t, len(stats.Referrers), 2)
+		// Direct traffic has no referrer
+		testutil.AssertEqual(t, stats.Referrers[1], shorty.StatsCount{Value: "", Clicks: 1})
+	})
+
+	t.Run("reports unique visitors with the link", func(t *testing.T) {
+		response := serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123", "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContains(t, response.Body.String(), `"uniqueVisitors":2`)
+	})
+
+	t.Run("counts bots only when asked", func(t *testing.T) {
+		response := serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123/stats?from=2023-10-16&to=2023-10-19&bots=true", "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		var stats shorty.ClickStats
+		if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, stats.Total, 4)
+	})
+
+	t.Run("rejects invalid queries", func(t *testing.T) {
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123/stats?bots=maybe", "").Code, http.StatusBadRequest)
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123/stats?interval=month", "").Code, http.StatusBadRequest)
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123/stats?from=2023-10-19&to=2023-10-16", "").Code, http.StatusBadRequest)
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/urls/abc123/stats?from=2000-01-01&interval=hour", "").Code, http.StatusBadRequest)
+	})
+
+	t.Run("responds with 404 for unknown links", func(t *testing.T) {
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/urls/missing/stats", "").Code, http.StatusNotFound)
+	})
+}
+
+func TestMisses(t *testing.T) {
+	store := inmem.NewStore()
+	service := NewAPIService(ServiceConfig{Store: store, MissStore: store, APIkey: "test-api-key"})
+	resolve := func(path, referer, userAgent string) {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Referer", referer)
+		request.Header.Set("User-Agent", userAgent)
+		response := httptest.NewRecorder()
+		NewServer(service).ServeHTTP(response, request)
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+	}
+
+	const browser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36"
+	resolve("/fal-cohort", "", browser)
+	resolve("/fal-cohort", "https://operationspark.org/events?utm_source=flyer", browser)
+	resolve("/fal-cohort", "https://operationspark.org/events", browser)
+	resolve("/created-later", "", browser)
+	// Not recorded
+	resolve("/fal-cohort", "", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	resolve("/"+strings.Repeat("x", 100), "", browser)
+	store.Store["created-later"] = shorty.Link{Code: "created-later", OriginalUrl: "https://operationspark.org"}
+
+	t.Run("lists the most missed codes that still have no link", func(t *testing.T) {
+		response := serveWithAPIKey(service, http.MethodGet, "/api/misses", "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		var misses []shorty.MissCount
+		if err := json.NewDecoder(response.Body).Decode(&misses); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(misses), 1)
+		testutil.AssertEqual(t, misses[0].Code, "fal-cohort")
+		testutil.AssertEqual(t, misses[0].Misses, 3)
+		testutil.AssertEqual(t, len(misses[0].Referrers), 2)
+		testutil.AssertEqual(t, misses[0].Referrers[0], shorty.StatsCount{Value: "https://operationspark.org/events", Clicks: 2})
+	})
+
+	t.Run("leaves out codes missed before since", func(t *testing.T) {
+		response := serveWithAPIKey(service, http.MethodGet, "/api/misses?since="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339), "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, strings.TrimSpace(response.Body.String()), "[]")
+	})
+
+	t.Run("rejects invalid queries", func(t *testing.T) {
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/misses?limit=0", "").Code, http.StatusBadRequest)
+		testutil.AssertStatus(t, serveWithAPIKey(service, http.MethodGet, "/api/misses?since=yesterday", "").Code, http.StatusBadRequest)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	t.Setenv("GCP_PROJECT_ID", "shorty-test")
+
+	t.Run("continues the trace in the traceparent header", func(t *testing.T) {
+		spans := tracetest.NewSpanRecorder()
+		store := inmem.NewStore()
+		store.Store = map[string]shorty.Link{"abc123": {Code: "abc123", OriginalUrl: "https://operationspark.org"}}
+		service := NewAPIService(ServiceConfig{
+			Store:          store,
+			TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		})
+
+		request := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		request.Header.Set("traceparent", traceparent)
+		NewServer(service).ServeHTTP(httptest.NewRecorder(), request)
+
+		ended := spans.Ended()
+		testutil.AssertEqual(t, len(ended), 1)
+		testutil.AssertEqual(t, ended[0].Name(), "GET /")
+		testutil.AssertEqual(t, ended[0].SpanContext().TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+		testutil.AssertEqual(t, ended[0].Parent().SpanID().String(), "00f067aa0ba902b7")
+	})
+
+	t.Run("logs with the W3C trace before the Cloud trace header", func(t *testing.T) {
+		service := NewAPIService(ServiceConfig{Store: inmem.NewStore()})
+		request := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		request.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+
+		testutil.AssertEqual(t, service.getTrace(request), "projects/shorty-test/traces/105445aa7843bc8bf206b12000100000")
+		request.Header.Set("traceparent", traceparent)
+		testutil.AssertEqual(t, service.getTrace(request), "projects/shorty-test/traces/4bf92f3577b34da6a3ce929d0e0e4736")
+	})
+
+	t.Run("logs the bare trace ID without a project", func(t *testing.T) {
+		t.Setenv("GCP_PROJECT_ID", "")
+		t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+		service := NewAPIService(ServiceConfig{Store: inmem.NewStore()})
+		request := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		request.Header.Set("traceparent", traceparent)
+
+		testutil.AssertEqual(t, service.getTrace(request), "4bf92f3577b34da6a3ce929d0e0e4736")
+		request.Header.Del("traceparent")
+		testutil.AssertEqual(t, service.getTrace(request), "")
+	})
+}
+
+func TestLogRequests(t *testing.T) {
+	t.Setenv("GCP_PROJECT_ID", "shorty-test")
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	store := inmem.NewStore()
+	store.Store = map[string]shorty.Link{"abc123": {Code: "abc123", OriginalUrl: "https://operationspark.org"}}
+	serve := func(t *testing.T, store LinkStore, sampleRate float64, path string) []gcp.LogEntry {
+		t.Helper()
+		logs.Reset()
+		service := NewAPIService(ServiceConfig{Store: store, APIkey: "test-api-key"})
+		request := newRequestWithAPIKey(http.MethodGet, path, "")
+		request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		request.Header.Set("Referer", "https://mail.example.com/inbox?token=secret")
+		request.Header.Set("X-Api-Secret", "secret")
+		service.LogRequests(NewServer(service), sampleRate).ServeHTTP(httptest.NewRecorder(), request)
+
+		var entries []gcp.LogEntry
+		dec := json.NewDecoder(&logs)
+		for dec.More() {
+			var entry gcp.LogEntry
+			if err := dec.Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+			// Skip errors logged by the handlers
+			if entry.HTTPRequest != nil {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	t.Run("logs the request with its trace and link code", func(t *testing.T) {
+		entries := serve(t, store, 1, "/abc123?utm_source=flyer")
+
+		testutil.AssertEqual(t, len(entries), 1)
+		entry := entries[0]
+		testutil.AssertEqual(t, entry.HTTPRequest.Status, http.StatusTemporaryRedirect)
+		testutil.AssertEqual(t, entry.HTTPRequest.RequestURL, "/abc123?utm_source=flyer")
+		testutil.AssertEqual(t, entry.HTTPRequest.ResponseSize > 0, true)
+		testutil.AssertEqual(t, entry.Trace, "projects/shorty-test/traces/4bf92f3577b34da6a3ce929d0e0e4736")
+		testutil.AssertEqual(t, entry.Labels["code"], "abc123")
+		testutil.AssertEqual(t, entry.RequestHeaders["Key"], "REDACTED")
+	})
+
+	t.Run("leaves private request details out", func(t *testing.T) {
+		entry := serve(t, store, 1, "/abc123")[0]
+
+		testutil.AssertEqual(t, entry.HTTPRequest.Referer, "https://mail.example.com/inbox")
+		// Only allowed headers are logged
+		testutil.AssertEqual(t, len(entry.RequestHeaders), 1)
+		testutil.AssertEqual(t, entry.RequestHeaders["Key"], "REDACTED")
+	})
+
+	t.Run("always logs server errors when sampling", func(t *testing.T) {
+		testutil.AssertEqual(t, len(serve(t, store, 0, "/abc123")), 0)
+
+		entries := serve(t, failingStore{store}, 0, "/abc123")
+		testutil.AssertEqual(t, len(entries), 1)
+		testutil.AssertEqual(t, entries[0].Severity, "ERROR")
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+)
+
+// Time allowed for each readiness check. Load balancers usually give up after a few seconds.
+const readyTimeout = 2 * time.Second
+
+// How long a readiness result is reused, so probes from several load balancers don't each ping the store.
+const readyCacheTTL = 5 * time.Second
+
+type (
+	readiness struct {
+		// "ok" when every check passed, otherwise "unavailable".
+		Status string                    `json:"status"`
+		Checks map[string]readinessCheck `json:"checks"`
+	}
+
+	readinessCheck struct {
+		Status  string `json:"status"`
+		Latency string `json:"latency"`
+		// Logged rather than served, since store errors can name hosts.
+		err error
+	}
+
+	// ReadyCache holds the last readiness result and when it was checked.
+	readyCache struct {
+		lock      sync.Mutex
+		checkedAt time.Time
+		res       readiness
+		// Set while the checks run, and closed when they finish, so concurrent probes wait for them instead of checking again.
+		running chan struct{}
+	}
+)
+
+// ServeHealth responds 200 while the process is able to serve requests. It doesn't check dependencies.
+func (s *ShortyService) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ServeReady responds 200 when the store is reachable and the HTML templates load, or 503 with the failing checks.
+// Why a check failed is only logged.
+func (s *ShortyService) ServeReady(w http.ResponseWriter, r *http.Request) {
+	res := s.checkReady(r)
+	status := http.StatusOK
+	if res.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// CheckReady runs the readiness checks, or returns the last result if it's under readyCacheTTL old.
+// Probes arriving while the checks run wait for their result. Failed checks are logged when they run.
+func (s *ShortyService) checkReady(r *http.Request) readiness {
+	c := &s.ready
+	c.lock.Lock()
+	if time.Since(c.checkedAt) < readyCacheTTL {
+		defer c.lock.Unlock()
+		return c.res
+	}
+	if running := c.running; running != nil {
+		c.lock.Unlock()
+		select {
+		case <-running:
+		case <-r.Context().Done():
+			// The prober has gone away, so the result is never read
+			return readiness{Status: "unavailable"}
+		}
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.res
+	}
+	running := make(chan struct{})
+	c.running = running
+	c.lock.Unlock()
+
+	res := s.runReadyChecks(r)
+
+	c.lock.Lock()
+	c.res, c.checkedAt, c.running = res, time.Now(), nil
+	c.lock.Unlock()
+	close(running)
+	return res
+}
+
+// RunReadyChecks runs every readiness check and logs the ones that fail.
+// The checks are shared with other probes, so they aren't cut short when this request is cancelled.
+func (s *ShortyService) runReadyChecks(r *http.Request) readiness {
+	ctx := context.WithoutCancel(r.Context())
+	res := readiness{
+		Status: "ok",
+		Checks: map[string]readinessCheck{
+			"store":     runCheck(ctx, s.store.Ping),
+			"templates": runCheck(ctx, checkTemplates),
+		},
+	}
+	for name, c := range res.Checks {
+		if c.err != nil {
+			res.Status = "unavailable"
+			log.Println(gcp.LogEntry{
+				Severity:  "WARNING",
+				Message:   "readiness check " + name + " failed: " + c.err.Error(),
+				Component: s.serviceName,
+				Trace:     s.getTrace(r),
+			})
+		}
+	}
+	return res
+}
+
+// RunCheck runs check with the readiness timeout and records how long it took.
+func runCheck(ctx context.Context, check func(context.Context) error) readinessCheck {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	c := readinessCheck{Status: "ok", Latency: time.Since(start).String(), err: err}
+	if err != nil {
+		c.Status = "error"
+	}
+	return c
+}
+
+// CheckTemplates parses the embedded HTML templates used by the not found and server error pages.
+func checkTemplates(ctx context.Context) error {
+	_, err := template.ParseFS(content, "html/*.html")
+	return err
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Longest unknown code that is recorded as a miss or gets suggestions. Longer paths are probes, not typos.
+const maxTypoCodeLen = 64
+
+// Number of codes listed by GET /api/misses, by default and at most
+const (
+	defaultMissLimit = 50
+	maxMissLimit     = 500
+)
+
+// Range of GET /api/misses without "since"
+const defaultMissRange = 30 * 24 * time.Hour
+
+// RecordMiss counts a request for an unknown code, if a MissStore is configured, using the buffered MissRecorder if there is one.
+// Bots are skipped, since crawlers request dead and made up links all the time.
+func (s *ShortyService) recordMiss(r *http.Request, code string, bot bool) {
+	if s.missStore == nil || bot || len(code) == 0 || len(code) > maxTypoCodeLen {
+		return
+	}
+	m := shorty.Miss{Code: code, Referrer: referrer(r), At: time.Now().UTC()}
+	if s.missRecorder != nil {
+		s.missRecorder.Record(m)
+		return
+	}
+	err := s.missStore.RecordMisses(r.Context(), []shorty.MissTally{shorty.NewMissTally(m)})
+	if err != nil {
+		// Render the not found page even if there is an error
+		fmt.Fprintf(os.Stderr, "could not record miss: %v", err)
+	}
+}
+
+// GetMisses lists the most requested unknown codes, so links can be created for them.
+func (s *ShortyService) getMisses(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.missStore == nil {
+		http.Error(w, "Misses aren't recorded", http.StatusNotImplemented)
+		return
+	}
+
+	q, err := parseMissQuery(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	misses, err := s.missStore.TopMisses(r.Context(), q)
+	if err != nil {
+		s.logError(fmt.Errorf("getMisses: TopMisses: %v", err), s.getTrace(r))
+		http.Error(w, "Could not retrieve misses", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(misses); err != nil {
+		s.logError(fmt.Errorf("getMisses: encode: %v", err), s.getTrace(r))
+	}
+}
+
+// ParseMissQuery reads "since", which defaults to 30 days ago, and "limit".
+func parseMissQuery(r *http.Request, now time.Time) (shorty.MissQuery, error) {
+	q := shorty.MissQuery{Since: now.Add(-defaultMissRange), Limit: defaultMissLimit}
+
+	var err error
+	if v := r.URL.Query().Get("since"); len(v) > 0 {
+		if q.Since, err = parseStatsTime(v); err != nil {
+			return q, fmt.Errorf("since: %v", err)
+		}
+	}
+	if v := r.URL.Query().Get("limit"); len(v) > 0 {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxMissLimit {
+			return q, fmt.Errorf("limit: want 1 to %d, got %q", maxMissLimit, v)
+		}
+	}
+	return q, nil
+}
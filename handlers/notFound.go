@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"os"
 )
 
 //go:embed html
@@ -12,18 +14,26 @@ var content embed.FS
 
 type (
 	notFoundTemplateData struct {
-		Code  string
-		Title string
+		Code        string
+		Title       string
+		Suggestions []suggestion
+	}
+
+	// Suggestion is an existing link with a code like the one requested.
+	suggestion struct {
+		Code string
+		Path string
 	}
 
 	errorTemplateData struct {
+		Title       string
 		Error       string
 		Description string
 	}
 )
 
-// RenderServerError renders and responds a 404 Not Found page for the client.
-func (s *ShortyService) renderNotFound(w http.ResponseWriter, r *http.Request) {
+// RenderNotFound renders and responds a 404 Not Found page for the client, offering links to the suggested codes.
+func (s *ShortyService) renderNotFound(w http.ResponseWriter, r *http.Request, suggested []string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusNotFound)
 	t, err := template.ParseFS(content, "html/not-found.html")
@@ -34,10 +44,14 @@ func (s *ShortyService) renderNotFound(w http.ResponseWriter, r *http.Request) {
 	}
 
 	code := parseLinkCode(r.URL.Path)
-	err = t.Execute(w, notFoundTemplateData{
+	data := notFoundTemplateData{
 		Code:  code,
 		Title: s.serviceName,
-	})
+	}
+	for _, c := range suggested {
+		data.Suggestions = append(data.Suggestions, suggestion{Code: c, Path: "/" + url.PathEscape(c)})
+	}
+	err = t.Execute(w, data)
 	if err != nil {
 		s.logError(fmt.Errorf("unable to render template: %v", err), s.getTrace(r))
 		s.renderServerError(w, r, "")
@@ -45,6 +59,20 @@ func (s *ShortyService) renderNotFound(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SuggestCodes looks up public links with codes like the requested one. Bots don't get suggestions.
+func (s *ShortyService) suggestCodes(r *http.Request, code string, bot bool) []string {
+	if s.suggestDistance < 0 || bot || len(code) == 0 || len(code) > maxTypoCodeLen {
+		return nil
+	}
+	codes, err := s.store.FindSimilarCodes(r.Context(), code, s.suggestDistance)
+	if err != nil {
+		// Render the not found page without suggestions
+		fmt.Fprintf(os.Stderr, "could not find similar codes: %v", err)
+		return nil
+	}
+	return codes
+}
+
 // RenderServerError renders and responds with a generic error page for the client.
 func (s *ShortyService) renderServerError(w http.ResponseWriter, r *http.Request, errMessage string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -62,6 +90,7 @@ func (s *ShortyService) renderServerError(w http.ResponseWriter, r *http.Request
 		description = errMessage
 	}
 	err = t.Execute(w, errorTemplateData{
+		Title:       s.serviceName,
 		Error:       "Ahh! Something broke.",
 		Description: description,
 	})
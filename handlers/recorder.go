@@ -0,0 +1,32 @@
+package handlers
+
+import "net/http"
+
+// StatusRecorder remembers the status code and response size written by a handler.
+// It's shared by the request middleware here and in the metrics package.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+	Size   int64
+}
+
+// NewStatusRecorder wraps w. The status is 200 until the handler writes another.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (rec *StatusRecorder) WriteHeader(status int) {
+	rec.Status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *StatusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.Size += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rec *StatusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
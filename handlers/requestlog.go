@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+)
+
+// Request headers logged with their values. Others are left out, since they can hold credentials or personal data.
+var loggedHeaders = []string{
+	"Accept",
+	"Accept-Language",
+	"Content-Type",
+	// Prefetches, which bots.IsBot counts as bots
+	"Purpose",
+	"Sec-Purpose",
+}
+
+// Credential headers logged as REDACTED when they're sent, so failed API calls show whether a key was given.
+var redactedHeaders = []string{
+	"Key",
+	"Authorization",
+	"Cookie",
+}
+
+// RequestLog holds what handlers learn about a request for its log entry, like the code it resolved.
+type requestLog struct {
+	code  string
+	trace string
+}
+
+type requestLogKey struct{}
+
+// LogRequests wraps next, typically the mux from NewServer, and logs an entry for a sampleRate fraction of requests,
+// from 0 to 1. Server errors are always logged. Each entry has the Cloud Logging httpRequest, the trace, and the link code.
+func (s *ShortyService) LogRequests(next http.Handler, sampleRate float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		details := &requestLog{}
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, details)))
+		latency := time.Since(start)
+
+		if rec.Status < 500 && rand.Float64() >= sampleRate {
+			return
+		}
+		if len(details.trace) == 0 {
+			details.trace = s.getTrace(r)
+		}
+		log.Println(requestLogEntry(r, rec, latency, details))
+	})
+}
+
+func requestLogEntry(r *http.Request, rec *StatusRecorder, latency time.Duration, details *requestLog) gcp.LogEntry {
+	severity := "INFO"
+	switch {
+	case rec.Status >= 500:
+		severity = "ERROR"
+	case rec.Status >= 400:
+		severity = "WARNING"
+	}
+
+	entry := gcp.LogEntry{
+		Message:   fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.Status),
+		Severity:  severity,
+		Trace:     details.trace,
+		Component: "requests",
+		HTTPRequest: &gcp.HTTPRequest{
+			RequestMethod: r.Method,
+			RequestURL:    r.URL.RequestURI(),
+			RequestSize:   max(r.ContentLength, 0),
+			Status:        rec.Status,
+			ResponseSize:  rec.Size,
+			UserAgent:     r.UserAgent(),
+			RemoteIP:      clientIP(r),
+			Referer:       referrer(r),
+			Latency:       fmt.Sprintf("%.9fs", latency.Seconds()),
+			Protocol:      r.Proto,
+		},
+		RequestHeaders: logHeaders(r.Header),
+	}
+	if len(details.code) > 0 {
+		entry.Labels = map[string]string{"code": details.code}
+	}
+	return entry
+}
+
+// LogHeaders flattens the allowed headers in h for logging, replacing credentials like the API key.
+func logHeaders(h http.Header) map[string]string {
+	out := map[string]string{}
+	for _, name := range loggedHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			out[name] = strings.Join(values, ", ")
+		}
+	}
+	for _, name := range redactedHeaders {
+		if len(h.Values(name)) > 0 {
+			out[name] = "REDACTED"
+		}
+	}
+	return out
+}
+
+// SetLoggedCode records the link code a request is for in its log entry, if it's logged.
+func setLoggedCode(ctx context.Context, code string) {
+	if details, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		details.code = code
+	}
+}
+
+// SetLoggedTrace records the trace of a request's span in its log entry, which is only known once the span starts.
+func setLoggedTrace(ctx context.Context, trace string) {
+	if details, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		details.trace = trace
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Range of a stats query without "from", per interval
+var defaultStatsRange = map[string]time.Duration{
+	shorty.IntervalHour: 24 * time.Hour,
+	shorty.IntervalDay:  30 * 24 * time.Hour,
+	shorty.IntervalWeek: 12 * 7 * 24 * time.Hour,
+}
+
+// GetStats responds with a link's clicks over time.
+func (s *ShortyService) getStats(w http.ResponseWriter, r *http.Request, code string) {
+	if s.clickStore == nil {
+		http.Error(w, "Click stats aren't available", http.StatusNotImplemented)
+		return
+	}
+
+	q, err := parseStatsQuery(r, code, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.store.FindLink(r.Context(), code); err != nil {
+		if errors.Is(err, shorty.ErrLinkNotFound) {
+			http.Error(w, fmt.Sprintf("Link not found: %q", code), http.StatusNotFound)
+			return
+		}
+		s.logError(fmt.Errorf("getStats: FindLink: %v", err), s.getTrace(r))
+		http.Error(w, "Could not retrieve link", http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := s.clickStore.ClickStats(r.Context(), q)
+	if err != nil {
+		s.logError(fmt.Errorf("getStats: ClickStats: %v", err), s.getTrace(r))
+		http.Error(w, "Could not retrieve stats", http.StatusInternalServerError)
+		return
+	}
+	stats.UniqueVisitors, err = s.clickStore.UniqueVisitors(r.Context(), code, q.From, q.To)
+	if err != nil {
+		s.logError(fmt.Errorf("getStats: UniqueVisitors: %v", err), s.getTrace(r))
+		http.Error(w, "Could not retrieve stats", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		s.logError(fmt.Errorf("getStats: encode: %v", err), s.getTrace(r))
+	}
+}
+
+// ParseStatsQuery reads "from", "to" and "interval". "to" defaults to now, "interval" to a day,
+// and "from" to a range that suits the interval.
+func parseStatsQuery(r *http.Request, code string, now time.Time) (shorty.StatsQuery, error) {
+	q := shorty.StatsQuery{Code: code, To: now, Interval: r.URL.Query().Get("interval")}
+	if len(q.Interval) == 0 {
+		q.Interval = shorty.IntervalDay
+	}
+
+	var err error
+	if v := r.URL.Query().Get("to"); len(v) > 0 {
+		if q.To, err = parseStatsTime(v); err != nil {
+			return q, fmt.Errorf("to: %v", err)
+		}
+	}
+	if v := r.URL.Query().Get("from"); len(v) > 0 {
+		if q.From, err = parseStatsTime(v); err != nil {
+			return q, fmt.Errorf("from: %v", err)
+		}
+	} else {
+		q.From = q.To.Add(-defaultStatsRange[q.Interval])
+	}
+	if v := r.URL.Query().Get("bots"); len(v) > 0 {
+		if q.IncludeBots, err = strconv.ParseBool(v); err != nil {
+			return q, fmt.Errorf("bots: want true or false, got %q", v)
+		}
+	}
+	return q, q.Validate()
+}
+
+// ParseStatsTime accepts RFC 3339 times and dates, ex: "2023-10-18", which are midnight UTC.
+func parseStatsTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return t, fmt.Errorf("want an RFC 3339 time or a date, got %q", v)
+	}
+	return t, nil
+}
+
+// ParseStatsPath returns the code from a path like /api/urls/abc123/stats.
+func parseStatsPath(path string) (string, bool) {
+	rest := strings.Trim(strings.TrimPrefix(path, "/api/urls/"), "/")
+	code, ok := strings.CutSuffix(rest, "/stats")
+	if !ok || len(code) == 0 || strings.Contains(code, "/") {
+		return "", false
+	}
+	return code, true
+}
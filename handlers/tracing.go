@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer that records handler spans
+const tracerName = "github.com/operationspark/shorty/handlers"
+
+// Propagator reads the W3C traceparent and tracestate headers.
+var propagator = propagation.TraceContext{}
+
+// Traced serves h in a span named after its route. The span continues the trace in the request's traceparent header, if any.
+func (s *ShortyService) traced(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := s.tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+		setLoggedTrace(ctx, s.getTrace(r.WithContext(ctx)))
+
+		rec := NewStatusRecorder(w)
+		h(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status))
+		if rec.Status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status))
+		}
+	}
+}
+
+// TraceSpanContext returns the span context traced put on the request, or the one in its traceparent header.
+func traceSpanContext(r *http.Request) trace.SpanContext {
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		return sc
+	}
+	return trace.SpanContextFromContext(propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header)))
+}
@@ -0,0 +1,109 @@
+// Package hll estimates the number of distinct keys with HyperLogLog sketches.
+//
+// A sketch is a fixed 4 KiB of registers, whatever the number of keys, with a standard error of about 1.6%.
+// Sketches of different days or instances merge into the sketch of their union, so they can be stored per day and combined for any range.
+package hll
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision is the number of hash bits that select a register.
+const Precision = 12
+
+// Registers is the number of registers in a sketch.
+const Registers = 1 << Precision
+
+// Sketch is a HyperLogLog sketch. The zero value is an empty sketch.
+type Sketch struct {
+	registers [Registers]uint8
+}
+
+// New returns an empty sketch.
+func New() *Sketch {
+	return &Sketch{}
+}
+
+// Position returns the register key hashes to and the rank stored there, ex: for storing a sketch as individual registers.
+// The hash is stable across processes, so positions from different instances can be merged.
+func Position(key string) (int, uint8) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := mix(h.Sum64())
+
+	idx := int(x >> (64 - Precision))
+	// The guard bit caps the rank when the remaining bits are all zero
+	w := x<<Precision | 1<<(Precision-1)
+	return idx, uint8(bits.LeadingZeros64(w) + 1)
+}
+
+// Add adds key to the sketch.
+func (s *Sketch) Add(key string) {
+	s.Set(Position(key))
+}
+
+// Set raises register idx to rank. Lower ranks are ignored, so registers can be set in any order.
+func (s *Sketch) Set(idx int, rank uint8) {
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+	}
+}
+
+// Register returns the rank in register idx.
+func (s *Sketch) Register(idx int) uint8 {
+	return s.registers[idx]
+}
+
+// Merge adds every key added to o.
+func (s *Sketch) Merge(o *Sketch) {
+	for idx, rank := range o.registers {
+		s.Set(idx, rank)
+	}
+}
+
+// SetRegisters sets the registers from a map of register index to rank, the sparse form sketches are stored in.
+func (s *Sketch) SetRegisters(registers map[int]uint8) error {
+	for idx, rank := range registers {
+		if idx < 0 || idx >= Registers {
+			return fmt.Errorf("register %d out of range", idx)
+		}
+		s.Set(idx, rank)
+	}
+	return nil
+}
+
+// Estimate returns the approximate number of distinct keys added.
+func (s *Sketch) Estimate() int {
+	const m = float64(Registers)
+	sum, zeros := 0.0, 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	if zeros == Registers {
+		return 0
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// Linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// Mix spreads FNV's output over all 64 bits (the SplitMix64 finalizer), since the register index comes from the top bits.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
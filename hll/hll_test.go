@@ -0,0 +1,67 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestSketch(t *testing.T) {
+	t.Run("estimates within a few percent", func(t *testing.T) {
+		for _, n := range []int{10, 1000, 100000} {
+			s := New()
+			for i := 0; i < n; i++ {
+				s.Add(fmt.Sprintf("visitor-%d", i))
+			}
+			if e := s.Estimate(); math.Abs(float64(e-n)) > 0.05*float64(n)+1 {
+				t.Errorf("%d keys: estimated %d", n, e)
+			}
+		}
+	})
+
+	t.Run("ignores repeated keys", func(t *testing.T) {
+		s := New()
+		for i := 0; i < 50; i++ {
+			s.Add("same visitor")
+		}
+		testutil.AssertEqual(t, s.Estimate(), 1)
+		testutil.AssertEqual(t, New().Estimate(), 0)
+	})
+
+	t.Run("merges into the union", func(t *testing.T) {
+		monday, tuesday, both := New(), New(), New()
+		for i := 0; i < 3000; i++ {
+			key := fmt.Sprintf("visitor-%d", i)
+			if i < 2000 {
+				monday.Add(key)
+			}
+			if i >= 1000 {
+				tuesday.Add(key)
+			}
+			both.Add(key)
+		}
+
+		monday.Merge(tuesday)
+		testutil.AssertEqual(t, monday.Estimate(), both.Estimate())
+	})
+
+	t.Run("round trips through sparse registers", func(t *testing.T) {
+		s, sparse := New(), map[int]uint8{}
+		for i := 0; i < 500; i++ {
+			idx, rank := Position(fmt.Sprintf("visitor-%d", i))
+			s.Set(idx, rank)
+			sparse[idx] = max(sparse[idx], rank)
+		}
+
+		restored := New()
+		if err := restored.SetRegisters(sparse); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, restored.Estimate(), s.Estimate())
+		if err := restored.SetRegisters(map[int]uint8{Registers: 1}); err == nil {
+			t.Error("want error for an out of range register")
+		}
+	})
+}
@@ -2,39 +2,59 @@ package inmem
 
 import (
 	"context"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/operationspark/shorty/hll"
 	"github.com/operationspark/shorty/shorty"
 )
 
 // NewStore creates an empty Shorty store.
 func NewStore() *Store {
 	return &Store{
-		map[string]shorty.Link{},
-		sync.RWMutex{},
+		Store: map[string]shorty.Link{},
 	}
 }
 
 // Store stores the short links in memory.
 type Store struct {
 	Store map[string]shorty.Link
+	// Click events in the order they were saved, at most the newest maxClicks. They aren't included in snapshots.
+	Clicks []shorty.Click
+	// Visitor sketches by code and UTC day, for the last maxVisitorDays days, updated by SaveClicks.
+	// They aren't included in snapshots either.
+	Visitors map[string]map[time.Time]*hll.Sketch
+	// Requests for unknown codes, tallied by code and referrer. They aren't included in snapshots either.
+	misses map[missKey]*shorty.MissTally
 	// A mutex is used to synchronize read/write access to the map
 	lock sync.RWMutex
+	// Set when the map changes so snapshots are only written when needed
+	dirty bool
+	// Periodic snapshot flushing. Nil unless created with NewPersistentStore.
+	snapshot *snapshotter
 }
 
 func (i *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
 	i.lock.Lock()
 	defer i.lock.Unlock()
 
+	if _, ok := i.Store[newLink.Code]; ok {
+		return shorty.Link{}, shorty.ErrCodeInUse
+	}
 	i.Store[newLink.Code] = newLink
+	i.dirty = true
 	return newLink, nil
 }
 
 func (i *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
 	i.lock.RLock()
 	defer i.lock.RUnlock()
+	return i.findLink(code)
+}
 
+// FindLink looks up a link without locking. The caller must hold the lock.
+func (i *Store) findLink(code string) (shorty.Link, error) {
 	link, ok := i.Store[code]
 	if !ok {
 		return shorty.Link{}, shorty.ErrLinkNotFound
@@ -45,46 +65,117 @@ func (i *Store) FindLink(ctx context.Context, code string) (shorty.Link, error)
 func (i *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
 	i.lock.RLock()
 	defer i.lock.RUnlock()
-	links := shorty.Links{}
+	links := make(shorty.Links, 0, len(i.Store))
 	for _, l := range i.Store {
-		links = append(links, &l)
+		// Copy so each pointer refers to its own Link
+		link := l
+		links = append(links, &link)
 	}
 	return links, nil
 }
 
-func (i *Store) UpdateLink(ctx context.Context, code string, link shorty.Link) (shorty.Link, error) {
+// EachLink calls fn with every link in order of code. Iteration stops at the first error returned by fn.
+// The links are copied first so fn may call other Store methods.
+func (i *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	i.lock.RLock()
+	links := make([]shorty.Link, 0, len(i.Store))
+	for _, l := range i.Store {
+		links = append(links, l)
+	}
+	i.lock.RUnlock()
+
+	sort.Slice(links, func(a, b int) bool { return links[a].Code < links[b].Code })
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateLink updates a link's originalUrl and private flag if given. If a customCode is given, the link moves to that code
+// and its code, customCode, and shortUrl are updated. The updated link is returned.
+func (i *Store) UpdateLink(ctx context.Context, code string, link shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
 	i.lock.Lock()
 	defer i.lock.Unlock()
-	oldLink, err := i.FindLink(ctx, code)
+	updated, err := i.findLink(code)
 	if err != nil {
-		return link, err
+		return shorty.Link{}, err
 	}
 
-	oldLink.UpdatedAt = time.Now()
+	updated.UpdatedAt = time.Now()
 	if len(link.OriginalUrl) > 0 {
-		oldLink.OriginalUrl = link.OriginalUrl
+		updated.OriginalUrl = link.OriginalUrl
+	}
+	if opts.Private != nil {
+		updated.Private = *opts.Private
 	}
 
-	oldLink.OriginalUrl = link.OriginalUrl
-	if len(link.CustomCode) > 0 {
-		oldLink.Code = link.CustomCode
-		oldLink.CustomCode = link.CustomCode
+	if len(link.CustomCode) > 0 && link.CustomCode != code {
+		if _, ok := i.Store[link.CustomCode]; ok {
+			return shorty.Link{}, shorty.ErrCodeInUse
+		}
+		updated.Code = link.CustomCode
+		updated.CustomCode = link.CustomCode
+		updated.ShortURL = link.ShortURL
+		delete(i.Store, code)
+
+		// Click data follows the link to its new code
+		for n := range i.Clicks {
+			if i.Clicks[n].Code == code {
+				i.Clicks[n].Code = link.CustomCode
+			}
+		}
+		if days, ok := i.Visitors[code]; ok {
+			i.Visitors[link.CustomCode] = days
+			delete(i.Visitors, code)
+		}
 	}
-	i.Store[code] = oldLink
-	return oldLink, nil
+	i.Store[updated.Code] = updated
+	i.dirty = true
+	return updated, nil
 }
 
 func (i *Store) DeleteLink(ctx context.Context, code string) (int, error) {
 	i.lock.Lock()
 	defer i.lock.Unlock()
+	if _, ok := i.Store[code]; !ok {
+		return 0, nil
+	}
 	delete(i.Store, code)
+	i.dirty = true
+
+	clicks := i.Clicks[:0]
+	for _, c := range i.Clicks {
+		if c.Code != code {
+			clicks = append(clicks, c)
+		}
+	}
+	i.Clicks = clicks
+	delete(i.Visitors, code)
 	return 1, nil
 }
 
+// FindSimilarCodes compares code with every public link's code.
+func (i *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	candidates := []string{}
+	for c, link := range i.Store {
+		if !link.Private {
+			candidates = append(candidates, c)
+		}
+	}
+	return shorty.RankSimilarCodes(code, candidates, maxDistance), nil
+}
+
 func (i *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
 	i.lock.RLock()
 	defer i.lock.RUnlock()
-	_, err := i.FindLink(ctx, code)
+	_, err := i.findLink(code)
 	if err != nil {
 		if err == shorty.ErrLinkNotFound {
 			return false, nil
@@ -96,17 +187,193 @@ func (i *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
 	return true, nil
 }
 
+// Ping always succeeds since the links are in memory.
+func (i *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
 func (i *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
 	i.lock.Lock()
 	defer i.lock.Unlock()
-	link, err := i.FindLink(ctx, code)
-	if err != nil {
-		return 0, err
-	}
-	link.TotalClicks++
-	_, err = i.SaveLink(ctx, link)
+	link, err := i.findLink(code)
 	if err != nil {
 		return 0, err
 	}
+	link.AddClicks(shorty.ClickCounts{Human: 1})
+	i.Store[code] = link
+	i.dirty = true
 	return link.TotalClicks, nil
 }
+
+func (i *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for code, n := range counts {
+		link, ok := i.Store[code]
+		if !ok {
+			continue
+		}
+		link.AddClicks(n)
+		link.UpdatedAt = time.Now()
+		i.Store[code] = link
+		i.dirty = true
+	}
+	return nil
+}
+
+// Most click events kept. The oldest are dropped beyond it.
+const maxClicks = 100000
+
+// Days of visitor sketches kept, matching mongodb.ClickRetention.
+const maxVisitorDays = 400
+
+// SaveClicks appends click events and adds their visitors to the day's sketch.
+func (i *Store) SaveClicks(ctx context.Context, clicks []shorty.Click) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.Clicks = append(i.Clicks, clicks...)
+	if over := len(i.Clicks) - maxClicks; over > 0 {
+		// Copy, so the dropped events' backing array can be freed
+		i.Clicks = append([]shorty.Click(nil), i.Clicks[over:]...)
+	}
+
+	for _, c := range clicks {
+		key := c.VisitorKey()
+		if len(key) == 0 {
+			continue
+		}
+		if i.Visitors == nil {
+			i.Visitors = map[string]map[time.Time]*hll.Sketch{}
+		}
+		days, ok := i.Visitors[c.Code]
+		if !ok {
+			days = map[time.Time]*hll.Sketch{}
+			i.Visitors[c.Code] = days
+		}
+		day := shorty.TruncateInterval(c.At, shorty.IntervalDay)
+		if days[day] == nil {
+			days[day] = hll.New()
+			// Expire the link's old days as new ones start
+			for d := range days {
+				if day.Sub(d) > maxVisitorDays*24*time.Hour {
+					delete(days, d)
+				}
+			}
+		}
+		days[day].Add(key)
+	}
+	return nil
+}
+
+// UniqueVisitors merges the visitor sketches of code's days in range.
+func (i *Store) UniqueVisitors(ctx context.Context, code string, from, to time.Time) (int, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	from = shorty.TruncateInterval(from, shorty.IntervalDay)
+	merged := hll.New()
+	for day, sketch := range i.Visitors[code] {
+		if day.Before(from) || (!to.IsZero() && !day.Before(to)) {
+			continue
+		}
+		merged.Merge(sketch)
+	}
+	return merged.Estimate(), nil
+}
+
+// ClickStats counts the click events matching q.
+func (i *Store) ClickStats(ctx context.Context, q shorty.StatsQuery) (shorty.ClickStats, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	series := map[time.Time]int{}
+	referrers, browsers, oses, devices := map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+	for _, c := range i.Clicks {
+		if !q.Matches(c) {
+			continue
+		}
+		series[shorty.TruncateInterval(c.At, q.Interval)]++
+		referrers[c.Referrer]++
+		browsers[c.UserAgent.Browser]++
+		oses[c.UserAgent.OS]++
+		devices[c.UserAgent.Device]++
+	}
+
+	stats := shorty.NewClickStats(q, series)
+	stats.Referrers = shorty.TopCounts(referrers, shorty.StatsTopN)
+	stats.Browsers = shorty.TopCounts(browsers, shorty.StatsTopN)
+	stats.OS = shorty.TopCounts(oses, shorty.StatsTopN)
+	stats.Devices = shorty.TopCounts(devices, shorty.StatsTopN)
+	return stats, nil
+}
+
+// Most code and referrer pairs whose misses are kept. Misses of new pairs are dropped beyond it.
+const maxMissTallies = 10000
+
+type missKey struct {
+	code, referrer string
+}
+
+// RecordMisses adds the tallies to the misses of their code and referrer.
+func (i *Store) RecordMisses(ctx context.Context, tallies []shorty.MissTally) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.misses == nil {
+		i.misses = map[missKey]*shorty.MissTally{}
+	}
+	for _, t := range tallies {
+		key := missKey{t.Code, t.Referrer}
+		if tally, ok := i.misses[key]; ok {
+			tally.Add(t)
+			continue
+		}
+		if len(i.misses) < maxMissTallies {
+			i.misses[key] = &t
+		}
+	}
+	return nil
+}
+
+// TopMisses counts the misses by code from the referrers seen since q.Since. Codes that have since been given a link are left out.
+func (i *Store) TopMisses(ctx context.Context, q shorty.MissQuery) ([]shorty.MissCount, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	counts := map[string]*shorty.MissCount{}
+	referrers := map[string]map[string]int{}
+	for _, t := range i.misses {
+		if _, ok := i.Store[t.Code]; ok || t.LastSeen.Before(q.Since) {
+			continue
+		}
+		c, ok := counts[t.Code]
+		if !ok {
+			c = &shorty.MissCount{Code: t.Code, FirstSeen: t.FirstSeen, LastSeen: t.LastSeen}
+			counts[t.Code] = c
+			referrers[t.Code] = map[string]int{}
+		}
+		c.Misses += t.Count
+		if t.FirstSeen.Before(c.FirstSeen) {
+			c.FirstSeen = t.FirstSeen
+		}
+		if t.LastSeen.After(c.LastSeen) {
+			c.LastSeen = t.LastSeen
+		}
+		referrers[t.Code][t.Referrer] += t.Count
+	}
+
+	top := []shorty.MissCount{}
+	for code, c := range counts {
+		c.Referrers = shorty.TopCounts(referrers[code], shorty.MissTopReferrers)
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(a, b int) bool {
+		if top[a].Misses != top[b].Misses {
+			return top[a].Misses > top[b].Misses
+		}
+		return top[a].Code < top[b].Code
+	})
+	if q.Limit > 0 && len(top) > q.Limit {
+		top = top[:q.Limit]
+	}
+	return top, nil
+}
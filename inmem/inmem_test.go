@@ -0,0 +1,79 @@
+package inmem
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+		return NewStore()
+	})
+}
+
+func TestClickData(t *testing.T) {
+	ctx := context.Background()
+	newStore := func() *Store {
+		store := NewStore()
+		store.SaveLink(ctx, shorty.Link{Code: "abc123"})
+		store.SaveLink(ctx, shorty.Link{Code: "xyz789"})
+		store.SaveClicks(ctx, []shorty.Click{
+			{Code: "abc123", At: time.Now(), IPHash: "a"},
+			{Code: "xyz789", At: time.Now(), IPHash: "b"},
+		})
+		return store
+	}
+
+	t.Run("moves click data with the link's code", func(t *testing.T) {
+		store := newStore()
+		if _, err := store.UpdateLink(ctx, "abc123", shorty.Link{CustomCode: "moved"}, shorty.UpdateOpts{}); err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertEqual(t, store.Clicks[0].Code, "moved")
+		visitors, _ := store.UniqueVisitors(ctx, "moved", time.Time{}, time.Time{})
+		testutil.AssertEqual(t, visitors, 1)
+		visitors, _ = store.UniqueVisitors(ctx, "abc123", time.Time{}, time.Time{})
+		testutil.AssertEqual(t, visitors, 0)
+	})
+
+	t.Run("deletes click data with the link", func(t *testing.T) {
+		store := newStore()
+		if _, err := store.DeleteLink(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertEqual(t, len(store.Clicks), 1)
+		testutil.AssertEqual(t, store.Clicks[0].Code, "xyz789")
+		visitors, _ := store.UniqueVisitors(ctx, "abc123", time.Time{}, time.Time{})
+		testutil.AssertEqual(t, visitors, 0)
+	})
+
+	t.Run("keeps the newest click events", func(t *testing.T) {
+		store := NewStore()
+		clicks := make([]shorty.Click, maxClicks+2)
+		for n := range clicks {
+			clicks[n] = shorty.Click{Code: "new"}
+		}
+		clicks[0].Code, clicks[1].Code = "old", "old"
+		store.SaveClicks(ctx, clicks)
+
+		testutil.AssertEqual(t, len(store.Clicks), maxClicks)
+		testutil.AssertEqual(t, store.Clicks[0].Code, "new")
+	})
+
+	t.Run("expires old visitor sketches", func(t *testing.T) {
+		store := NewStore()
+		day := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+		store.SaveClicks(ctx, []shorty.Click{{Code: "abc123", At: day, IPHash: "a"}})
+		store.SaveClicks(ctx, []shorty.Click{{Code: "abc123", At: day.AddDate(0, 0, maxVisitorDays+1), IPHash: "a"}})
+
+		testutil.AssertEqual(t, len(store.Visitors["abc123"]), 1)
+	})
+}
@@ -0,0 +1,215 @@
+package inmem
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// ErrCorruptSnapshot is returned when a snapshot file cannot be decoded or contains invalid links.
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+type (
+	SnapshotOpts struct {
+		// Path to the snapshot file. Files ending in ".gob" are encoded with gob, everything else as JSON.
+		Path string
+		// How often changes are flushed to the snapshot file. Defaults to 30 seconds.
+		FlushInterval time.Duration
+	}
+
+	snapshotter struct {
+		path     string
+		interval time.Duration
+		stop     chan struct{}
+		done     chan struct{}
+		once     sync.Once
+	}
+)
+
+// NewPersistentStore creates a store backed by a snapshot file.
+// Links are loaded from the file if it exists, and changes are flushed to it periodically and on Close.
+func NewPersistentStore(o SnapshotOpts) (*Store, error) {
+	if len(o.Path) == 0 {
+		return nil, errors.New("snapshot path required")
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 30 * time.Second
+	}
+
+	s := NewStore()
+	err := s.LoadSnapshot(o.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	s.snapshot = &snapshotter{
+		path:     o.Path,
+		interval: o.FlushInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+// LoadSnapshot replaces the contents of the store with the links in the snapshot file at path.
+// A JSON snapshot is an array of Links, so hand-written fixture files can be loaded as well.
+func (i *Store) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	links, err := decodeSnapshot(f, path)
+	if err != nil {
+		return err
+	}
+
+	store := make(map[string]shorty.Link, len(links))
+	for n, l := range links {
+		if len(l.Code) == 0 {
+			return fmt.Errorf("%w: %s: link %d has no code", ErrCorruptSnapshot, path, n)
+		}
+		if _, ok := store[l.Code]; ok {
+			return fmt.Errorf("%w: %s: duplicate code %q", ErrCorruptSnapshot, path, l.Code)
+		}
+		store[l.Code] = l
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.Store = store
+	i.dirty = false
+	return nil
+}
+
+// SaveSnapshot atomically writes the contents of the store to the snapshot file at path.
+// The snapshot is written to a temporary file in the same directory and renamed into place, so readers never see a partial file.
+func (i *Store) SaveSnapshot(path string) error {
+	i.lock.RLock()
+	links := make([]shorty.Link, 0, len(i.Store))
+	for _, l := range i.Store {
+		links = append(links, l)
+	}
+	i.lock.RUnlock()
+
+	// Sort so snapshots are stable and diff nicely when checked in as fixtures
+	sort.Slice(links, func(a, b int) bool { return links[a].Code < links[b].Code })
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("createTemp: %v", err)
+	}
+	// Clean up the temp file if anything below fails
+	defer os.Remove(tmp.Name())
+
+	if err := encodeSnapshot(tmp, path, links); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename: %v", err)
+	}
+	return nil
+}
+
+// Close stops the periodic flush and writes a final snapshot.
+// It is a no-op for stores not created with NewPersistentStore.
+func (i *Store) Close() error {
+	if i.snapshot == nil {
+		return nil
+	}
+	i.snapshot.once.Do(func() { close(i.snapshot.stop) })
+	<-i.snapshot.done
+	return i.flush()
+}
+
+// Flush writes a snapshot if the store changed since the last one was written.
+func (i *Store) flush() error {
+	i.lock.Lock()
+	dirty := i.dirty
+	i.dirty = false
+	i.lock.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	if err := i.SaveSnapshot(i.snapshot.path); err != nil {
+		// Try again on the next flush
+		i.lock.Lock()
+		i.dirty = true
+		i.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (i *Store) flushLoop() {
+	defer close(i.snapshot.done)
+	ticker := time.NewTicker(i.snapshot.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := i.flush(); err != nil {
+				log.Printf("inmem: snapshot flush: %v", err)
+			}
+		case <-i.snapshot.stop:
+			return
+		}
+	}
+}
+
+func isGob(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gob")
+}
+
+func decodeSnapshot(r io.Reader, path string) ([]shorty.Link, error) {
+	var links []shorty.Link
+	var err error
+	if isGob(path) {
+		err = gob.NewDecoder(r).Decode(&links)
+	} else {
+		err = json.NewDecoder(r).Decode(&links)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptSnapshot, path, err)
+	}
+	return links, nil
+}
+
+func encodeSnapshot(w io.Writer, path string, links []shorty.Link) error {
+	if isGob(path) {
+		if err := gob.NewEncoder(w).Encode(links); err != nil {
+			return fmt.Errorf("gob encode: %v", err)
+		}
+		return nil
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(links); err != nil {
+		return fmt.Errorf("json encode: %v", err)
+	}
+	return nil
+}
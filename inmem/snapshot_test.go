@@ -0,0 +1,90 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestSnapshot(t *testing.T) {
+	for _, name := range []string{"links.json", "links.gob"} {
+		t.Run("round trips links through "+name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			createdAt := time.Date(2022, 10, 21, 3, 17, 15, 0, time.UTC)
+
+			store := NewStore()
+			store.SaveLink(context.Background(), shorty.Link{Code: "abc123", OriginalUrl: "https://operationspark.org", TotalClicks: 4, CreatedAt: createdAt})
+			store.SaveLink(context.Background(), shorty.Link{Code: "xyz789", OriginalUrl: "https://ospk.org"})
+			if err := store.SaveSnapshot(path); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := NewStore()
+			if err := loaded.LoadSnapshot(path); err != nil {
+				t.Fatal(err)
+			}
+			link, err := loaded.FindLink(context.Background(), "abc123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AssertEqual(t, len(loaded.Store), 2)
+			testutil.AssertEqual(t, link.TotalClicks, 4)
+			testutil.AssertEqual(t, link.CreatedAt.Equal(createdAt), true)
+		})
+	}
+
+	t.Run("reports corrupt snapshots", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			contents string
+		}{
+			{"truncated.json", `[{"code":"abc123"`},
+			{"empty.json", ``},
+			{"missing-code.json", `[{"originalUrl":"https://ospk.org"}]`},
+			{"duplicate.json", `[{"code":"abc123"},{"code":"abc123"}]`},
+			{"garbage.gob", `not a gob`},
+		}
+
+		for _, c := range tests {
+			path := filepath.Join(t.TempDir(), c.name)
+			os.WriteFile(path, []byte(c.contents), 0o644)
+
+			err := NewStore().LoadSnapshot(path)
+			if !errors.Is(err, ErrCorruptSnapshot) {
+				t.Errorf("%s: want ErrCorruptSnapshot, got %v", c.name, err)
+			}
+		}
+	})
+
+	t.Run("flushes on close", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "links.json")
+		os.WriteFile(path, []byte(`[{"code":"seeded","originalUrl":"https://ospk.org"}]`), 0o644)
+
+		store, err := NewPersistentStore(SnapshotOpts{Path: path, FlushInterval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(store.Store), 1)
+
+		store.SaveLink(context.Background(), shorty.Link{Code: "abc123"})
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Closing again is harmless
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded := NewStore()
+		if err := reloaded.LoadSnapshot(path); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(reloaded.Store), 2)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,7 +17,9 @@ import (
 	"github.com/operationspark/shorty/mongodb"
 	"github.com/operationspark/shorty/shorty"
 	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -459,3 +462,405 @@ func NewRequestWithAPIKey(method, path string, body io.Reader) *http.Request {
 	r.Header.Add("key", "test-api-key")
 	return r
 }
+
+func TestShardedClickCounters(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{
+		Client:         dbClient,
+		DBName:         dbName,
+		LinksCollName:  urlCollName,
+		ClickShards:    4,
+		ShardsCollName: "urlClickShards",
+	}
+	if err := store.EnsureShardIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing links keep their totalClicks as the base count
+	_, err := store.SaveLink(ctx, shorty.Link{Code: "viral", TotalClicks: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("adds shard counts to totalClicks on read", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			if _, err := store.IncrementTotalClicks(ctx, "viral"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.IncrementTotalClicksBatch(ctx, map[string]shorty.ClickCounts{"viral": {Human: 4, Bot: 1}, "unknown": {Human: 3}}); err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := store.FindLink(ctx, "viral")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The bot click isn't part of the total
+		testutil.AssertEqual(t, link.TotalClicks, 19)
+		testutil.AssertEqual(t, link.BotClicks, 1)
+
+		links, err := store.FindAllLinks(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range links {
+			if l.Code == "viral" {
+				testutil.AssertEqual(t, l.TotalClicks, 19)
+			}
+		}
+
+		// No shards are created for unknown codes
+		n, _ := dbClient.Database(dbName).Collection("urlClickShards").CountDocuments(ctx, bson.D{{"code", "unknown"}})
+		testutil.AssertEqual(t, n, int64(0))
+	})
+
+	t.Run("folds shard counts back into totalClicks", func(t *testing.T) {
+		folded, err := store.FoldClickShards(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, folded, 10)
+
+		unsharded := &mongodb.Store{Client: dbClient, DBName: dbName, LinksCollName: urlCollName}
+		link, err := unsharded.FindLink(ctx, "viral")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, link.TotalClicks, 19)
+		testutil.AssertEqual(t, link.BotClicks, 1)
+	})
+
+	t.Run("finishes an interrupted fold once", func(t *testing.T) {
+		shards := dbClient.Database(dbName).Collection("urlClickShards")
+		pending := bson.D{
+			{"code", "viral"}, {"shard", 9}, {"count", 0}, {"human", 0}, {"bot", 0},
+			{"folding", bson.D{{"id", primitive.NewObjectID()}, {"count", 2}, {"human", 2}, {"bot", 0}}},
+		}
+		unsharded := &mongodb.Store{Client: dbClient, DBName: dbName, LinksCollName: urlCollName}
+
+		// Interrupted before the link was updated, then after
+		for _, want := range []int{2, 0} {
+			if _, err := shards.InsertOne(ctx, pending); err != nil {
+				t.Fatal(err)
+			}
+			folded, err := store.FoldClickShards(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AssertEqual(t, folded, want)
+
+			link, err := unsharded.FindLink(ctx, "viral")
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AssertEqual(t, link.TotalClicks, 21)
+			n, _ := shards.CountDocuments(ctx, bson.D{{"code", "viral"}})
+			testutil.AssertEqual(t, n, int64(0))
+		}
+	})
+}
+
+func TestUniqueVisitors(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{Client: dbClient, DBName: dbName, ClicksCollName: "clicks", VisitorsCollName: "visitors"}
+	day := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	var clicks []shorty.Click
+	for n := 0; n < 300; n++ {
+		// Each visitor clicks on two days
+		ip := shorty.HashIP(fmt.Sprintf("203.0.113.%d", n), "salt")
+		clicks = append(clicks,
+			shorty.Click{Code: "visited", At: day.Add(time.Hour), IPHash: ip},
+			shorty.Click{Code: "visited", At: day.Add(30 * time.Hour), IPHash: ip},
+		)
+	}
+	clicks = append(clicks, shorty.Click{Code: "visited", At: day, IPHash: "crawler", Bot: true})
+	if err := store.SaveClicks(ctx, clicks); err != nil {
+		t.Fatal(err)
+	}
+	// Saving again is a no-op for the sketches
+	if err := store.SaveClicks(ctx, clicks[:10]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Estimates are within a few percent
+	for _, to := range []time.Time{day.AddDate(0, 0, 1), {}} {
+		visitors, err := store.UniqueVisitors(ctx, "visited", day, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visitors < 290 || visitors > 310 {
+			t.Errorf("to %v: want about 300 visitors, got %d", to, visitors)
+		}
+	}
+}
+
+func TestClickStatsAggregation(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{Client: dbClient, DBName: dbName, ClicksCollName: "clicks", VisitorsCollName: "visitors"}
+	// A Monday
+	day := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+	chrome := shorty.UserAgent{Browser: "Chrome", OS: "Windows", Device: "desktop"}
+	safari := shorty.UserAgent{Browser: "Safari", OS: "iOS", Device: "mobile"}
+	err := store.SaveClicks(ctx, []shorty.Click{
+		{Code: "stats", At: day.Add(time.Hour), UserAgent: chrome, Referrer: "https://mail.google.com/", IPHash: "visitor1"},
+		{Code: "stats", At: day.Add(2 * time.Hour), UserAgent: safari, IPHash: "visitor2"},
+		{Code: "stats", At: day.Add(50 * time.Hour), UserAgent: chrome, Referrer: "https://mail.google.com/", IPHash: "visitor1"},
+		// Outside the range, and another link
+		{Code: "stats", At: day.Add(-time.Hour), UserAgent: chrome},
+		{Code: "stats-other", At: day.Add(time.Hour), UserAgent: chrome},
+		// A link preview
+		{Code: "stats", At: day.Add(3 * time.Hour), UserAgent: shorty.UserAgent{Browser: "Other"}, IPHash: "crawler", Bot: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("counts clicks per interval with zero-filled buckets", func(t *testing.T) {
+		stats, err := store.ClickStats(ctx, shorty.StatsQuery{Code: "stats", From: day, To: day.AddDate(0, 0, 3), Interval: shorty.IntervalDay})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, stats.Total, 3)
+		testutil.AssertEqual(t, len(stats.Series), 3)
+		testutil.AssertEqual(t, stats.Series[0], shorty.StatsBucket{Start: day, Clicks: 2})
+		testutil.AssertEqual(t, stats.Series[1], shorty.StatsBucket{Start: day.AddDate(0, 0, 1), Clicks: 0})
+		testutil.AssertEqual(t, stats.Series[2], shorty.StatsBucket{Start: day.AddDate(0, 0, 2), Clicks: 1})
+		testutil.AssertEqual(t, len(stats.Referrers), 2)
+		testutil.AssertEqual(t, stats.Referrers[0], shorty.StatsCount{Value: "https://mail.google.com/", Clicks: 2})
+		// Missing referrers count as direct traffic
+		testutil.AssertEqual(t, stats.Referrers[1], shorty.StatsCount{Value: "", Clicks: 1})
+		testutil.AssertEqual(t, len(stats.Browsers), 2)
+		testutil.AssertEqual(t, stats.Browsers[0], shorty.StatsCount{Value: "Chrome", Clicks: 2})
+		testutil.AssertEqual(t, stats.Devices[1], shorty.StatsCount{Value: "mobile", Clicks: 1})
+	})
+
+	t.Run("counts bots when asked", func(t *testing.T) {
+		stats, err := store.ClickStats(ctx, shorty.StatsQuery{Code: "stats", From: day, To: day.AddDate(0, 0, 1), Interval: shorty.IntervalHour, IncludeBots: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, stats.Total, 3)
+		testutil.AssertEqual(t, len(stats.Series), 24)
+		testutil.AssertEqual(t, stats.Series[3], shorty.StatsBucket{Start: day.Add(3 * time.Hour), Clicks: 1})
+		testutil.AssertEqual(t, len(stats.Browsers), 3)
+	})
+
+	t.Run("starts weeks on Monday", func(t *testing.T) {
+		stats, err := store.ClickStats(ctx, shorty.StatsQuery{Code: "stats", From: day.AddDate(0, 0, -7), To: day.AddDate(0, 0, 7), Interval: shorty.IntervalWeek})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(stats.Series), 2)
+		testutil.AssertEqual(t, stats.Series[0], shorty.StatsBucket{Start: day.AddDate(0, 0, -7), Clicks: 1})
+		testutil.AssertEqual(t, stats.Series[1], shorty.StatsBucket{Start: day, Clicks: 3})
+	})
+}
+
+func TestClickDataLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{Client: dbClient, DBName: dbName, LinksCollName: urlCollName, ClicksCollName: "clicks", VisitorsCollName: "visitors"}
+	clicks := dbClient.Database(dbName).Collection("clicks")
+	store.SaveLink(ctx, shorty.Link{Code: "lifecycle", OriginalUrl: "https://operationspark.org"})
+	if err := store.SaveClicks(ctx, []shorty.Click{{Code: "lifecycle", At: time.Now(), IPHash: "visitor"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("moves click data with the link's code", func(t *testing.T) {
+		if _, err := store.UpdateLink(ctx, "lifecycle", shorty.Link{CustomCode: "lifecycle-moved"}, shorty.UpdateOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		n, _ := clicks.CountDocuments(ctx, bson.D{{"code", "lifecycle-moved"}})
+		testutil.AssertEqual(t, n, int64(1))
+		visitors, _ := store.UniqueVisitors(ctx, "lifecycle-moved", time.Time{}, time.Time{})
+		testutil.AssertEqual(t, visitors, 1)
+	})
+
+	t.Run("deletes click data with the link", func(t *testing.T) {
+		if _, err := store.DeleteLink(ctx, "lifecycle-moved"); err != nil {
+			t.Fatal(err)
+		}
+		n, _ := clicks.CountDocuments(ctx, bson.D{{"code", "lifecycle-moved"}})
+		testutil.AssertEqual(t, n, int64(0))
+		visitors, _ := store.UniqueVisitors(ctx, "lifecycle-moved", time.Time{}, time.Time{})
+		testutil.AssertEqual(t, visitors, 0)
+	})
+}
+
+func TestMisses(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{Client: dbClient, DBName: dbName, LinksCollName: urlCollName, MissesCollName: "misses"}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	misses := []shorty.Miss{
+		{Code: "fal-cohort", At: now.Add(-time.Hour)},
+		{Code: "fal-cohort", Referrer: "https://operationspark.org/events", At: now.Add(-2 * time.Hour)},
+		{Code: "fal-cohort", Referrer: "https://operationspark.org/events", At: now},
+		{Code: "typo", At: now},
+		{Code: "exists", At: now},
+		{Code: "stale", At: now.AddDate(0, -2, 0)},
+	}
+	for _, m := range misses {
+		if err := store.RecordMisses(ctx, []shorty.MissTally{shorty.NewMissTally(m)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.SaveLink(ctx, shorty.Link{Code: "exists", OriginalUrl: "https://operationspark.org"}); err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := store.TopMisses(ctx, shorty.MissQuery{Since: now.AddDate(0, 0, -30), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertEqual(t, len(top), 2)
+	testutil.AssertEqual(t, top[0].Code, "fal-cohort")
+	testutil.AssertEqual(t, top[0].Misses, 3)
+	testutil.AssertEqual(t, top[0].FirstSeen.Equal(now.Add(-2*time.Hour)), true)
+	testutil.AssertEqual(t, top[0].LastSeen.Equal(now), true)
+	testutil.AssertEqual(t, top[0].Referrers[0], shorty.StatsCount{Value: "https://operationspark.org/events", Clicks: 2})
+	testutil.AssertEqual(t, top[1].Code, "typo")
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{Client: dbClient, DBName: dbName + "-migrations", LinksCollName: urlCollName}
+	if err := dbClient.Database(store.DBName).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A link saved before the defaults existed
+	links := dbClient.Database(store.DBName).Collection(urlCollName)
+	links.InsertOne(ctx, bson.D{{"code", "old"}, {"originalUrl", "https://operationspark.org"}})
+
+	t.Run("refuses an unmigrated database", func(t *testing.T) {
+		err := store.CheckSchema(ctx)
+		if !errors.Is(err, mongodb.ErrSchemaMismatch) {
+			t.Fatalf("want ErrSchemaMismatch, got %v", err)
+		}
+	})
+
+	t.Run("dry run lists pending migrations without applying them", func(t *testing.T) {
+		pending, err := store.Migrate(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(pending), len(mongodb.Migrations))
+
+		statuses, _ := store.MigrationStatus(ctx)
+		testutil.AssertEqual(t, statuses[0].AppliedAt.IsZero(), true)
+	})
+
+	t.Run("applies pending migrations once", func(t *testing.T) {
+		ran, err := store.Migrate(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(ran), len(mongodb.Migrations))
+
+		ran, err = store.Migrate(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(ran), 0)
+		testutil.AssertEqual(t, store.CheckSchema(ctx), nil)
+
+		link, err := store.FindLink(ctx, "old")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, link.CustomCode, "old")
+	})
+
+	t.Run("tolerates migrations from a newer version", func(t *testing.T) {
+		migrations := dbClient.Database(store.DBName).Collection("migrations")
+		migrations.InsertOne(ctx, bson.D{{"version", 9999}, {"name", "from the future"}, {"appliedAt", time.Now()}})
+		defer migrations.DeleteOne(ctx, bson.D{{"version", 9999}})
+
+		testutil.AssertEqual(t, store.CheckSchema(ctx), nil)
+	})
+
+	t.Run("renames fields", func(t *testing.T) {
+		coll := func(s *mongodb.Store) *mongo.Collection { return s.Client.Database(s.DBName).Collection("legacy") }
+		coll(store).InsertOne(ctx, bson.D{{"url", "https://ospk.org"}})
+
+		if err := mongodb.RenameField(coll, "url", "originalUrl")(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+		n, _ := coll(store).CountDocuments(ctx, bson.D{{"originalUrl", "https://ospk.org"}})
+		testutil.AssertEqual(t, n, int64(1))
+	})
+
+	t.Run("refuses a database migrated by a newer version", func(t *testing.T) {
+		dbClient.Database(store.DBName).Collection("migrations").InsertOne(ctx, bson.D{{"version", 999}, {"name", "from the future"}})
+
+		err := store.CheckSchema(ctx)
+		if !errors.Is(err, mongodb.ErrSchemaMismatch) {
+			t.Fatalf("want ErrSchemaMismatch, got %v", err)
+		}
+	})
+}
+
+func TestSchemaValidation(t *testing.T) {
+	ctx := context.Background()
+	store := &mongodb.Store{Client: dbClient, DBName: dbName, LinksCollName: "urls_validated"}
+	if err := store.InstallValidator(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Installing again updates the existing validator
+	if err := store.InstallValidator(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rejects malformed documents written by other tools", func(t *testing.T) {
+		coll := dbClient.Database(dbName).Collection("urls_validated")
+		_, err := coll.InsertOne(ctx, bson.D{{"code", "abc"}, {"totalClicks", "lots"}})
+		if err == nil {
+			t.Fatal("want validation error")
+		}
+	})
+
+	t.Run("returns a ValidationError from the store", func(t *testing.T) {
+		_, err := store.SaveLink(ctx, shorty.Link{Code: "", OriginalUrl: "https://ospk.org"})
+		var vErr *shorty.ValidationError
+		if !errors.As(err, &vErr) {
+			t.Fatalf("want *shorty.ValidationError, got %v", err)
+		}
+	})
+
+	t.Run("accepts valid links", func(t *testing.T) {
+		_, err := store.SaveLink(ctx, shorty.Link{Code: "valid", OriginalUrl: "https://ospk.org", CreatedAt: time.Now(), UpdatedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, shards := range []int{0, 4} {
+		t.Run(fmt.Sprintf("%d click shards", shards), func(t *testing.T) {
+			n := 0
+			storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+				ctx := context.Background()
+				n++
+				store := &mongodb.Store{
+					Client:         dbClient,
+					DBName:         fmt.Sprintf("%s-conformance-%d", dbName, n),
+					LinksCollName:  urlCollName,
+					ClickShards:    shards,
+					ShardsCollName: "urlClickShards",
+				}
+				if err := dbClient.Database(store.DBName).Drop(ctx); err != nil {
+					t.Fatal(err)
+				}
+				// The unique index on code is what rejects codes in use
+				if _, err := store.Migrate(ctx, false); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { dbClient.Database(store.DBName).Drop(context.Background()) })
+				return store
+			})
+		})
+	}
+}
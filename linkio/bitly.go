@@ -0,0 +1,111 @@
+package linkio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Bitly writes "2020-03-04T18:15:25+0000" in the API and "2020-03-04 18:15:25" in CSV exports
+var bitlyTimeLayouts = []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05Z07:00", "2006-01-02 15:04:05", "2006-01-02"}
+
+// Column names used by Bitly's CSV exports, which have changed over the years
+var (
+	bitlyLinkColumns    = []string{"link", "bitlink", "short link", "id"}
+	bitlyLongURLColumns = []string{"long_url", "long url", "destination", "destination url"}
+	bitlyCreatedColumns = []string{"created_at", "created", "date created", "creation date"}
+	bitlyClicksColumns  = []string{"clicks", "total clicks", "total engagements", "engagements"}
+	bitlyUserColumns    = []string{"created_by", "created by"}
+)
+
+type (
+	bitlyCSVReader struct {
+		rows *headerRows
+	}
+
+	// BitlyJSONReader reads the "links" array of a bitlinks API response, one link at a time.
+	bitlyJSONReader struct {
+		dec     *json.Decoder
+		started bool
+		row     int
+	}
+
+	bitlyLink struct {
+		ID        string  `json:"id"`
+		Link      string  `json:"link"`
+		LongURL   string  `json:"long_url"`
+		CreatedAt string  `json:"created_at"`
+		CreatedBy string  `json:"created_by"`
+		Clicks    flexInt `json:"clicks"`
+	}
+)
+
+func newBitlyReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case CSV:
+		return &bitlyCSVReader{rows: newHeaderRows(r)}, nil
+	case JSON, JSONL:
+		return &bitlyJSONReader{dec: json.NewDecoder(r)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func (b *bitlyCSVReader) Read() (shorty.Link, error) {
+	if err := b.rows.next(bitlyLinkColumns, bitlyLongURLColumns); err != nil {
+		return shorty.Link{}, err
+	}
+
+	clicks, err := parseCount(b.rows.field(bitlyClicksColumns))
+	if err != nil {
+		return shorty.Link{}, &RowError{Row: b.rows.row, Err: fmt.Errorf("clicks: %v", err)}
+	}
+	created, err := parseTimeLayouts(b.rows.field(bitlyCreatedColumns), bitlyTimeLayouts...)
+	if err != nil {
+		return shorty.Link{}, &RowError{Row: b.rows.row, Err: fmt.Errorf("created: %v", err)}
+	}
+	return importedLink(
+		codeFromURL(b.rows.field(bitlyLinkColumns)),
+		b.rows.field(bitlyLongURLColumns),
+		b.rows.field(bitlyUserColumns),
+		clicks,
+		created,
+	), nil
+}
+
+func (b *bitlyJSONReader) Read() (shorty.Link, error) {
+	if !b.started {
+		if err := seekKey(b.dec, "links"); err != nil {
+			return shorty.Link{}, err
+		}
+		if err := expectDelim(b.dec, '['); err != nil {
+			return shorty.Link{}, err
+		}
+		b.started = true
+	}
+	if !b.dec.More() {
+		return shorty.Link{}, io.EOF
+	}
+
+	b.row++
+	var raw json.RawMessage
+	if err := b.dec.Decode(&raw); err != nil {
+		return shorty.Link{}, fmt.Errorf("row %d: decode: %v", b.row, err)
+	}
+	var bl bitlyLink
+	if err := json.Unmarshal(raw, &bl); err != nil {
+		return shorty.Link{}, &RowError{Row: b.row, Err: err}
+	}
+	created, err := parseTimeLayouts(bl.CreatedAt, bitlyTimeLayouts...)
+	if err != nil {
+		return shorty.Link{}, &RowError{Row: b.row, Err: fmt.Errorf("created_at: %v", err)}
+	}
+
+	short := bl.Link
+	if len(short) == 0 {
+		short = bl.ID
+	}
+	return importedLink(codeFromURL(short), bl.LongURL, bl.CreatedBy, int(bl.Clicks), created), nil
+}
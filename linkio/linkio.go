@@ -0,0 +1,298 @@
+// Package linkio reads and writes links in the bulk import and export formats.
+//
+// Every format is streamed one link at a time, so a whole collection never has to fit in memory.
+package linkio
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Formats
+const (
+	// One JSON encoded shorty.Link per line.
+	JSONL = "jsonl"
+	// A header row naming the columns, then one link per row.
+	CSV = "csv"
+	// A single JSON document, as returned by other shorteners' APIs. Only readable from those sources.
+	JSON = "json"
+)
+
+// ErrUnknownFormat is returned for formats this package can't read or write.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// CSVColumns are the CSV header columns, named after the JSON fields of shorty.Link.
+var CSVColumns = []string{"code", "customCode", "shortUrl", "originalUrl", "private", "createdBy", "totalClicks", "humanClicks", "botClicks", "createdAt", "updatedAt"}
+
+type (
+	// Writer writes links in one of the export formats.
+	Writer interface {
+		Write(shorty.Link) error
+		// Flush writes any buffered data to the underlying io.Writer.
+		Flush() error
+	}
+
+	// Reader reads links in one of the import formats. Read returns io.EOF when there are no more links.
+	Reader interface {
+		Read() (shorty.Link, error)
+	}
+
+	// RowError is returned by Read for a row that couldn't be parsed. Reading can continue with the next row.
+	RowError struct {
+		Row int
+		Err error
+	}
+
+	jsonlWriter struct {
+		w   *bufio.Writer
+		enc *json.Encoder
+	}
+
+	jsonlReader struct {
+		dec *json.Decoder
+		row int
+	}
+
+	csvWriter struct {
+		w           *csv.Writer
+		wroteHeader bool
+	}
+
+	csvReader struct {
+		r *csv.Reader
+		// Column index of each header name
+		columns map[string]int
+		row     int
+	}
+)
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ContentType returns the MIME type for a format.
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case Netlify, Nginx:
+		return "text/plain; charset=utf-8"
+	case HTML:
+		return "application/zip"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// Filename returns the conventional file name for an export in format.
+func Filename(format string) string {
+	switch format {
+	case Netlify:
+		return "_redirects"
+	case Nginx:
+		return "shorty-redirects.map"
+	case HTML:
+		return "links.zip"
+	default:
+		return "links." + format
+	}
+}
+
+// NewWriter returns a Writer for format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case JSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case Netlify:
+		return &netlifyWriter{w: bufio.NewWriter(w)}, nil
+	case Nginx:
+		return &nginxWriter{w: bufio.NewWriter(w)}, nil
+	case HTML:
+		return &htmlZipWriter{z: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// NewReader returns a Reader for format.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case JSONL:
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case CSV:
+		cr := csv.NewReader(r)
+		// Columns are matched by header name, so rows may omit trailing empty columns
+		cr.FieldsPerRecord = -1
+		return &csvReader{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func (j *jsonlWriter) Write(l shorty.Link) error {
+	if err := j.enc.Encode(l); err != nil {
+		return fmt.Errorf("encode: %v", err)
+	}
+	return nil
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
+
+// Read decodes the next link. A line that isn't valid JSON ends the import,
+// since the decoder can't find the start of the next link.
+func (j *jsonlReader) Read() (shorty.Link, error) {
+	var l shorty.Link
+	j.row++
+	err := j.dec.Decode(&l)
+	if err == io.EOF {
+		return l, io.EOF
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return l, &RowError{Row: j.row, Err: err}
+	}
+	if err != nil {
+		return l, fmt.Errorf("row %d: decode: %v", j.row, err)
+	}
+	return l, nil
+}
+
+func (c *csvWriter) Write(l shorty.Link) error {
+	if !c.wroteHeader {
+		if err := c.w.Write(CSVColumns); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+	return c.w.Write([]string{
+		l.Code,
+		l.CustomCode,
+		l.ShortURL,
+		l.OriginalUrl,
+		strconv.FormatBool(l.Private),
+		l.CreatedBy,
+		strconv.Itoa(l.TotalClicks),
+		strconv.Itoa(l.HumanClicks),
+		strconv.Itoa(l.BotClicks),
+		formatTime(l.CreatedAt),
+		formatTime(l.UpdatedAt),
+	})
+}
+
+// Flush writes the header even when there are no links, so an empty export is still a valid import.
+func (c *csvWriter) Flush() error {
+	if !c.wroteHeader {
+		if err := c.w.Write(CSVColumns); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvReader) Read() (shorty.Link, error) {
+	var l shorty.Link
+	if c.columns == nil {
+		header, err := c.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return l, io.EOF
+			}
+			return l, fmt.Errorf("header: %v", err)
+		}
+		c.columns = map[string]int{}
+		for i, name := range header {
+			c.columns[strings.TrimSpace(name)] = i
+		}
+		if _, ok := c.columns["originalUrl"]; !ok {
+			return l, errors.New(`header: "originalUrl" column required`)
+		}
+	}
+
+	c.row++
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return l, io.EOF
+	}
+	// Only malformed rows can be skipped. Errors reading the body end the import.
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return l, &RowError{Row: c.row, Err: err}
+	}
+	if err != nil {
+		return l, fmt.Errorf("row %d: read: %v", c.row, err)
+	}
+
+	field := func(name string) string {
+		i, ok := c.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	l = shorty.Link{
+		Code:        field("code"),
+		CustomCode:  field("customCode"),
+		ShortURL:    field("shortUrl"),
+		OriginalUrl: field("originalUrl"),
+		CreatedBy:   field("createdBy"),
+	}
+	if v := field("private"); len(v) > 0 {
+		if l.Private, err = strconv.ParseBool(v); err != nil {
+			return l, &RowError{Row: c.row, Err: fmt.Errorf("private: %v", err)}
+		}
+	}
+	counts := []struct {
+		name string
+		n    *int
+	}{{"totalClicks", &l.TotalClicks}, {"humanClicks", &l.HumanClicks}, {"botClicks", &l.BotClicks}}
+	for _, count := range counts {
+		if v := field(count.name); len(v) > 0 {
+			if *count.n, err = strconv.Atoi(v); err != nil {
+				return l, &RowError{Row: c.row, Err: fmt.Errorf("%s: %v", count.name, err)}
+			}
+		}
+	}
+	if l.CreatedAt, err = parseTime(field("createdAt")); err != nil {
+		return l, &RowError{Row: c.row, Err: fmt.Errorf("createdAt: %v", err)}
+	}
+	if l.UpdatedAt, err = parseTime(field("updatedAt")); err != nil {
+		return l, &RowError{Row: c.row, Err: fmt.Errorf("updatedAt: %v", err)}
+	}
+	return l, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(v string) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
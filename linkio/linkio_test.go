@@ -0,0 +1,114 @@
+package linkio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2022, 10, 21, 3, 17, 15, 400000000, time.UTC)
+	links := []shorty.Link{
+		{Code: "abc123", CustomCode: "abc123", ShortURL: "https://ospk.org/abc123", OriginalUrl: "https://operationspark.org", CreatedBy: "Halle Bot", TotalClicks: 7, HumanClicks: 5, BotClicks: 3, CreatedAt: created, UpdatedAt: created},
+		{Code: "def456", OriginalUrl: "https://operationspark.org/?a=1,b=2", CreatedBy: `quoted "name"`},
+	}
+
+	for _, format := range []string{JSONL, CSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range links {
+				if err := w.Write(l); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range links {
+				got, err := r.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				testutil.AssertEqual(t, got.Code, want.Code)
+				testutil.AssertEqual(t, got.OriginalUrl, want.OriginalUrl)
+				testutil.AssertEqual(t, got.CreatedBy, want.CreatedBy)
+				testutil.AssertEqual(t, got.TotalClicks, want.TotalClicks)
+				testutil.AssertEqual(t, got.HumanClicks, want.HumanClicks)
+				testutil.AssertEqual(t, got.BotClicks, want.BotClicks)
+				testutil.AssertEqual(t, got.CreatedAt.Equal(want.CreatedAt), true)
+			}
+			_, err = r.Read()
+			testutil.AssertEqual(t, err, io.EOF)
+		})
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	t.Run("matches columns by header name", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("originalUrl,code\nhttps://ospk.org,abc\n"), CSV)
+		l, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, l.Code, "abc")
+		testutil.AssertEqual(t, l.OriginalUrl, "https://ospk.org")
+	})
+
+	t.Run("reports bad rows and continues", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("code,originalUrl,totalClicks\nabc,https://ospk.org,lots\ndef,https://ospk.org,1\n"), CSV)
+
+		_, err := r.Read()
+		var rowErr *RowError
+		if !errors.As(err, &rowErr) {
+			t.Fatalf("want *RowError, got %v", err)
+		}
+		testutil.AssertEqual(t, rowErr.Row, 1)
+
+		l, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, l.Code, "def")
+	})
+
+	t.Run("stops when the body can't be read", func(t *testing.T) {
+		body := io.MultiReader(strings.NewReader("code,originalUrl\nabc,https://ospk.org"), iotest.ErrReader(errors.New("connection reset")))
+		r, _ := NewReader(body, CSV)
+
+		_, err := r.Read()
+		var rowErr *RowError
+		if err == nil || errors.As(err, &rowErr) {
+			t.Fatalf("want an error that ends the import, got %v", err)
+		}
+	})
+
+	t.Run("requires an originalUrl column", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("code\nabc\n"), CSV)
+		if _, err := r.Read(); err == nil {
+			t.Fatal("want error")
+		}
+	})
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewWriter(io.Discard, "xml")
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("want ErrUnknownFormat, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package linkio
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Static redirect map formats, for serving redirects from a CDN or backup web server when the service is down.
+// They can only be written.
+const (
+	// A Netlify _redirects file.
+	Netlify = "netlify"
+	// Entries for an nginx map block, keyed by request path.
+	Nginx = "nginx"
+	// A zip of <code>/index.html pages that redirect with a meta refresh.
+	HTML = "html"
+)
+
+// Status used by the static redirects. Temporary, like the service's own redirects, so browsers don't cache them.
+const staticRedirectStatus = 307
+
+var redirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8" />
+    <meta http-equiv="refresh" content="0; url={{.}}" />
+    <meta name="robots" content="noindex" />
+    <link rel="canonical" href="{{.}}" />
+    <title>Redirecting…</title>
+  </head>
+  <body>
+    <p>Redirecting to <a href="{{.}}">{{.}}</a></p>
+  </body>
+</html>
+`))
+
+type (
+	netlifyWriter struct {
+		w *bufio.Writer
+	}
+
+	nginxWriter struct {
+		w           *bufio.Writer
+		wroteHeader bool
+	}
+
+	htmlZipWriter struct {
+		z *zip.Writer
+	}
+)
+
+func (n *netlifyWriter) Write(l shorty.Link) error {
+	if len(l.OriginalUrl) == 0 {
+		return nil
+	}
+	// Fields are separated by whitespace, so none may contain any
+	_, err := fmt.Fprintf(n.w, "/%s  %s  %d\n", url.PathEscape(l.Code), escapeSpaces(l.OriginalUrl), staticRedirectStatus)
+	return err
+}
+
+func (n *netlifyWriter) Flush() error {
+	return n.w.Flush()
+}
+
+func (n *nginxWriter) Write(l shorty.Link) error {
+	if err := n.writeHeader(); err != nil {
+		return err
+	}
+	if len(l.OriginalUrl) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(n.w, "%s %s;\n", nginxQuote("/"+l.Code), nginxQuote(nginxURL(l.OriginalUrl)))
+	return err
+}
+
+func (n *nginxWriter) Flush() error {
+	if err := n.writeHeader(); err != nil {
+		return err
+	}
+	return n.w.Flush()
+}
+
+func (n *nginxWriter) writeHeader() error {
+	if n.wroteHeader {
+		return nil
+	}
+	n.wroteHeader = true
+	_, err := fmt.Fprintf(n.w, `# Short link redirects. Include them in a map block:
+#
+#   map $uri $shorty_redirect {
+#       include /etc/nginx/shorty-redirects.map;
+#   }
+#
+# and redirect in a server block:
+#
+#   if ($shorty_redirect) {
+#       return %d $shorty_redirect;
+#   }
+`, staticRedirectStatus)
+	return err
+}
+
+func (h *htmlZipWriter) Write(l shorty.Link) error {
+	// The code is used as a directory name, so it must not escape the archive
+	if len(l.OriginalUrl) == 0 || l.Code == "." || l.Code == ".." {
+		return nil
+	}
+	f, err := h.z.Create(url.PathEscape(l.Code) + "/index.html")
+	if err != nil {
+		return fmt.Errorf("create: %v", err)
+	}
+	if err := redirectPage.Execute(f, l.OriginalUrl); err != nil {
+		return fmt.Errorf("execute: %v", err)
+	}
+	return nil
+}
+
+// Flush finishes the archive. Nothing can be written after it.
+func (h *htmlZipWriter) Flush() error {
+	return h.z.Close()
+}
+
+func escapeSpaces(s string) string {
+	return strings.NewReplacer(" ", "%20", "\t", "%09").Replace(s)
+}
+
+// NginxURL encodes characters nginx would treat as syntax or variables in a map value.
+func nginxURL(s string) string {
+	return strings.NewReplacer("$", "%24", " ", "%20").Replace(s)
+}
+
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
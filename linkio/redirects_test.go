@@ -0,0 +1,72 @@
+package linkio
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+var redirectLinks = []shorty.Link{
+	{Code: "abc123", OriginalUrl: "https://operationspark.org/?q=a b"},
+	{Code: "def456", OriginalUrl: `https://ospk.org/$price"`},
+}
+
+func writeAll(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range redirectLinks {
+		if err := w.Write(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRedirectMaps(t *testing.T) {
+	t.Run("writes Netlify redirects without whitespace in fields", func(t *testing.T) {
+		out := string(writeAll(t, Netlify))
+
+		testutil.AssertEqual(t, out, "/abc123  https://operationspark.org/?q=a%20b  307\n/def456  https://ospk.org/$price\"  307\n")
+	})
+
+	t.Run("writes quoted nginx map entries", func(t *testing.T) {
+		out := string(writeAll(t, Nginx))
+
+		testutil.AssertContains(t, out, `"/abc123" "https://operationspark.org/?q=a%20b";`)
+		// nginx would expand $price as a variable
+		testutil.AssertContains(t, out, `"/def456" "https://ospk.org/%24price\"";`)
+	})
+
+	t.Run("writes a zip of meta refresh pages", func(t *testing.T) {
+		out := writeAll(t, HTML)
+
+		z, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, len(z.File), 2)
+		testutil.AssertEqual(t, z.File[0].Name, "abc123/index.html")
+
+		f, err := z.File[1].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := io.ReadAll(f)
+		testutil.AssertContains(t, string(page), `<meta http-equiv="refresh" content="0; url=https://ospk.org/$price&#34;" />`)
+		if strings.Contains(string(page), `$price"`) {
+			t.Error("URL should be escaped")
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package linkio
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Sources of imported links
+const (
+	// This service's own export formats.
+	Shorty = "shorty"
+	// Bitly's CSV link export, or the "links" response of its v4 bitlinks API.
+	Bitly = "bitly"
+	// The YOURLS url table as CSV, or its "stats" API response.
+	YOURLS = "yourls"
+)
+
+// ErrUnknownSource is returned for sources this package can't read.
+var ErrUnknownSource = errors.New("unknown source")
+
+type (
+	// HeaderRows reads CSV rows and looks up fields by case-insensitive header name.
+	headerRows struct {
+		r       *csv.Reader
+		columns map[string]int
+		record  []string
+		row     int
+	}
+
+	// FlexInt decodes JSON numbers and numeric strings, since YOURLS sends counts as strings.
+	flexInt int
+)
+
+// NewSourceReader returns a Reader for links exported from source in format.
+// Links from other shorteners keep their code as the customCode, along with their click totals and creation dates.
+func NewSourceReader(r io.Reader, source, format string) (Reader, error) {
+	switch source {
+	case "", Shorty:
+		return NewReader(r, format)
+	case Bitly:
+		return newBitlyReader(r, format)
+	case YOURLS:
+		return newYOURLSReader(r, format)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSource, source)
+	}
+}
+
+func newHeaderRows(r io.Reader) *headerRows {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	return &headerRows{r: cr}
+}
+
+// Next reads the next row, reading the header first if needed. required columns must be in the header.
+func (h *headerRows) next(required ...[]string) error {
+	if h.columns == nil {
+		header, err := h.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return fmt.Errorf("header: %v", err)
+		}
+		h.columns = map[string]int{}
+		for i, name := range header {
+			h.columns[normalizeHeader(name)] = i
+		}
+		for _, names := range required {
+			if _, ok := h.index(names); !ok {
+				return fmt.Errorf("header: %q column required", names[0])
+			}
+		}
+	}
+
+	h.row++
+	record, err := h.r.Read()
+	if err == io.EOF {
+		return io.EOF
+	}
+	// Only malformed rows can be skipped. Errors reading the body end the import.
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &RowError{Row: h.row, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("row %d: read: %v", h.row, err)
+	}
+	h.record = record
+	return nil
+}
+
+// Field returns the value of the first column in names that's in the header.
+func (h *headerRows) field(names []string) string {
+	i, ok := h.index(names)
+	if !ok || i >= len(h.record) {
+		return ""
+	}
+	return strings.TrimSpace(h.record[i])
+}
+
+func (h *headerRows) index(names []string) (int, bool) {
+	for _, name := range names {
+		if i, ok := h.columns[normalizeHeader(name)]; ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// NormalizeHeader makes "Long URL", "long_url" and "longUrl" the same column.
+func normalizeHeader(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer(" ", "", "_", "", "-", "", "\ufeff", "").Replace(name)
+}
+
+// SeekKey advances dec to the value of key in the top level JSON object.
+func seekKey(dec *json.Decoder, key string) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("decode: %v", err)
+		}
+		if t == key {
+			return nil
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return fmt.Errorf("decode: %v", err)
+		}
+	}
+	return fmt.Errorf("decode: %q key not found", key)
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+	if t != want {
+		return fmt.Errorf("decode: want %q, got %v", want, t)
+	}
+	return nil
+}
+
+// CodeFromURL returns the last path segment of a short URL, ex: "abc" for "https://bit.ly/abc".
+func codeFromURL(u string) string {
+	u = strings.TrimRight(strings.TrimSpace(u), "/")
+	return u[strings.LastIndex(u, "/")+1:]
+}
+
+// ParseTimeLayouts parses v with the first layout that matches. Times without a zone are UTC.
+func parseTimeLayouts(v string, layouts ...string) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", v)
+}
+
+func parseCount(v string) (int, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.ReplaceAll(v, ",", ""))
+}
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if len(s) == 0 || s == "null" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("want a number, got %s", b)
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+// ImportedLink builds a link from another shortener, keeping its code as the customCode.
+func importedLink(code, originalURL, createdBy string, clicks int, created time.Time) shorty.Link {
+	return shorty.Link{
+		Code:        code,
+		CustomCode:  code,
+		OriginalUrl: originalURL,
+		CreatedBy:   createdBy,
+		TotalClicks: clicks,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+	}
+}
@@ -0,0 +1,91 @@
+package linkio
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestSourceReaders(t *testing.T) {
+	created := time.Date(2020, 3, 4, 18, 15, 25, 0, time.UTC)
+	tests := []struct {
+		name   string
+		source string
+		format string
+		input  string
+	}{
+		{
+			"bitly CSV",
+			Bitly, CSV,
+			"\ufeffBitlink,Long URL,Title,Created,Total Clicks\n" +
+				"https://bit.ly/3abcDEF,https://operationspark.org,Home,2020-03-04 18:15:25,\"1,204\"\n",
+		},
+		{
+			"bitly API JSON",
+			Bitly, JSON,
+			`{"pagination":{"total":1},"links":[{"id":"bit.ly/3abcDEF","link":"https://bit.ly/3abcDEF","long_url":"https://operationspark.org","created_at":"2020-03-04T18:15:25+0000","clicks":1204}]}`,
+		},
+		{
+			"YOURLS CSV",
+			YOURLS, CSV,
+			"keyword,url,title,timestamp,ip,clicks\n3abcDEF,https://operationspark.org,Home,2020-03-04 18:15:25,127.0.0.1,1204\n",
+		},
+		{
+			"YOURLS stats JSON",
+			YOURLS, JSON,
+			`{"links":{"link_1":{"shorturl":"https://sho.rt/3abcDEF","url":"https://operationspark.org","timestamp":"2020-03-04 18:15:25","clicks":"1204"}},"stats":{"total_links":"1"}}`,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := NewSourceReader(strings.NewReader(c.input), c.source, c.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			l, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AssertEqual(t, l.Code, "3abcDEF")
+			testutil.AssertEqual(t, l.CustomCode, "3abcDEF")
+			testutil.AssertEqual(t, l.OriginalUrl, "https://operationspark.org")
+			testutil.AssertEqual(t, l.TotalClicks, 1204)
+			testutil.AssertEqual(t, l.CreatedAt.Equal(created), true)
+
+			_, err = r.Read()
+			testutil.AssertEqual(t, err, io.EOF)
+		})
+	}
+}
+
+func TestSourceReaderRowErrors(t *testing.T) {
+	input := `{"links":{"link_1":{"keyword":"bad","url":"https://ospk.org","clicks":"many"},"link_2":{"keyword":"good","url":"https://ospk.org"}}}`
+	r, _ := NewSourceReader(strings.NewReader(input), YOURLS, JSON)
+
+	_, err := r.Read()
+	var rowErr *RowError
+	if !errors.As(err, &rowErr) {
+		t.Fatalf("want *RowError, got %v", err)
+	}
+
+	var l shorty.Link
+	l, err = r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertEqual(t, l.Code, "good")
+}
+
+func TestUnknownSource(t *testing.T) {
+	_, err := NewSourceReader(strings.NewReader(""), "tinyurl", CSV)
+	if !errors.Is(err, ErrUnknownSource) {
+		t.Fatalf("want ErrUnknownSource, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package linkio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/operationspark/shorty/shorty"
+)
+
+// YOURLS stores timestamps as "2006-01-02 15:04:05" in the server's time zone, which is assumed to be UTC
+var yourlsTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05Z07:00"}
+
+// Columns of the yourls_url table
+var (
+	yourlsKeywordColumns   = []string{"keyword", "shorturl"}
+	yourlsURLColumns       = []string{"url", "long url"}
+	yourlsTimestampColumns = []string{"timestamp", "date"}
+	yourlsClicksColumns    = []string{"clicks"}
+)
+
+type (
+	yourlsCSVReader struct {
+		rows *headerRows
+	}
+
+	// YOURLSJSONReader reads the "links" object of a YOURLS stats API response
+	// (action=stats&filter=last&format=json), one link at a time.
+	yourlsJSONReader struct {
+		dec     *json.Decoder
+		started bool
+		row     int
+	}
+
+	yourlsLink struct {
+		ShortURL  string  `json:"shorturl"`
+		Keyword   string  `json:"keyword"`
+		URL       string  `json:"url"`
+		Timestamp string  `json:"timestamp"`
+		Clicks    flexInt `json:"clicks"`
+	}
+)
+
+func newYOURLSReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case CSV:
+		return &yourlsCSVReader{rows: newHeaderRows(r)}, nil
+	case JSON, JSONL:
+		return &yourlsJSONReader{dec: json.NewDecoder(r)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func (y *yourlsCSVReader) Read() (shorty.Link, error) {
+	if err := y.rows.next(yourlsKeywordColumns, yourlsURLColumns); err != nil {
+		return shorty.Link{}, err
+	}
+
+	clicks, err := parseCount(y.rows.field(yourlsClicksColumns))
+	if err != nil {
+		return shorty.Link{}, &RowError{Row: y.rows.row, Err: fmt.Errorf("clicks: %v", err)}
+	}
+	created, err := parseTimeLayouts(y.rows.field(yourlsTimestampColumns), yourlsTimeLayouts...)
+	if err != nil {
+		return shorty.Link{}, &RowError{Row: y.rows.row, Err: fmt.Errorf("timestamp: %v", err)}
+	}
+	return importedLink(
+		codeFromURL(y.rows.field(yourlsKeywordColumns)),
+		y.rows.field(yourlsURLColumns),
+		"",
+		clicks,
+		created,
+	), nil
+}
+
+func (y *yourlsJSONReader) Read() (shorty.Link, error) {
+	if !y.started {
+		if err := seekKey(y.dec, "links"); err != nil {
+			return shorty.Link{}, err
+		}
+		if err := expectDelim(y.dec, '{'); err != nil {
+			return shorty.Link{}, err
+		}
+		y.started = true
+	}
+	if !y.dec.More() {
+		return shorty.Link{}, io.EOF
+	}
+
+	y.row++
+	// Keys are "link_1", "link_2", ...
+	if _, err := y.dec.Token(); err != nil {
+		return shorty.Link{}, fmt.Errorf("row %d: decode: %v", y.row, err)
+	}
+	var raw json.RawMessage
+	if err := y.dec.Decode(&raw); err != nil {
+		return shorty.Link{}, fmt.Errorf("row %d: decode: %v", y.row, err)
+	}
+	var yl yourlsLink
+	if err := json.Unmarshal(raw, &yl); err != nil {
+		return shorty.Link{}, &RowError{Row: y.row, Err: err}
+	}
+	created, err := parseTimeLayouts(yl.Timestamp, yourlsTimeLayouts...)
+	if err != nil {
+		return shorty.Link{}, &RowError{Row: y.row, Err: fmt.Errorf("timestamp: %v", err)}
+	}
+
+	code := yl.Keyword
+	if len(code) == 0 {
+		code = codeFromURL(yl.ShortURL)
+	}
+	return importedLink(code, yl.URL, "", int(yl.Clicks), created), nil
+}
@@ -0,0 +1,17 @@
+package metrics
+
+import "github.com/operationspark/shorty/cache"
+
+// RegisterCache exposes the statistics of a link cache in reg.
+func RegisterCache(reg *Registry, c *cache.Store) {
+	reg.CounterFunc("shorty_cache_hits_total", "Link lookups answered with a cached link.",
+		func() float64 { return float64(c.Stats().Hits) })
+	reg.CounterFunc("shorty_cache_negative_hits_total", "Link lookups answered with a cached \"not found\".",
+		func() float64 { return float64(c.Stats().NegativeHits) })
+	reg.CounterFunc("shorty_cache_misses_total", "Link lookups passed to the store behind the cache.",
+		func() float64 { return float64(c.Stats().Misses) })
+	reg.CounterFunc("shorty_cache_evictions_total", "Cached links removed to make room for new ones.",
+		func() float64 { return float64(c.Stats().Evictions) })
+	reg.GaugeFunc("shorty_cache_size", "Codes currently cached.",
+		func() float64 { return float64(c.Stats().Size) })
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+)
+
+// Path the metrics are served at. It takes precedence over a link with the code "metrics".
+const Path = "/metrics"
+
+type (
+	// HTTPOpts configures Middleware.
+	HTTPOpts struct {
+		// When set, scrapes must send it in the "key" header, like API requests.
+		APIKey string
+	}
+
+	middleware struct {
+		mux      *http.ServeMux
+		reg      *Registry
+		apiKey   string
+		requests *Counter
+		duration *Histogram
+		outcomes *Counter
+	}
+)
+
+// Middleware serves reg at Path and records the requests handled by mux: their count by route, method and status,
+// their latency by route, and the outcome of every redirect.
+func Middleware(mux *http.ServeMux, reg *Registry, o HTTPOpts) http.Handler {
+	return &middleware{
+		mux:    mux,
+		reg:    reg,
+		apiKey: o.APIKey,
+		requests: reg.Counter("shorty_http_requests_total",
+			"HTTP requests handled, by route, method and status code.", "route", "method", "code"),
+		duration: reg.Histogram("shorty_http_request_duration_seconds",
+			"Time taken to handle HTTP requests, by route.", DefaultBuckets, "route"),
+		outcomes: reg.Counter("shorty_redirects_total",
+			"Short link lookups, by outcome: hit, miss or error.", "outcome"),
+	}
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == Path {
+		if len(m.apiKey) > 0 && r.Header.Get("key") != m.apiKey {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		m.reg.ServeHTTP(w, r)
+		return
+	}
+
+	_, pattern := m.mux.Handler(r)
+	route := routeName(pattern)
+	rec := handlers.NewStatusRecorder(w)
+	start := time.Now()
+	m.mux.ServeHTTP(rec, r)
+
+	m.duration.Observe(time.Since(start).Seconds(), route)
+	m.requests.Inc(route, methodName(r.Method), strconv.Itoa(rec.Status))
+	if route == "resolver" {
+		if outcome := redirectOutcome(rec.Status); len(outcome) > 0 {
+			m.outcomes.Inc(outcome)
+		}
+	}
+}
+
+// RouteName groups mux patterns into a few routes, so codes and API paths don't each get their own series.
+func routeName(pattern string) string {
+	switch {
+	case pattern == "/":
+		return "resolver"
+	case strings.HasPrefix(pattern, "/api/"):
+		return "api"
+	case pattern == "/healthz" || pattern == "/readyz":
+		return "health"
+	}
+	return "other"
+}
+
+// MethodName returns standard HTTP methods as is, and "other" for the rest, since clients can send any method.
+func methodName(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// RedirectOutcome classifies a resolver response. Responses that aren't lookups, like 405s, have no outcome.
+func redirectOutcome(status int) string {
+	switch {
+	case status >= 300 && status < 400:
+		return "hit"
+	case status == http.StatusNotFound:
+		return "miss"
+	case status >= 500:
+		return "error"
+	}
+	return ""
+}
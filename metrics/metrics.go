@@ -0,0 +1,273 @@
+// Package metrics keeps counters and histograms for request, store and cache activity,
+// and serves them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type (
+	// Registry holds metrics by name and writes them for scraping.
+	Registry struct {
+		lock    sync.Mutex
+		metrics map[string]metric
+	}
+
+	// Counter is a cumulative count, one per combination of label values.
+	Counter struct {
+		lock   sync.Mutex
+		labels []string
+		series map[string]*counterSeries
+	}
+
+	// Histogram counts observations, such as latencies, in buckets, one set per combination of label values.
+	Histogram struct {
+		lock    sync.Mutex
+		labels  []string
+		buckets []float64
+		series  map[string]*histogramSeries
+	}
+
+	metric interface {
+		write(w io.Writer, name string) error
+	}
+
+	header struct {
+		help, typ string
+		metric
+	}
+
+	counterSeries struct {
+		values []string
+		value  float64
+	}
+
+	histogramSeries struct {
+		values []string
+		// Observations per bucket, not cumulative. The last count is for observations above every bucket.
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+
+	valueFunc func() float64
+)
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{labels: labels, series: map[string]*counterSeries{}}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// Histogram registers a histogram with the given bucket upper bounds and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// CounterFunc registers a counter whose value is read from fn on every scrape.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", valueFunc(fn))
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", valueFunc(fn))
+}
+
+// Register panics if name is taken, since that's a programming error.
+func (r *Registry) register(name, help, typ string, m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: %q registered twice", name))
+	}
+	r.metrics[name] = header{help: help, typ: typ, metric: m}
+}
+
+// Write writes every metric in the text exposition format, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for n, name := range names {
+		metrics[n] = r.metrics[name]
+	}
+	r.lock.Unlock()
+
+	for n, m := range metrics {
+		if err := m.write(w, names[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "Only GET requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.Write(w); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write metrics: %v", err)
+	}
+}
+
+func (h header) write(w io.Writer, name string) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(h.help), name, h.typ); err != nil {
+		return err
+	}
+	return h.metric.write(w, name)
+}
+
+// Inc adds one to the count for the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the count for the label values.
+func (c *Counter) Add(v float64, values ...string) {
+	checkValues(c.labels, values)
+	key := seriesKey(values)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string{}, values...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *Counter) write(w io.Writer, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", name, labelPairs(c.labels, s.values), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Observe counts v in the histogram for the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	checkValues(h.labels, values)
+	key := seriesKey(values)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string{}, values...), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer, name string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for n, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if n < len(h.buckets) {
+				le = h.buckets[n]
+			}
+			values := append(append([]string{}, s.values...), formatFloat(le))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelPairs(labels, values), cumulative); err != nil {
+				return err
+			}
+		}
+		pairs := labelPairs(h.labels, s.values)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, pairs, formatFloat(s.sum), name, pairs, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fn valueFunc) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(fn()))
+	return err
+}
+
+// CheckValues panics if a metric is used with the wrong number of label values.
+func checkValues(labels, values []string) {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: want %d label values, got %d", len(labels), len(values)))
+	}
+}
+
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LabelPairs formats labels as {name="value",...}, or nothing when there are no labels.
+func labelPairs(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for n, l := range labels {
+		pairs[n] = l + `="` + labelValueEscaper.Replace(values[n]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+func scrape(t *testing.T, h http.Handler, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, Path, nil)
+	request.Header.Set("key", key)
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return response
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("writes counters and histograms in the text format", func(t *testing.T) {
+		reg := NewRegistry()
+		requests := reg.Counter("test_requests_total", "Requests.", "route")
+		latency := reg.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+		reg.GaugeFunc("test_size", "Size.", func() float64 { return 3 })
+
+		requests.Inc("api")
+		requests.Add(2, `say "hi"`)
+		latency.Observe(0.05, "api")
+		latency.Observe(0.5, "api")
+		latency.Observe(2, "api")
+
+		var b strings.Builder
+		if err := reg.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, b.String(), strings.Join([]string{
+			"# HELP test_latency_seconds Latency.",
+			"# TYPE test_latency_seconds histogram",
+			`test_latency_seconds_bucket{route="api",le="0.1"} 1`,
+			`test_latency_seconds_bucket{route="api",le="1"} 2`,
+			`test_latency_seconds_bucket{route="api",le="+Inf"} 3`,
+			`test_latency_seconds_sum{route="api"} 2.55`,
+			`test_latency_seconds_count{route="api"} 3`,
+			"# HELP test_requests_total Requests.",
+			"# TYPE test_requests_total counter",
+			`test_requests_total{route="api"} 1`,
+			`test_requests_total{route="say \"hi\""} 2`,
+			"# HELP test_size Size.",
+			"# TYPE test_size gauge",
+			"test_size 3",
+			"",
+		}, "\n"))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/urls", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/abc123":
+			http.Redirect(w, r, "https://operationspark.org", http.StatusTemporaryRedirect)
+		case "/broken":
+			http.Error(w, "Could not resolve link", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	t.Run("records requests by route and redirect outcomes", func(t *testing.T) {
+		h := Middleware(mux, NewRegistry(), HTTPOpts{})
+		for _, path := range []string{"/abc123", "/abc123", "/nope", "/broken", "/api/urls"} {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+		// Made up methods share one series
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/api/urls", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/api/urls", nil))
+
+		response := scrape(t, h, "")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		body := response.Body.String()
+		testutil.AssertContains(t, body, `shorty_http_requests_total{route="resolver",method="GET",code="307"} 2`)
+		testutil.AssertContains(t, body, `shorty_http_requests_total{route="api",method="GET",code="200"} 1`)
+		testutil.AssertContains(t, body, `shorty_http_requests_total{route="api",method="other",code="200"} 2`)
+		testutil.AssertContains(t, body, `shorty_http_request_duration_seconds_count{route="resolver"} 4`)
+		testutil.AssertContains(t, body, `shorty_redirects_total{outcome="hit"} 2`)
+		testutil.AssertContains(t, body, `shorty_redirects_total{outcome="miss"} 1`)
+		testutil.AssertContains(t, body, `shorty_redirects_total{outcome="error"} 1`)
+	})
+
+	t.Run("requires the API key when configured", func(t *testing.T) {
+		h := Middleware(mux, NewRegistry(), HTTPOpts{APIKey: "secret"})
+
+		testutil.AssertStatus(t, scrape(t, h, "").Code, http.StatusUnauthorized)
+		testutil.AssertStatus(t, scrape(t, h, "secret").Code, http.StatusOK)
+	})
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+)
+
+// Store records the latency and errors of every call to another LinkStore.
+type Store struct {
+	next     handlers.LinkStore
+	duration *Histogram
+	errors   *Counter
+}
+
+// NewStore wraps a LinkStore and registers its metrics in reg.
+func NewStore(next handlers.LinkStore, reg *Registry) *Store {
+	return &Store{
+		next: next,
+		duration: reg.Histogram("shorty_store_operation_duration_seconds",
+			"Time taken by link store operations, by operation.", DefaultBuckets, "op"),
+		errors: reg.Counter("shorty_store_errors_total",
+			"Link store operations that failed, by operation.", "op"),
+	}
+}
+
+// Observe records an operation that started at start. Unknown and taken codes are answers, not failures.
+func (s *Store) observe(op string, start time.Time, err error) {
+	s.duration.Observe(time.Since(start).Seconds(), op)
+	if err != nil && !errors.Is(err, shorty.ErrLinkNotFound) && !errors.Is(err, shorty.ErrCodeInUse) {
+		s.errors.Inc(op)
+	}
+}
+
+func (s *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	start := time.Now()
+	link, err := s.next.FindLink(ctx, code)
+	s.observe("FindLink", start, err)
+	return link, err
+}
+
+func (s *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
+	start := time.Now()
+	links, err := s.next.FindAllLinks(ctx)
+	s.observe("FindAllLinks", start, err)
+	return links, err
+}
+
+// EachLink times the whole stream. Errors returned by fn don't count as store errors.
+func (s *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	start := time.Now()
+	var fnErr error
+	err := s.next.EachLink(ctx, func(l shorty.Link) error {
+		fnErr = fn(l)
+		return fnErr
+	})
+	storeErr := err
+	if fnErr != nil {
+		storeErr = nil
+	}
+	s.observe("EachLink", start, storeErr)
+	return err
+}
+
+func (s *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	start := time.Now()
+	link, err := s.next.SaveLink(ctx, newLink)
+	s.observe("SaveLink", start, err)
+	return link, err
+}
+
+func (s *Store) UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	start := time.Now()
+	link, err := s.next.UpdateLink(ctx, code, toUpdate, opts)
+	s.observe("UpdateLink", start, err)
+	return link, err
+}
+
+func (s *Store) DeleteLink(ctx context.Context, code string) (int, error) {
+	start := time.Now()
+	n, err := s.next.DeleteLink(ctx, code)
+	s.observe("DeleteLink", start, err)
+	return n, err
+}
+
+func (s *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
+	start := time.Now()
+	inUse, err := s.next.CheckCodeInUse(ctx, code)
+	s.observe("CheckCodeInUse", start, err)
+	return inUse, err
+}
+
+func (s *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	start := time.Now()
+	codes, err := s.next.FindSimilarCodes(ctx, code, maxDistance)
+	s.observe("FindSimilarCodes", start, err)
+	return codes, err
+}
+
+func (s *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
+	start := time.Now()
+	n, err := s.next.IncrementTotalClicks(ctx, code)
+	s.observe("IncrementTotalClicks", start, err)
+	return n, err
+}
+
+func (s *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	start := time.Now()
+	err := s.next.IncrementTotalClicksBatch(ctx, counts)
+	s.observe("IncrementTotalClicksBatch", start, err)
+	return err
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
+)
+
+// FailingStore fails every Ping.
+type failingStore struct {
+	handlers.LinkStore
+}
+
+func (failingStore) Ping(ctx context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("records latencies and errors by operation", func(t *testing.T) {
+		reg := NewRegistry()
+		store := NewStore(failingStore{inmem.NewStore()}, reg)
+
+		if _, err := store.FindLink(ctx, "nope"); err != shorty.ErrLinkNotFound {
+			t.Fatalf("want ErrLinkNotFound, got %v", err)
+		}
+		if err := store.Ping(ctx); err == nil {
+			t.Fatal("want an error")
+		}
+
+		var b strings.Builder
+		if err := reg.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertContains(t, b.String(), `shorty_store_operation_duration_seconds_count{op="FindLink"} 1`)
+		testutil.AssertContains(t, b.String(), `shorty_store_errors_total{op="Ping"} 1`)
+		// Unknown codes aren't errors
+		testutil.AssertEqual(t, strings.Contains(b.String(), `shorty_store_errors_total{op="FindLink"}`), false)
+	})
+}
+
+func TestStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+		return NewStore(inmem.NewStore(), NewRegistry())
+	})
+}
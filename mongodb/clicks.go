@@ -0,0 +1,168 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultClicksCollName = "clicks"
+
+// ClickRetention is how long click events and visitor sketches are kept. TTL indexes remove them afterward.
+// Changing it takes a migration that changes the indexes' expireAfterSeconds.
+const ClickRetention = 400 * 24 * time.Hour
+
+func clicksColl(s *Store) *mongo.Collection {
+	name := s.ClicksCollName
+	if len(name) == 0 {
+		name = defaultClicksCollName
+	}
+	return s.Client.Database(s.DBName).Collection(name)
+}
+
+// SaveClicks adds the clicks' visitors to their day's sketch and inserts the click events.
+// The insert is unordered, so one bad event doesn't stop the rest.
+// Sketches are written first: adding a visitor again is a no-op, so retrying after a failed insert doesn't overcount them.
+func (i *Store) SaveClicks(ctx context.Context, clicks []shorty.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	if err := i.saveVisitors(ctx, clicks); err != nil {
+		return fmt.Errorf("saveVisitors: %v", err)
+	}
+
+	docs := make([]interface{}, len(clicks))
+	for n, c := range clicks {
+		docs[n] = c
+	}
+	if _, err := clicksColl(i).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		return fmt.Errorf("insertMany: %v", err)
+	}
+	return nil
+}
+
+// RenameClickData moves a link's click events and visitor sketches to its new code.
+func (i *Store) renameClickData(ctx context.Context, code, newCode string) error {
+	for _, coll := range []*mongo.Collection{clicksColl(i), visitorsColl(i)} {
+		_, err := coll.UpdateMany(ctx,
+			bson.D{{"code", code}},
+			bson.D{{"$set", bson.D{{"code", newCode}}}},
+		)
+		if err != nil {
+			return fmt.Errorf("updateMany %s: %v", coll.Name(), err)
+		}
+	}
+	return nil
+}
+
+// DeleteClickData deletes a link's click events and visitor sketches, so a new link with the code starts without them.
+func (i *Store) deleteClickData(ctx context.Context, code string) error {
+	for _, coll := range []*mongo.Collection{clicksColl(i), visitorsColl(i)} {
+		if _, err := coll.DeleteMany(ctx, bson.D{{"code", code}}); err != nil {
+			return fmt.Errorf("deleteMany %s: %v", coll.Name(), err)
+		}
+	}
+	return nil
+}
+
+type statsFacets struct {
+	Series []struct {
+		Start  time.Time `bson:"_id"`
+		Clicks int       `bson:"clicks"`
+	} `bson:"series"`
+	Referrers []statsCount `bson:"referrers"`
+	Browsers  []statsCount `bson:"browsers"`
+	OS        []statsCount `bson:"os"`
+	Devices   []statsCount `bson:"devices"`
+}
+
+type statsCount struct {
+	Value  string `bson:"_id"`
+	Clicks int    `bson:"clicks"`
+}
+
+// ClickStats counts the click events matching q in one aggregation. $dateTrunc needs MongoDB 5.0 or later.
+func (i *Store) ClickStats(ctx context.Context, q shorty.StatsQuery) (shorty.ClickStats, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	// Missing fields are grouped as "" rather than null
+	top := func(field string) mongo.Pipeline {
+		return mongo.Pipeline{
+			{{"$group", bson.D{
+				{"_id", bson.D{{"$ifNull", bson.A{field, ""}}}},
+				{"clicks", bson.D{{"$sum", 1}}},
+			}}},
+			{{"$sort", bson.D{{"clicks", -1}, {"_id", 1}}}},
+			{{"$limit", shorty.StatsTopN}},
+		}
+	}
+	match := bson.D{
+		{"code", q.Code},
+		{"at", bson.D{{"$gte", q.From}, {"$lt", q.To}}},
+	}
+	if !q.IncludeBots {
+		match = append(match, bson.E{"bot", bson.D{{"$ne", true}}})
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$facet", bson.D{
+			{"series", mongo.Pipeline{
+				{{"$group", bson.D{
+					{"_id", bson.D{{"$dateTrunc", bson.D{
+						{"date", "$at"},
+						{"unit", q.Interval},
+						{"timezone", "UTC"},
+						{"startOfWeek", "monday"},
+					}}}},
+					{"clicks", bson.D{{"$sum", 1}}},
+				}}},
+			}},
+			{"referrers", top("$referrer")},
+			{"browsers", top("$userAgent.browser")},
+			{"os", top("$userAgent.os")},
+			{"devices", top("$userAgent.device")},
+		}}},
+	}
+
+	cur, err := clicksColl(i).Aggregate(ctx, pipeline)
+	if err != nil {
+		return shorty.ClickStats{}, fmt.Errorf("aggregate: %v", err)
+	}
+	var results []statsFacets
+	if err := cur.All(ctx, &results); err != nil {
+		return shorty.ClickStats{}, fmt.Errorf("all: %v", err)
+	}
+	// $facet always returns one document
+	var facets statsFacets
+	if len(results) > 0 {
+		facets = results[0]
+	}
+
+	series := map[time.Time]int{}
+	for _, b := range facets.Series {
+		series[b.Start.UTC()] = b.Clicks
+	}
+	stats := shorty.NewClickStats(q, series)
+	stats.Referrers = toStatsCounts(facets.Referrers)
+	stats.Browsers = toStatsCounts(facets.Browsers)
+	stats.OS = toStatsCounts(facets.OS)
+	stats.Devices = toStatsCounts(facets.Devices)
+	return stats, nil
+}
+
+func toStatsCounts(counts []statsCount) []shorty.StatsCount {
+	out := make([]shorty.StatsCount, len(counts))
+	for n, c := range counts {
+		out[n] = shorty.StatsCount{Value: c.Value, Clicks: c.Clicks}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// OptsFromEnv reads the MongoDB store options from MONGO_* environment variables.
+func OptsFromEnv() (StoreOpts, error) {
+	opts := StoreOpts{
+		URI:              os.Getenv("MONGO_URI"),
+		DBName:           os.Getenv("MONGO_DB_NAME"),
+		LinksCollName:    os.Getenv("MONGO_LINKS_COLLECTION"),
+		ShardsCollName:   os.Getenv("MONGO_SHARDS_COLLECTION"),
+		ClicksCollName:   os.Getenv("MONGO_CLICKS_COLLECTION"),
+		VisitorsCollName: os.Getenv("MONGO_VISITORS_COLLECTION"),
+		MissesCollName:   os.Getenv("MONGO_MISSES_COLLECTION"),
+		ReadPreference:   os.Getenv("MONGO_READ_PREFERENCE"),
+		WriteConcern:     os.Getenv("MONGO_WRITE_CONCERN"),
+	}
+	if len(opts.URI) == 0 {
+		opts.URI = "mongodb://localhost:27017/url-shortener"
+	}
+
+	var errs []error
+	parseInt := func(name string) int {
+		v := os.Getenv(name)
+		if len(v) == 0 {
+			return 0
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+		return n
+	}
+	parseDuration := func(name string) time.Duration {
+		v := os.Getenv(name)
+		if len(v) == 0 {
+			return 0
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+		return d
+	}
+
+	opts.ClickShards = parseInt("MONGO_CLICK_SHARDS")
+	opts.ValidateSchema = os.Getenv("MONGO_SCHEMA_VALIDATION") == "true"
+	opts.MaxPoolSize = uint64(max(parseInt("MONGO_MAX_POOL_SIZE"), 0))
+	opts.MinPoolSize = uint64(max(parseInt("MONGO_MIN_POOL_SIZE"), 0))
+	opts.ConnectTimeout = parseDuration("MONGO_CONNECT_TIMEOUT")
+	opts.PingTimeout = parseDuration("MONGO_PING_TIMEOUT")
+	opts.OperationTimeout = parseDuration("MONGO_OPERATION_TIMEOUT")
+
+	if err := errors.Join(errs...); err != nil {
+		return opts, err
+	}
+	return opts, opts.Validate()
+}
@@ -0,0 +1,318 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSchemaMismatch is returned by CheckSchema when migrations are pending.
+var ErrSchemaMismatch = errors.New("database schema mismatch")
+
+const migrationsCollName = "migrations"
+
+type (
+	// Migration is a versioned change to the database.
+	// Up must be idempotent. It may run again if an earlier attempt failed part way through.
+	Migration struct {
+		Version int
+		Name    string
+		Up      func(ctx context.Context, s *Store) error
+	}
+
+	MigrationStatus struct {
+		Version int    `bson:"version" json:"version"`
+		Name    string `bson:"name" json:"name"`
+		// When the migration was applied. Zero if it is pending.
+		AppliedAt time.Time `bson:"appliedAt" json:"appliedAt"`
+		// Set for migrations recorded in the database but unknown to this build,
+		// which means the database was migrated by a newer version of the service.
+		Unknown bool `bson:"-" json:"unknown,omitempty"`
+	}
+)
+
+// Migrations are applied in order of Version. Never edit or reorder a released migration; add a new one.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create unique index on links code",
+		Up:      CreateIndex(linksColl, bson.D{{"code", 1}}, true),
+	},
+	{
+		Version: 2,
+		Name:    "backfill link defaults",
+		Up: func(ctx context.Context, s *Store) error {
+			if err := Backfill(linksColl, "totalClicks", 0)(ctx, s); err != nil {
+				return err
+			}
+			if err := Backfill(linksColl, "createdBy", "")(ctx, s); err != nil {
+				return err
+			}
+			// Links created before custom codes existed use their generated code
+			_, err := linksColl(s).UpdateMany(ctx,
+				bson.D{{"customCode", bson.D{{"$exists", false}}}},
+				mongo.Pipeline{{{"$set", bson.D{{"customCode", "$code"}}}}},
+			)
+			if err != nil {
+				return fmt.Errorf("updateMany: %v", err)
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create unique index on click shards code and shard",
+		Up: func(ctx context.Context, s *Store) error {
+			return s.EnsureShardIndexes(ctx)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create index on click events code and time",
+		Up:      CreateIndex(clicksColl, bson.D{{"code", 1}, {"at", 1}}, false),
+	},
+	{
+		Version: 5,
+		Name:    "backfill human and bot clicks",
+		Up: func(ctx context.Context, s *Store) error {
+			if err := Backfill(linksColl, "humanClicks", 0)(ctx, s); err != nil {
+				return err
+			}
+			return Backfill(linksColl, "botClicks", 0)(ctx, s)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create unique index on visitor sketches code and day",
+		Up:      CreateIndex(visitorsColl, bson.D{{"code", 1}, {"day", 1}}, true),
+	},
+	{
+		Version: 7,
+		Name:    "create unique index on misses code and referrer",
+		Up:      CreateIndex(missesColl, bson.D{{"code", 1}, {"referrer", 1}}, true),
+	},
+	{
+		Version: 8,
+		Name:    "backfill private and similarity keys, and index the keys",
+		Up: func(ctx context.Context, s *Store) error {
+			if err := Backfill(linksColl, "private", false)(ctx, s); err != nil {
+				return err
+			}
+			if err := backfillSimilarityKeys(ctx, s); err != nil {
+				return err
+			}
+			return CreateIndex(linksColl, bson.D{{"similarityKeys", 1}}, false)(ctx, s)
+		},
+	},
+	// Version 9 installed the links validator. It was removed because validation is opt-in with StoreOpts.ValidateSchema.
+	{
+		Version: 10,
+		Name:    "expire click events and visitor sketches after ClickRetention",
+		Up: func(ctx context.Context, s *Store) error {
+			if err := CreateTTLIndex(clicksColl, "at", ClickRetention)(ctx, s); err != nil {
+				return err
+			}
+			return CreateTTLIndex(visitorsColl, "day", ClickRetention)(ctx, s)
+		},
+	},
+	{
+		Version: 11,
+		Name:    "expire misses after MissRetention",
+		Up:      CreateTTLIndex(missesColl, "lastSeen", MissRetention),
+	},
+}
+
+func linksColl(s *Store) *mongo.Collection {
+	return s.Client.Database(s.DBName).Collection(s.LinksCollName)
+}
+
+// CreateIndex returns a migration step that creates an index on the collection returned by coll.
+// Creating an index that already exists with the same options is a no-op.
+func CreateIndex(coll func(*Store) *mongo.Collection, keys bson.D, unique bool) func(context.Context, *Store) error {
+	return func(ctx context.Context, s *Store) error {
+		_, err := coll(s).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    keys,
+			Options: options.Index().SetUnique(unique),
+		})
+		if err != nil {
+			return fmt.Errorf("createIndex: %v", err)
+		}
+		return nil
+	}
+}
+
+// CreateTTLIndex returns a migration step that creates an index on field, a date, which removes documents once it's older than after.
+func CreateTTLIndex(coll func(*Store) *mongo.Collection, field string, after time.Duration) func(context.Context, *Store) error {
+	return func(ctx context.Context, s *Store) error {
+		_, err := coll(s).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{field, 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(after.Seconds())),
+		})
+		if err != nil {
+			return fmt.Errorf("createIndex: %v", err)
+		}
+		return nil
+	}
+}
+
+// Backfill returns a migration step that sets field to value on documents that don't have the field.
+func Backfill(coll func(*Store) *mongo.Collection, field string, value interface{}) func(context.Context, *Store) error {
+	return func(ctx context.Context, s *Store) error {
+		_, err := coll(s).UpdateMany(ctx,
+			bson.D{{field, bson.D{{"$exists", false}}}},
+			bson.D{{"$set", bson.D{{field, value}}}},
+		)
+		if err != nil {
+			return fmt.Errorf("updateMany: %v", err)
+		}
+		return nil
+	}
+}
+
+// RenameField returns a migration step that renames the field from to the field to on every document that has it.
+func RenameField(coll func(*Store) *mongo.Collection, from, to string) func(context.Context, *Store) error {
+	return func(ctx context.Context, s *Store) error {
+		_, err := coll(s).UpdateMany(ctx,
+			bson.D{{from, bson.D{{"$exists", true}}}},
+			bson.D{{"$rename", bson.D{{from, to}}}},
+		)
+		if err != nil {
+			return fmt.Errorf("updateMany: %v", err)
+		}
+		return nil
+	}
+}
+
+func (i *Store) migrationsColl() *mongo.Collection {
+	return i.Client.Database(i.DBName).Collection(migrationsCollName)
+}
+
+// MigrationStatus lists every known migration, marking the ones that have been applied,
+// followed by any applied migrations this build doesn't know about.
+func (i *Store) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	cur, err := i.migrationsColl().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("find: %v", err)
+	}
+	var applied []MigrationStatus
+	if err := cur.All(ctx, &applied); err != nil {
+		return nil, fmt.Errorf("all: %v", err)
+	}
+
+	appliedByVersion := map[int]MigrationStatus{}
+	for _, a := range applied {
+		appliedByVersion[a.Version] = a
+	}
+
+	statuses := make([]MigrationStatus, 0, len(Migrations))
+	for _, m := range sortedMigrations() {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := appliedByVersion[m.Version]; ok {
+			status.AppliedAt = a.AppliedAt
+			delete(appliedByVersion, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	var unknown []MigrationStatus
+	for _, a := range appliedByVersion {
+		a.Unknown = true
+		unknown = append(unknown, a)
+	}
+	sort.Slice(unknown, func(a, b int) bool { return unknown[a].Version < unknown[b].Version })
+	return append(statuses, unknown...), nil
+}
+
+// Migrate applies pending migrations in order and returns the ones it applied.
+// With dryRun set, nothing is changed and the pending migrations are returned.
+func (i *Store) Migrate(ctx context.Context, dryRun bool) ([]MigrationStatus, error) {
+	if !dryRun {
+		// Concurrent runs race to record a version; the unique index lets only one win
+		_, err := i.migrationsColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{"version", 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("createIndex: %v", err)
+		}
+	}
+
+	statuses, err := i.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := map[int]bool{}
+	for _, s := range statuses {
+		if s.AppliedAt.IsZero() && !s.Unknown {
+			pending[s.Version] = true
+		}
+	}
+
+	ran := []MigrationStatus{}
+	for _, m := range sortedMigrations() {
+		if !pending[m.Version] {
+			continue
+		}
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if dryRun {
+			ran = append(ran, status)
+			continue
+		}
+
+		if err := m.Up(ctx, i); err != nil {
+			return ran, fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+		}
+		status.AppliedAt = time.Now()
+		_, err := i.migrationsColl().InsertOne(ctx, status)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return ran, fmt.Errorf("record migration %d: %v", m.Version, err)
+		}
+		ran = append(ran, status)
+	}
+	return ran, nil
+}
+
+// CheckSchema returns ErrSchemaMismatch if there are pending migrations.
+// Migrations this build doesn't know about only log a warning, since deploys migrate the database
+// before the new version starts serving, and the old version keeps running until then.
+func (i *Store) CheckSchema(ctx context.Context) error {
+	statuses, err := i.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending, unknown []int
+	for _, s := range statuses {
+		switch {
+		case s.Unknown:
+			unknown = append(unknown, s.Version)
+		case s.AppliedAt.IsZero():
+			pending = append(pending, s.Version)
+		}
+	}
+	if len(unknown) > 0 {
+		log.Println(gcp.LogEntry{
+			Severity:  "WARNING",
+			Message:   fmt.Sprintf("database has migrations %v which this version does not know about", unknown),
+			Component: "mongodb",
+		})
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: migrations %v are pending; run cmd/migrate", ErrSchemaMismatch, pending)
+	}
+	return nil
+}
+
+func sortedMigrations() []Migration {
+	sorted := append([]Migration{}, Migrations...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Version < sorted[b].Version })
+	return sorted
+}
@@ -0,0 +1,123 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultMissesCollName = "misses"
+
+// Misses are counted per code and referrer, so a mistyped code only ever has one document per page linking to it:
+//
+//	{ "code": "fal-cohort", "referrer": "", "count": 12, "firstSeen": ISODate(...), "lastSeen": ISODate(...) }
+
+// MissGroup is the misses of one code, totaled across referrers.
+type missGroup struct {
+	Code      string         `bson:"_id"`
+	Misses    int            `bson:"misses"`
+	FirstSeen time.Time      `bson:"firstSeen"`
+	LastSeen  time.Time      `bson:"lastSeen"`
+	Referrers []missReferrer `bson:"referrers"`
+}
+
+type missReferrer struct {
+	Referrer string `bson:"referrer"`
+	Count    int    `bson:"count"`
+}
+
+func missesColl(s *Store) *mongo.Collection {
+	name := s.MissesCollName
+	if len(name) == 0 {
+		name = defaultMissesCollName
+	}
+	return s.Client.Database(s.DBName).Collection(name)
+}
+
+// MissRetention is how long a code and referrer's misses are kept after the last one. A TTL index removes them afterward.
+// Changing it takes a migration that changes the index's expireAfterSeconds.
+const MissRetention = 90 * 24 * time.Hour
+
+// RecordMisses adds the tallies to their code and referrer's counts in a single bulk write.
+func (i *Store) RecordMisses(ctx context.Context, tallies []shorty.MissTally) error {
+	if len(tallies) == 0 {
+		return nil
+	}
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(tallies))
+	for _, t := range tallies {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"code", t.Code}, {"referrer", t.Referrer}}).
+			SetUpdate(bson.D{
+				{"$inc", bson.D{{"count", t.Count}}},
+				{"$min", bson.D{{"firstSeen", t.FirstSeen}}},
+				{"$max", bson.D{{"lastSeen", t.LastSeen}}},
+			}).
+			SetUpsert(true))
+	}
+	if _, err := missesColl(i).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("bulkWrite: %v", err)
+	}
+	return nil
+}
+
+// TopMisses totals the misses of each code from the referrers seen since q.Since. Codes that have since been given a link are left out.
+func (i *Store) TopMisses(ctx context.Context, q shorty.MissQuery) ([]shorty.MissCount, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		// Matched first so old documents are skipped by the lastSeen index rather than grouped
+		{{"$match", bson.D{{"lastSeen", bson.D{{"$gte", q.Since}}}}}},
+		{{"$group", bson.D{
+			{"_id", "$code"},
+			{"misses", bson.D{{"$sum", "$count"}}},
+			{"firstSeen", bson.D{{"$min", "$firstSeen"}}},
+			{"lastSeen", bson.D{{"$max", "$lastSeen"}}},
+			{"referrers", bson.D{{"$push", bson.D{{"referrer", "$referrer"}, {"count", "$count"}}}}},
+		}}},
+		{{"$lookup", bson.D{
+			{"from", i.LinksCollName},
+			{"localField", "_id"},
+			{"foreignField", "code"},
+			{"as", "links"},
+		}}},
+		{{"$match", bson.D{{"links", bson.D{{"$size", 0}}}}}},
+		{{"$sort", bson.D{{"misses", -1}, {"_id", 1}}}},
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{"$limit", q.Limit}})
+	}
+
+	cur, err := missesColl(i).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate: %v", err)
+	}
+	var groups []missGroup
+	if err := cur.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("all: %v", err)
+	}
+
+	top := make([]shorty.MissCount, len(groups))
+	for n, g := range groups {
+		referrers := map[string]int{}
+		for _, r := range g.Referrers {
+			referrers[r.Referrer] = r.Count
+		}
+		top[n] = shorty.MissCount{
+			Code:      g.Code,
+			Misses:    g.Misses,
+			FirstSeen: g.FirstSeen,
+			LastSeen:  g.LastSeen,
+			Referrers: shorty.TopCounts(referrers, shorty.MissTopReferrers),
+		}
+	}
+	return top, nil
+}
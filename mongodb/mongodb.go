@@ -3,16 +3,12 @@ package mongodb
 import (
 	"context"
 	"fmt"
-	"net/url"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/operationspark/shorty/shorty"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type (
@@ -21,44 +17,80 @@ type (
 		Client        *mongo.Client
 		DBName        string
 		LinksCollName string
-	}
-
-	StoreOpts struct {
-		URI string
+		// Number of click counter shards per link. Clicks are written to ShardsCollName when greater than 1.
+		ClickShards int
+		// Collection holding the sharded click counters. Defaults to "urlClickShards".
+		ShardsCollName string
+		// Collection holding click events. Defaults to "clicks".
+		ClicksCollName string
+		// Collection holding the daily unique visitor sketches. Defaults to "visitors".
+		VisitorsCollName string
+		// Collection holding the counts of requests for unknown codes. Defaults to "misses".
+		MissesCollName string
+		// Deadline applied to each operation whose context doesn't have an earlier one. No deadline when 0.
+		OperationTimeout time.Duration
 	}
 )
 
-// NewStore creates an empty Shorty store.
+// NewStore connects to MongoDB and creates a Shorty store.
 func NewStore(o StoreOpts) (*Store, error) {
-	client, err := mongo.Connect(
-		context.TODO(),
-		options.Client().ApplyURI(o.URI),
-	)
+	if err := o.Validate(); err != nil {
+		return &Store{}, fmt.Errorf("validate: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), withDefault(o.ConnectTimeout, 10*time.Second))
+	defer cancel()
+	client, err := mongo.Connect(ctx, o.clientOptions())
 	if err != nil {
 		return &Store{}, fmt.Errorf("connect: %v", err)
 	}
 
-	// Ping the primary
-	if err := client.Ping(context.TODO(), readpref.Primary()); err != nil {
+	// Ping a member the configured read preference allows, rather than always the primary
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), withDefault(o.PingTimeout, 10*time.Second))
+	defer cancelPing()
+	if err := client.Ping(pingCtx, nil); err != nil {
 		return &Store{}, fmt.Errorf("ping: %v", err)
 	}
 
-	// Grab the DB Name from the connection URI or Env vars
-	connectionURI, err := url.Parse(o.URI)
-	if err != nil {
-		return &Store{}, fmt.Errorf("parse: %v", err)
+	s := Store{
+		Client:           client,
+		DBName:           o.dbName(),
+		LinksCollName:    "urls",
+		ClickShards:      o.ClickShards,
+		ShardsCollName:   defaultShardsCollName,
+		ClicksCollName:   defaultClicksCollName,
+		VisitorsCollName: defaultVisitorsCollName,
+		MissesCollName:   defaultMissesCollName,
+		OperationTimeout: o.OperationTimeout,
+	}
+	if len(o.LinksCollName) > 0 {
+		s.LinksCollName = o.LinksCollName
+	}
+	if len(o.ShardsCollName) > 0 {
+		s.ShardsCollName = o.ShardsCollName
+	}
+	if len(o.ClicksCollName) > 0 {
+		s.ClicksCollName = o.ClicksCollName
+	}
+	if len(o.VisitorsCollName) > 0 {
+		s.VisitorsCollName = o.VisitorsCollName
+	}
+	if len(o.MissesCollName) > 0 {
+		s.MissesCollName = o.MissesCollName
 	}
 
-	dbName := strings.TrimPrefix(connectionURI.Path, "/")
-	envDBName := os.Getenv("MONGO_DB_NAME")
-	if len(envDBName) > 0 {
-		dbName = envDBName
+	if o.ValidateSchema {
+		if err := s.InstallValidator(context.Background()); err != nil {
+			return &Store{}, err
+		}
 	}
 
-	s := Store{
-		Client:        client,
-		DBName:        dbName,
-		LinksCollName: "urls",
+	if s.sharded() {
+		ctx, cancel := s.opContext(context.Background())
+		defer cancel()
+		if err := s.EnsureShardIndexes(ctx); err != nil {
+			return &Store{}, fmt.Errorf("ensureShardIndexes: %v", err)
+		}
 	}
 
 	return &s, nil
@@ -66,37 +98,113 @@ func NewStore(o StoreOpts) (*Store, error) {
 
 // SaveLink inserts a new Link into the database.
 func (i *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
 	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
-	_, err := coll.InsertOne(ctx, newLink)
+	_, err := coll.InsertOne(ctx, newLinkDoc(newLink))
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return shorty.Link{}, shorty.ErrCodeInUse
+		}
+		if vErr := validationError(err); vErr != nil {
+			return shorty.Link{}, vErr
+		}
 		return shorty.Link{}, fmt.Errorf("insertOne: %v", err)
 	}
 	return newLink, nil
 }
 
-// IncrementTotalClicks increments the "totalClicks" field and updates the database.
+// IncrementTotalClicks increments the "totalClicks" and "humanClicks" fields and returns the new total.
 func (i *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+	if i.sharded() {
+		// Check the link exists so shards aren't created for unknown codes
+		exists, err := i.linkExists(ctx, code)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, shorty.ErrLinkNotFound
+		}
+		if err := i.incrementShard(ctx, code, shorty.ClickCounts{Human: 1}); err != nil {
+			return 0, err
+		}
+		link, err := i.FindLink(ctx, code)
+		if err != nil {
+			return 0, err
+		}
+		return link.TotalClicks, nil
+	}
+
 	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
-	res, err := coll.UpdateOne(
+	var link shorty.Link
+	err := coll.FindOneAndUpdate(
 		ctx,
 		bson.D{{"code", code}},
 		bson.D{
-			{"$inc", bson.D{{"totalClicks", 1}}},
+			{"$inc", bson.D{{"totalClicks", 1}, {"humanClicks", 1}}},
 			{"$set", bson.D{{"updatedAt", time.Now()}}},
 		},
-	)
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&link)
 	if err != nil {
-		return 0, fmt.Errorf("updateOne: %v", err)
+		if err == mongo.ErrNoDocuments {
+			return 0, shorty.ErrLinkNotFound
+		}
+		return 0, fmt.Errorf("findOneAndUpdate: %v", err)
+	}
+	return link.TotalClicks, nil
+}
+
+// IncrementTotalClicksBatch adds each count to the matching link's click fields in a single bulk write.
+func (i *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+	if len(counts) == 0 {
+		return nil
+	}
+	if i.sharded() {
+		return i.incrementShardsBatch(ctx, counts)
 	}
-	if res.ModifiedCount == 0 {
-		return 0, shorty.ErrLinkNotFound
+	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(counts))
+	for code, n := range counts {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"code", code}}).
+			SetUpdate(bson.D{
+				{"$inc", clickIncrements(n)},
+				{"$set", bson.D{{"updatedAt", now}}},
+			}),
+		)
 	}
-	return int(res.ModifiedCount), nil
+
+	// Unordered so one bad write doesn't prevent the rest of the batch from being applied
+	_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("bulkWrite: %v", err)
+	}
+	return nil
 }
 
 // FindLink finds the Link with the given code.
 func (i *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
 	var link shorty.Link
+	if i.sharded() {
+		links, err := i.findShardedLinks(ctx, bson.D{{"code", code}})
+		if err != nil {
+			return link, err
+		}
+		if len(links) == 0 {
+			return link, shorty.ErrLinkNotFound
+		}
+		return *links[0], nil
+	}
+
 	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
 
 	res := coll.FindOne(ctx, bson.D{{"code", code}})
@@ -116,6 +224,11 @@ func (i *Store) FindLink(ctx context.Context, code string) (shorty.Link, error)
 
 // FindAllLinks returns all the links from the database.
 func (i *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+	if i.sharded() {
+		return i.findShardedLinks(ctx, bson.D{})
+	}
 	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
 	cur, err := coll.Find(ctx, bson.D{{}})
 	if err != nil {
@@ -123,13 +236,51 @@ func (i *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
 	}
 	defer cur.Close(ctx)
 
-	links := make(shorty.Links, cur.RemainingBatchLength())
-	cur.All(ctx, &links)
+	links := shorty.Links{}
+	if err := cur.All(ctx, &links); err != nil {
+		return shorty.Links{}, fmt.Errorf("all: %v", err)
+	}
 	return links, nil
 }
 
-// UpdateLink updates a links originalUrl if given. If a code is given, shortCode, code, and customCode are updated. The updatedAt is set to the current time.
-func (i *Store) UpdateLink(ctx context.Context, code string, link shorty.Link) (shorty.Link, error) {
+// EachLink calls fn with every link in order of code, reading them from a cursor rather than loading them all.
+// Iteration stops at the first error returned by fn. OperationTimeout isn't applied, since a large
+// export can take longer than any single operation; use ctx to bound it.
+func (i *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
+	var cur *mongo.Cursor
+	var err error
+	if i.sharded() {
+		cur, err = coll.Aggregate(ctx, i.shardedLinksPipeline(bson.D{}, bson.D{{"code", 1}}))
+	} else {
+		cur, err = coll.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{"code", 1}}))
+	}
+	if err != nil {
+		return fmt.Errorf("find: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var link shorty.Link
+		if err := cur.Decode(&link); err != nil {
+			return fmt.Errorf("decode: %v", err)
+		}
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("cursor: %v", err)
+	}
+	return nil
+}
+
+// UpdateLink updates a links originalUrl and private flag if given. If a customCode is given, shortUrl, code, and customCode
+// are updated, and the link's click events and visitor sketches move to the new code.
+// The updatedAt is set to the current time and the updated link is returned.
+func (i *Store) UpdateLink(ctx context.Context, code string, link shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
 	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
 
 	updateDoc := bson.D{
@@ -138,41 +289,96 @@ func (i *Store) UpdateLink(ctx context.Context, code string, link shorty.Link) (
 	if len(link.OriginalUrl) > 0 {
 		updateDoc = append(updateDoc, bson.E{"originalUrl", link.OriginalUrl})
 	}
+	if opts.Private != nil {
+		updateDoc = append(updateDoc, bson.E{"private", *opts.Private})
+	}
 
+	newCode := code
 	if len(link.CustomCode) > 0 {
+		newCode = link.CustomCode
 		updateDoc = append(updateDoc,
 			bson.E{"shortUrl", link.ShortURL},
-			bson.E{"code", link.Code},
+			bson.E{"code", link.CustomCode},
 			bson.E{"customCode", link.CustomCode},
+			bson.E{"similarityKeys", shorty.SimilarityKeys(link.CustomCode, shorty.MaxSimilarDistance)},
 		)
 	}
-	res, err := coll.UpdateOne(
+
+	var updated shorty.Link
+	err := coll.FindOneAndUpdate(
 		ctx,
 		bson.D{{"code", code}},
 		bson.D{{"$set", updateDoc}},
-	)
-
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
 	if err != nil {
-		return link, fmt.Errorf("replaceOne: %v", err)
+		if err == mongo.ErrNoDocuments {
+			return shorty.Link{}, shorty.ErrLinkNotFound
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return shorty.Link{}, shorty.ErrCodeInUse
+		}
+		if vErr := validationError(err); vErr != nil {
+			return shorty.Link{}, vErr
+		}
+		return shorty.Link{}, fmt.Errorf("findOneAndUpdate: %v", err)
 	}
-	if res.ModifiedCount == 0 {
-		return link, shorty.ErrLinkNotFound
+
+	if newCode != code {
+		if err := i.renameClickData(ctx, code, newCode); err != nil {
+			return shorty.Link{}, err
+		}
 	}
-	return link, nil
+	if i.sharded() {
+		if newCode != code {
+			if err := i.renameShards(ctx, code, newCode); err != nil {
+				return shorty.Link{}, err
+			}
+		}
+		// The click total lives in the shards
+		return i.FindLink(ctx, newCode)
+	}
+	return updated, nil
 }
 
-// DeleteLink deletes a link from the database.
+// DeleteLink deletes a link from the database, along with its click events and visitor sketches.
 func (i *Store) DeleteLink(ctx context.Context, code string) (int, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
 	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
 	res, err := coll.DeleteOne(ctx, bson.D{{"code", code}})
 	if err != nil {
 		return 0, fmt.Errorf("deleteOne: %v", err)
 	}
+	if res.DeletedCount == 0 {
+		return 0, nil
+	}
+	if i.sharded() {
+		if err := i.deleteShards(ctx, code); err != nil {
+			return int(res.DeletedCount), err
+		}
+	}
+	if err := i.deleteClickData(ctx, code); err != nil {
+		return int(res.DeletedCount), err
+	}
 	return int(res.DeletedCount), nil
 }
 
+// Ping checks that a server matching the client's read preference is reachable, ex: any secondary with
+// MONGO_READ_PREFERENCE=secondaryPreferred, so reads keep being served while a primary is elected.
+func (i *Store) Ping(ctx context.Context) error {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+	if err := i.Client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("ping: %v", err)
+	}
+	return nil
+}
+
 // CheckCodeInUse returns false if the code is available for use, or true if the code is already in use.
 func (i *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
 	_, err := i.FindLink(ctx, code)
 	if err != nil {
 		if err == shorty.ErrLinkNotFound {
@@ -184,3 +390,8 @@ func (i *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
 	// Code found
 	return true, nil
 }
+
+// ClickIncrements is the $inc document that adds counts to a link.
+func clickIncrements(counts shorty.ClickCounts) bson.D {
+	return bson.D{{"totalClicks", counts.Human}, {"humanClicks", counts.Human}, {"botClicks", counts.Bot}}
+}
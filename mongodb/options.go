@@ -0,0 +1,160 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+type StoreOpts struct {
+	// MongoDB connection string. Ex: mongodb://localhost:27017/url-shortener
+	URI string
+	// Database name. Defaults to the database in the URI path.
+	DBName string
+	// Collection holding the links. Defaults to "urls".
+	LinksCollName string
+	// Collection holding the sharded click counters. Defaults to "urlClickShards".
+	ShardsCollName string
+	// Collection holding click events. Defaults to "clicks".
+	ClicksCollName string
+	// Collection holding the daily unique visitor sketches. Defaults to "visitors".
+	VisitorsCollName string
+	// Collection holding the counts of requests for unknown codes. Defaults to "misses".
+	MissesCollName string
+	// Spread each link's click counter across this many documents to avoid write contention on hot links.
+	ClickShards int
+	// Install a $jsonSchema validator derived from shorty.Link on the links collection.
+	ValidateSchema bool
+
+	// Time allowed to establish a connection. Defaults to 10 seconds.
+	ConnectTimeout time.Duration
+	// Time allowed for the startup ping. Defaults to 10 seconds.
+	PingTimeout time.Duration
+	// Deadline applied to each store operation whose context doesn't have an earlier one. No deadline when 0.
+	OperationTimeout time.Duration
+
+	// Maximum number of connections in the pool. Uses the driver default (100) when 0.
+	MaxPoolSize uint64
+	// Minimum number of idle connections kept in the pool.
+	MinPoolSize uint64
+	// One of "primary", "primaryPreferred", "secondary", "secondaryPreferred", or "nearest". Defaults to "primary".
+	ReadPreference string
+	// "majority", or the number of nodes that must acknowledge a write. Uses the server default when empty.
+	WriteConcern string
+	// Receives an event for every command the driver runs, ex: to trace them. Optional.
+	Monitor *event.CommandMonitor
+}
+
+// Validate checks the options for values NewStore can't use.
+func (o StoreOpts) Validate() error {
+	var errs []error
+
+	u, err := url.Parse(o.URI)
+	switch {
+	case len(o.URI) == 0:
+		errs = append(errs, errors.New("URI required"))
+	case err != nil:
+		errs = append(errs, fmt.Errorf("URI: %v", err))
+	case u.Scheme != "mongodb" && u.Scheme != "mongodb+srv":
+		errs = append(errs, fmt.Errorf("URI: unsupported scheme %q", u.Scheme))
+	case len(o.DBName) == 0 && len(strings.TrimPrefix(u.Path, "/")) == 0:
+		errs = append(errs, errors.New("DBName required when the URI has no database"))
+	}
+
+	if o.ClickShards < 0 {
+		errs = append(errs, fmt.Errorf("ClickShards: must not be negative, got %d", o.ClickShards))
+	}
+	if o.ConnectTimeout < 0 || o.PingTimeout < 0 || o.OperationTimeout < 0 {
+		errs = append(errs, errors.New("timeouts must not be negative"))
+	}
+	if o.MaxPoolSize > 0 && o.MinPoolSize > o.MaxPoolSize {
+		errs = append(errs, fmt.Errorf("MinPoolSize (%d) must not exceed MaxPoolSize (%d)", o.MinPoolSize, o.MaxPoolSize))
+	}
+	if len(o.ReadPreference) > 0 {
+		if _, err := readpref.ModeFromString(o.ReadPreference); err != nil {
+			errs = append(errs, fmt.Errorf("ReadPreference: %v", err))
+		}
+	}
+	if _, err := o.writeConcern(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// DBName returns DBName, or the database in the URI path if DBName is empty.
+func (o StoreOpts) dbName() string {
+	if len(o.DBName) > 0 {
+		return o.DBName
+	}
+	u, err := url.Parse(o.URI)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+func (o StoreOpts) clientOptions() *options.ClientOptions {
+	co := options.Client().ApplyURI(o.URI)
+	if o.ConnectTimeout > 0 {
+		co.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.MaxPoolSize > 0 {
+		co.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		co.SetMinPoolSize(o.MinPoolSize)
+	}
+	if len(o.ReadPreference) > 0 {
+		// Validated in Validate
+		mode, _ := readpref.ModeFromString(o.ReadPreference)
+		rp, _ := readpref.New(mode)
+		co.SetReadPreference(rp)
+	}
+	if wc, _ := o.writeConcern(); wc != nil {
+		co.SetWriteConcern(wc)
+	}
+	if o.Monitor != nil {
+		co.SetMonitor(o.Monitor)
+	}
+	return co
+}
+
+func (o StoreOpts) writeConcern() (*writeconcern.WriteConcern, error) {
+	switch o.WriteConcern {
+	case "":
+		return nil, nil
+	case "majority":
+		return writeconcern.New(writeconcern.WMajority()), nil
+	}
+
+	n, err := strconv.Atoi(o.WriteConcern)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("WriteConcern: want \"majority\" or a number of nodes, got %q", o.WriteConcern)
+	}
+	return writeconcern.New(writeconcern.W(n)), nil
+}
+
+func withDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// OpContext applies the OperationTimeout to ctx.
+func (i *Store) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if i.OperationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, i.OperationTimeout)
+}
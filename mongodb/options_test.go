@@ -0,0 +1,78 @@
+package mongodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestStoreOptsValidate(t *testing.T) {
+	t.Run("accepts valid options", func(t *testing.T) {
+		tests := []StoreOpts{
+			{URI: "mongodb://localhost:27017/url-shortener"},
+			{URI: "mongodb+srv://cluster0.example.net", DBName: "url-shortener"},
+			{
+				URI:              "mongodb://localhost:27017/url-shortener",
+				OperationTimeout: 5 * time.Second,
+				MaxPoolSize:      20,
+				MinPoolSize:      2,
+				ReadPreference:   "secondaryPreferred",
+				WriteConcern:     "majority",
+			},
+			{URI: "mongodb://localhost:27017/url-shortener", WriteConcern: "1"},
+		}
+
+		for _, o := range tests {
+			if err := o.Validate(); err != nil {
+				t.Errorf("%+v: unexpected error: %v", o, err)
+			}
+		}
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opts StoreOpts
+		}{
+			{"missing URI", StoreOpts{}},
+			{"wrong scheme", StoreOpts{URI: "http://localhost:27017/url-shortener"}},
+			{"no database", StoreOpts{URI: "mongodb://localhost:27017"}},
+			{"negative timeout", StoreOpts{URI: "mongodb://localhost/db", OperationTimeout: -time.Second}},
+			{"min pool above max", StoreOpts{URI: "mongodb://localhost/db", MaxPoolSize: 1, MinPoolSize: 2}},
+			{"unknown read preference", StoreOpts{URI: "mongodb://localhost/db", ReadPreference: "closest"}},
+			{"bad write concern", StoreOpts{URI: "mongodb://localhost/db", WriteConcern: "all"}},
+		}
+
+		for _, c := range tests {
+			if err := c.opts.Validate(); err == nil {
+				t.Errorf("%s: want error", c.name)
+			}
+		}
+	})
+
+	t.Run("prefers DBName over the URI path", func(t *testing.T) {
+		o := StoreOpts{URI: "mongodb://localhost:27017/from-uri", DBName: "from-opts"}
+		testutil.AssertEqual(t, o.dbName(), "from-opts")
+
+		o.DBName = ""
+		testutil.AssertEqual(t, o.dbName(), "from-uri")
+	})
+}
+
+func TestOptsFromEnv(t *testing.T) {
+	t.Run("reads collection names and options", func(t *testing.T) {
+		t.Setenv("MONGO_URI", "mongodb://localhost:27017/shorty")
+		t.Setenv("MONGO_SHARDS_COLLECTION", "clickShards")
+		t.Setenv("MONGO_SCHEMA_VALIDATION", "true")
+		t.Setenv("MONGO_CLICK_SHARDS", "8")
+
+		o, err := OptsFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, o.ShardsCollName, "clickShards")
+		testutil.AssertEqual(t, o.ValidateSchema, true)
+		testutil.AssertEqual(t, o.ClickShards, 8)
+	})
+}
@@ -0,0 +1,312 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/operationspark/shorty/shorty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Clicks on a viral link all $inc the same document, which serializes the writes.
+// With sharded counters, clicks are spread across ClickShards documents per link in a side collection:
+//
+//	{ "code": "abc123", "shard": 3, "count": 42, "human": 40, "bot": 2 }
+//
+// "count" is added to the link's "totalClicks", so like it, it only counts people.
+// Shards created before bots were counted separately only have "count".
+//
+// The link's own "totalClicks" field is kept as a base count, so existing totals need no migration
+// when sharding is turned on. Reads add the shard counts to it. Before turning sharding off again,
+// run FoldClickShards to move the shard counts back into "totalClicks". It records each fold on the shard,
+// as "folding", and on the link, in "foldedShards", so a run that's interrupted can be re-run.
+
+const defaultShardsCollName = "urlClickShards"
+
+type (
+	clickShard struct {
+		ID    interface{} `bson:"_id"`
+		Code  string      `bson:"code"`
+		Shard int         `bson:"shard"`
+		Count int         `bson:"count"`
+		Human int         `bson:"human"`
+		Bot   int         `bson:"bot"`
+		// Counts taken from the shard that may not have been added to the link yet.
+		Folding *shardFold `bson:"folding,omitempty"`
+	}
+
+	shardFold struct {
+		ID    primitive.ObjectID `bson:"id"`
+		Count int                `bson:"count"`
+		Human int                `bson:"human"`
+		Bot   int                `bson:"bot"`
+	}
+)
+
+func (i *Store) sharded() bool {
+	return i.ClickShards > 1
+}
+
+func (i *Store) shardsColl() *mongo.Collection {
+	name := i.ShardsCollName
+	if len(name) == 0 {
+		name = defaultShardsCollName
+	}
+	return i.Client.Database(i.DBName).Collection(name)
+}
+
+// EnsureShardIndexes creates the index used to look up and upsert a link's shards.
+func (i *Store) EnsureShardIndexes(ctx context.Context) error {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+	_, err := i.shardsColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"code", 1}, {"shard", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("createIndex: %v", err)
+	}
+	return nil
+}
+
+// LinkExists checks for a link without decoding it or reading its shards.
+func (i *Store) linkExists(ctx context.Context, code string) (bool, error) {
+	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
+	n, err := coll.CountDocuments(ctx, bson.D{{"code", code}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("countDocuments: %v", err)
+	}
+	return n > 0, nil
+}
+
+// IncrementShard adds counts to a random shard of the link with the given code.
+func (i *Store) incrementShard(ctx context.Context, code string, counts shorty.ClickCounts) error {
+	_, err := i.shardsColl().UpdateOne(
+		ctx,
+		bson.D{{"code", code}, {"shard", rand.Intn(i.ClickShards)}},
+		bson.D{{"$inc", shardIncrements(counts)}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("updateOne: %v", err)
+	}
+	return nil
+}
+
+// IncrementShardsBatch adds each count to a random shard of the matching link.
+// Codes without a link are dropped so shards aren't created for deleted links.
+func (i *Store) incrementShardsBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	codes := make([]string, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+
+	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
+	cur, err := coll.Find(
+		ctx,
+		bson.D{{"code", bson.D{{"$in", codes}}}},
+		options.Find().SetProjection(bson.D{{"code", 1}}),
+	)
+	if err != nil {
+		return fmt.Errorf("find: %v", err)
+	}
+	var existing []shorty.Link
+	if err := cur.All(ctx, &existing); err != nil {
+		return fmt.Errorf("all: %v", err)
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(existing))
+	for _, l := range existing {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"code", l.Code}, {"shard", rand.Intn(i.ClickShards)}}).
+			SetUpdate(bson.D{{"$inc", shardIncrements(counts[l.Code])}}).
+			SetUpsert(true),
+		)
+	}
+	_, err = i.shardsColl().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("bulkWrite: %v", err)
+	}
+	return nil
+}
+
+// FindShardedLinks returns the links matching filter with their shard counts added to their click fields.
+func (i *Store) findShardedLinks(ctx context.Context, filter bson.D) (shorty.Links, error) {
+	coll := i.Client.Database(i.DBName).Collection(i.LinksCollName)
+	cur, err := coll.Aggregate(ctx, i.shardedLinksPipeline(filter, nil))
+	if err != nil {
+		return shorty.Links{}, fmt.Errorf("aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	links := shorty.Links{}
+	if err := cur.All(ctx, &links); err != nil {
+		return shorty.Links{}, fmt.Errorf("all: %v", err)
+	}
+	return links, nil
+}
+
+// ShardedLinksPipeline matches links with filter and adds their shard counts to their click fields.
+// Links are sorted by sort, when given, before the lookup, so the sort can use an index on the links.
+func (i *Store) shardedLinksPipeline(filter, sort bson.D) mongo.Pipeline {
+	pipeline := mongo.Pipeline{{{"$match", filter}}}
+	if len(sort) > 0 {
+		pipeline = append(pipeline, bson.D{{"$sort", sort}})
+	}
+	return append(pipeline,
+		bson.D{{"$lookup", bson.D{
+			{"from", i.shardsColl().Name()},
+			{"localField", "code"},
+			{"foreignField", "code"},
+			{"as", "clickShards"},
+		}}},
+		bson.D{{"$addFields", bson.D{
+			{"totalClicks", sumShards("totalClicks", "count")},
+			{"humanClicks", sumShards("humanClicks", "human")},
+			{"botClicks", sumShards("botClicks", "bot")},
+		}}},
+		bson.D{{"$project", bson.D{{"clickShards", 0}}}},
+	)
+}
+
+// FoldClickShards moves the shard counts back into each link's click fields.
+// Run it before lowering ClickShards to 1 (or less) so no clicks are lost.
+// Shards are decremented rather than deleted, so clicks recorded while it runs are kept.
+// Each shard's counts are first moved into a pending fold on the shard, then added to the link, which records the fold.
+// If a run is interrupted, the pending counts are missing from reads until it's run again, which finishes the folds
+// without counting any of them twice. OperationTimeout isn't applied, since there can be a shard per link; use ctx to bound it.
+// It returns the number of clicks folded, bots included.
+func (i *Store) FoldClickShards(ctx context.Context) (int, error) {
+	cur, err := i.shardsColl().Find(ctx, bson.D{{"$or", bson.A{
+		bson.D{{"count", bson.D{{"$ne", 0}}}},
+		bson.D{{"bot", bson.D{{"$nin", bson.A{0, nil}}}}},
+		bson.D{{"folding", bson.D{{"$ne", nil}}}},
+	}}})
+	if err != nil {
+		return 0, fmt.Errorf("find: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	folded := 0
+	for cur.Next(ctx) {
+		var shard clickShard
+		if err := cur.Decode(&shard); err != nil {
+			return folded, fmt.Errorf("decode: %v", err)
+		}
+
+		// Finish the fold an earlier run left pending
+		if shard.Folding != nil {
+			n, err := i.applyFold(ctx, shard, *shard.Folding)
+			if err != nil {
+				return folded, err
+			}
+			folded += n
+		}
+		if shard.Count == 0 && shard.Human == 0 && shard.Bot == 0 {
+			continue
+		}
+
+		fold := shardFold{ID: primitive.NewObjectID(), Count: shard.Count, Human: shard.Human, Bot: shard.Bot}
+		res, err := i.shardsColl().UpdateOne(ctx,
+			bson.D{{"_id", shard.ID}, {"folding", nil}},
+			bson.D{
+				{"$inc", bson.D{{"count", -fold.Count}, {"human", -fold.Human}, {"bot", -fold.Bot}}},
+				{"$set", bson.D{{"folding", fold}}},
+			},
+		)
+		if err != nil {
+			return folded, fmt.Errorf("updateOne: %v", err)
+		}
+		// Another run took the shard's counts
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		n, err := i.applyFold(ctx, shard, fold)
+		if err != nil {
+			return folded, err
+		}
+		folded += n
+	}
+	if err := cur.Err(); err != nil {
+		return folded, fmt.Errorf("cursor: %v", err)
+	}
+
+	// Drop the emptied shards
+	emptied := bson.D{
+		{"count", 0},
+		{"human", bson.D{{"$in", bson.A{0, nil}}}},
+		{"bot", bson.D{{"$in", bson.A{0, nil}}}},
+		{"folding", nil},
+	}
+	if _, err := i.shardsColl().DeleteMany(ctx, emptied); err != nil {
+		return folded, fmt.Errorf("deleteMany: %v", err)
+	}
+	return folded, nil
+}
+
+// ApplyFold adds a fold taken from shard to its link, unless the link already has it, then clears it from the shard.
+// It returns the number of clicks added.
+func (i *Store) applyFold(ctx context.Context, shard clickShard, fold shardFold) (int, error) {
+	links := i.Client.Database(i.DBName).Collection(i.LinksCollName)
+	foldedField := fmt.Sprintf("foldedShards.%d", shard.Shard)
+	res, err := links.UpdateOne(ctx,
+		bson.D{{"code", shard.Code}, {foldedField, bson.D{{"$ne", fold.ID}}}},
+		bson.D{
+			{"$inc", bson.D{{"totalClicks", fold.Count}, {"humanClicks", fold.Human}, {"botClicks", fold.Bot}}},
+			{"$set", bson.D{{foldedField, fold.ID}}},
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("updateOne: %v", err)
+	}
+	_, err = i.shardsColl().UpdateOne(ctx,
+		bson.D{{"_id", shard.ID}, {"folding.id", fold.ID}},
+		bson.D{{"$unset", bson.D{{"folding", ""}}}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("updateOne: %v", err)
+	}
+	if res.ModifiedCount == 0 {
+		return 0, nil
+	}
+	return fold.Count + fold.Bot, nil
+}
+
+// RenameShards moves a link's shards to its new code.
+func (i *Store) renameShards(ctx context.Context, code, newCode string) error {
+	_, err := i.shardsColl().UpdateMany(ctx,
+		bson.D{{"code", code}},
+		bson.D{{"$set", bson.D{{"code", newCode}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("updateMany: %v", err)
+	}
+	return nil
+}
+
+func (i *Store) deleteShards(ctx context.Context, code string) error {
+	if _, err := i.shardsColl().DeleteMany(ctx, bson.D{{"code", code}}); err != nil {
+		return fmt.Errorf("deleteMany: %v", err)
+	}
+	return nil
+}
+
+// ShardIncrements is the $inc document that adds counts to a shard.
+func shardIncrements(counts shorty.ClickCounts) bson.D {
+	return bson.D{{"count", counts.Human}, {"human", counts.Human}, {"bot", counts.Bot}}
+}
+
+// SumShards adds the shards' shardField to the link's linkField, either of which may be missing.
+func sumShards(linkField, shardField string) bson.D {
+	return bson.D{{"$add", bson.A{
+		bson.D{{"$ifNull", bson.A{"$" + linkField, 0}}},
+		bson.D{{"$sum", "$clickShards." + shardField}},
+	}}}
+}
@@ -0,0 +1,95 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/operationspark/shorty/shorty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Most candidates fetched for one FindSimilarCodes lookup
+const maxSimilarCandidates = 200
+
+// LinkDoc is a link as stored, with the keys FindSimilarCodes looks similar codes up by.
+// The keys are kept on the link document, so they're written atomically with the code.
+type linkDoc struct {
+	shorty.Link `bson:",inline"`
+	// shorty.SimilarityKeys of the code, in a multikey index.
+	SimilarityKeys []string `bson:"similarityKeys"`
+}
+
+func newLinkDoc(link shorty.Link) linkDoc {
+	return linkDoc{Link: link, SimilarityKeys: shorty.SimilarityKeys(link.Code, shorty.MaxSimilarDistance)}
+}
+
+// FindSimilarCodes looks up the public links that share a similarity key with code, then ranks them.
+func (i *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	cur, err := linksColl(i).Find(ctx,
+		bson.D{
+			{"similarityKeys", bson.D{{"$in", shorty.SimilarityKeys(code, maxDistance)}}},
+			{"private", bson.D{{"$ne", true}}},
+		},
+		options.Find().SetProjection(bson.D{{"code", 1}}).SetLimit(maxSimilarCandidates),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("find: %v", err)
+	}
+	var links []shorty.Link
+	if err := cur.All(ctx, &links); err != nil {
+		return nil, fmt.Errorf("all: %v", err)
+	}
+
+	candidates := make([]string, len(links))
+	for n, l := range links {
+		candidates[n] = l.Code
+	}
+	return shorty.RankSimilarCodes(code, candidates, maxDistance), nil
+}
+
+// BackfillSimilarityKeys sets the similarity keys of links saved before they were indexed.
+func backfillSimilarityKeys(ctx context.Context, s *Store) error {
+	cur, err := linksColl(s).Find(ctx,
+		bson.D{{"similarityKeys", bson.D{{"$exists", false}}}},
+		options.Find().SetProjection(bson.D{{"code", 1}}),
+	)
+	if err != nil {
+		return fmt.Errorf("find: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		if _, err := linksColl(s).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("bulkWrite: %v", err)
+		}
+		models = models[:0]
+		return nil
+	}
+	for cur.Next(ctx) {
+		var link shorty.Link
+		if err := cur.Decode(&link); err != nil {
+			return fmt.Errorf("decode: %v", err)
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"_id", cur.Current.Lookup("_id")}}).
+			SetUpdate(bson.D{{"$set", bson.D{{"similarityKeys", newLinkDoc(link).SimilarityKeys}}}}))
+		if len(models) == 500 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("cursor: %v", err)
+	}
+	return flush()
+}
@@ -0,0 +1,132 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/operationspark/shorty/shorty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error codes
+const (
+	codeNamespaceNotFound       = 26
+	codeDocumentValidationError = 121
+)
+
+// Fields every version of the service writes. Fields added to shorty.Link later are checked when
+// present but not required, so a version from before they existed can keep writing during a deploy.
+var requiredLinkFields = map[string]bool{
+	"shortUrl":    true,
+	"code":        true,
+	"customCode":  true,
+	"originalUrl": true,
+	"totalClicks": true,
+	"createdBy":   true,
+	"createdAt":   true,
+	"updatedAt":   true,
+}
+
+// LinkSchema derives a $jsonSchema document from the bson fields of shorty.Link. "code" must not be empty.
+// NewStore installs it on startup when StoreOpts.ValidateSchema is set, so it follows shorty.Link as fields are added.
+func LinkSchema() bson.D {
+	t := reflect.TypeOf(shorty.Link{})
+	required := bson.A{}
+	properties := bson.D{}
+
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		name := strings.Split(f.Tag.Get("bson"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		property := bson.D{{"bsonType", bsonType(f.Type)}}
+		if name == "code" {
+			property = append(property, bson.E{"minLength", 1})
+		}
+		if requiredLinkFields[name] {
+			required = append(required, name)
+		}
+		properties = append(properties, bson.E{name, property})
+	}
+
+	return bson.D{
+		{"bsonType", "object"},
+		{"required", required},
+		{"properties", properties},
+	}
+}
+
+func bsonType(t reflect.Type) interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "date"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		// The driver writes Go ints as int32 when they fit and int64 otherwise
+		return bson.A{"int", "long"}
+	case reflect.Float32, reflect.Float64:
+		return "double"
+	case reflect.Slice:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// InstallValidator sets LinkSchema as the $jsonSchema validator on the links collection, creating the collection if needed.
+// The "moderate" validation level is used, so existing documents that don't match are left alone until they are fixed.
+func (i *Store) InstallValidator(ctx context.Context) error {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	db := i.Client.Database(i.DBName)
+	validator := bson.D{{"$jsonSchema", LinkSchema()}}
+
+	err := db.RunCommand(ctx, bson.D{
+		{"collMod", i.LinksCollName},
+		{"validator", validator},
+		{"validationLevel", "moderate"},
+		{"validationAction", "error"},
+	}).Err()
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(codeNamespaceNotFound) {
+		err = db.CreateCollection(ctx, i.LinksCollName, options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel("moderate").
+			SetValidationAction("error"),
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("install validator: %v", err)
+	}
+	return nil
+}
+
+// ValidationError converts a document validation failure into a *shorty.ValidationError.
+// It returns nil for any other error.
+func validationError(err error) error {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) || !serverErr.HasErrorCode(codeDocumentValidationError) {
+		return nil
+	}
+
+	details := "document failed validation"
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) && len(writeErr.WriteErrors) > 0 {
+		details = writeErr.WriteErrors[0].Error()
+	}
+	return &shorty.ValidationError{Details: details}
+}
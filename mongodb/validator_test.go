@@ -0,0 +1,51 @@
+package mongodb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestLinkSchema(t *testing.T) {
+	t.Run("derives properties from the Link bson tags", func(t *testing.T) {
+		schema := LinkSchema().Map()
+		properties := schema["properties"].(bson.D).Map()
+
+		testutil.AssertEqual(t, len(schema["required"].(bson.A)), len(requiredLinkFields))
+		testutil.AssertEqual(t, properties["private"].(bson.D).Map()["bsonType"], "bool")
+		testutil.AssertEqual(t, properties["originalUrl"].(bson.D).Map()["bsonType"], "string")
+		testutil.AssertEqual(t, properties["createdAt"].(bson.D).Map()["bsonType"], "date")
+		testutil.AssertEqual(t, properties["code"].(bson.D).Map()["minLength"], 1)
+	})
+
+	t.Run("doesn't require fields added after the validator", func(t *testing.T) {
+		for _, f := range LinkSchema().Map()["required"].(bson.A) {
+			switch f {
+			case "private", "humanClicks", "botClicks":
+				t.Errorf("%s is required", f)
+			}
+		}
+	})
+}
+
+func TestValidationError(t *testing.T) {
+	t.Run("maps document validation failures to a ValidationError", func(t *testing.T) {
+		err := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121, Message: "Document failed validation"}}}
+
+		got := validationError(err)
+		var vErr *shorty.ValidationError
+		if !errors.As(got, &vErr) {
+			t.Fatalf("want *shorty.ValidationError, got %v", got)
+		}
+		testutil.AssertEqual(t, errors.Is(got, shorty.ErrInvalidLink), true)
+	})
+
+	t.Run("ignores other errors", func(t *testing.T) {
+		err := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+		testutil.AssertEqual(t, validationError(err), nil)
+	})
+}
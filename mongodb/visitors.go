@@ -0,0 +1,118 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/operationspark/shorty/hll"
+	"github.com/operationspark/shorty/shorty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultVisitorsCollName = "visitors"
+
+// Each link has one visitor sketch per UTC day. Only the registers that are set are stored, keyed by index,
+// so a link with a handful of visitors has a handful of fields:
+//
+//	{ "code": "abc123", "day": ISODate("2023-10-18"), "registers": { "97": 2, "3120": 1 } }
+//
+// Registers are only ever raised with $max, so writes from any instance, in any order, merge.
+type visitorSketch struct {
+	Registers map[string]uint8 `bson:"registers"`
+}
+
+type visitorDay struct {
+	code string
+	day  time.Time
+}
+
+func visitorsColl(s *Store) *mongo.Collection {
+	name := s.VisitorsCollName
+	if len(name) == 0 {
+		name = defaultVisitorsCollName
+	}
+	return s.Client.Database(s.DBName).Collection(name)
+}
+
+// SaveVisitors adds the visitors of clicks to their link's sketch for the day, in a single bulk write.
+func (i *Store) saveVisitors(ctx context.Context, clicks []shorty.Click) error {
+	// $max can't name the same register twice, so keep the highest rank of each first
+	sketches := map[visitorDay]map[int]uint8{}
+	for _, c := range clicks {
+		key := c.VisitorKey()
+		if len(key) == 0 {
+			continue
+		}
+		vd := visitorDay{c.Code, shorty.TruncateInterval(c.At, shorty.IntervalDay)}
+		if sketches[vd] == nil {
+			sketches[vd] = map[int]uint8{}
+		}
+		idx, rank := hll.Position(key)
+		sketches[vd][idx] = max(sketches[vd][idx], rank)
+	}
+	if len(sketches) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(sketches))
+	for vd, registers := range sketches {
+		update := bson.D{}
+		for idx, rank := range registers {
+			update = append(update, bson.E{"registers." + strconv.Itoa(idx), int32(rank)})
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"code", vd.code}, {"day", vd.day}}).
+			SetUpdate(bson.D{{"$max", update}}).
+			SetUpsert(true))
+	}
+	if _, err := visitorsColl(i).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("bulkWrite: %v", err)
+	}
+	return nil
+}
+
+// UniqueVisitors merges the visitor sketches of code's days in range.
+func (i *Store) UniqueVisitors(ctx context.Context, code string, from, to time.Time) (int, error) {
+	ctx, cancel := i.opContext(ctx)
+	defer cancel()
+
+	days := bson.D{{"$gte", shorty.TruncateInterval(from, shorty.IntervalDay)}}
+	if !to.IsZero() {
+		days = append(days, bson.E{"$lt", to})
+	}
+	cur, err := visitorsColl(i).Find(ctx,
+		bson.D{{"code", code}, {"day", days}},
+		options.Find().SetProjection(bson.D{{"registers", 1}}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("find: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	merged := hll.New()
+	for cur.Next(ctx) {
+		var sketch visitorSketch
+		if err := cur.Decode(&sketch); err != nil {
+			return 0, fmt.Errorf("decode: %v", err)
+		}
+		registers := make(map[int]uint8, len(sketch.Registers))
+		for k, rank := range sketch.Registers {
+			idx, err := strconv.Atoi(k)
+			if err != nil {
+				return 0, fmt.Errorf("register %q: %v", k, err)
+			}
+			registers[idx] = rank
+		}
+		if err := merged.SetRegisters(registers); err != nil {
+			return 0, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return 0, fmt.Errorf("cursor: %v", err)
+	}
+	return merged.Estimate(), nil
+}
@@ -0,0 +1,133 @@
+package resilient
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/gcp"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open rejects calls until the open timeout passes.
+	Open
+	// HalfOpen lets a single trial call through to decide whether to close or reopen.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker opens after a number of consecutive failures, and closes again once a trial call succeeds.
+type breaker struct {
+	threshold   int
+	openTimeout time.Duration
+
+	lock     sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// Set while the half-open trial call is in flight
+	probing bool
+
+	// Overridden in tests
+	now func() time.Time
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be followed by a call to done or cancel.
+func (b *breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Done records the outcome of an allowed call.
+func (b *breaker) done(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == HalfOpen {
+		b.probing = false
+		if failed {
+			b.openedAt = b.now()
+			b.setState(Open)
+			return
+		}
+		b.failures = 0
+		b.setState(Closed)
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == Closed && b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(Open)
+	}
+}
+
+// Cancel releases an allowed call whose caller gave up before it finished.
+// It counts as neither a success nor a failure, so a half-open breaker lets the next call probe instead.
+func (b *breaker) cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// SetState changes the state and logs the transition. The lock must be held.
+func (b *breaker) setState(s State) {
+	if s == b.state {
+		return
+	}
+	severity := "INFO"
+	if s == Open {
+		severity = "WARNING"
+	}
+	log.Println(gcp.LogEntry{
+		Severity:  severity,
+		Message:   "store circuit breaker " + b.state.String() + " -> " + s.String(),
+		Component: "store-breaker",
+	})
+	b.state = s
+}
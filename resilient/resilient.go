@@ -0,0 +1,270 @@
+// Package resilient wraps a LinkStore with retries and a circuit breaker so brief database outages
+// don't turn every redirect into an error page.
+package resilient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/operationspark/shorty/cache"
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+)
+
+// ErrCircuitOpen is returned without calling the underlying store while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("store circuit breaker open")
+
+type (
+	// Store retries idempotent reads with jittered exponential backoff and stops calling the
+	// underlying store after repeated failures. While the store is failing, FindLink answers
+	// with the last link it found for the code, if it has one.
+	Store struct {
+		next        handlers.LinkStore
+		maxAttempts int
+		baseDelay   time.Duration
+		maxDelay    time.Duration
+		breaker     *breaker
+
+		staleLock sync.Mutex
+		stale     *cache.LRU[string, shorty.Link]
+
+		// Overridden in tests
+		sleep func(ctx context.Context, d time.Duration) error
+	}
+
+	Opts struct {
+		// Attempts made for each read, including the first. Defaults to 3.
+		MaxAttempts int
+		// Delay before the first retry. Each retry waits a random duration up to double the previous limit. Defaults to 50ms.
+		BaseDelay time.Duration
+		// Upper limit for the delay between retries. Defaults to 1 second.
+		MaxDelay time.Duration
+		// Consecutive failed calls that open the circuit breaker. Defaults to 5.
+		FailureThreshold int
+		// How long the breaker stays open before letting a trial call through. Defaults to 30 seconds.
+		OpenTimeout time.Duration
+		// Number of links kept to answer FindLink while the store is failing. Defaults to 1000.
+		StaleSize int
+	}
+)
+
+// NewStore wraps a LinkStore with retries, a circuit breaker, and a stale FindLink fallback.
+func NewStore(next handlers.LinkStore, o Opts) *Store {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = 50 * time.Millisecond
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = time.Second
+	}
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = 30 * time.Second
+	}
+	if o.StaleSize <= 0 {
+		o.StaleSize = 1000
+	}
+
+	return &Store{
+		next:        next,
+		maxAttempts: o.MaxAttempts,
+		baseDelay:   o.BaseDelay,
+		maxDelay:    o.MaxDelay,
+		breaker: &breaker{
+			threshold:   o.FailureThreshold,
+			openTimeout: o.OpenTimeout,
+			now:         time.Now,
+		},
+		stale: cache.NewLRU[string, shorty.Link](o.StaleSize),
+		sleep: sleep,
+	}
+}
+
+// State returns the circuit breaker's current state.
+func (s *Store) State() State {
+	return s.breaker.State()
+}
+
+// FindLink retries transient failures. If the store is still failing, or the breaker is open,
+// the last link found for the code is returned instead of the error.
+func (s *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	link, err := call(ctx, s, true, func(ctx context.Context) (shorty.Link, error) {
+		return s.next.FindLink(ctx, code)
+	})
+
+	s.staleLock.Lock()
+	defer s.staleLock.Unlock()
+	switch {
+	case err == nil:
+		s.stale.Add(code, link)
+	case errors.Is(err, shorty.ErrLinkNotFound):
+		s.stale.Remove(code)
+	case transient(ctx, err):
+		if stale, ok := s.stale.Get(code); ok {
+			return stale, nil
+		}
+	}
+	return link, err
+}
+
+func (s *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
+	return call(ctx, s, true, s.next.FindAllLinks)
+}
+
+func (s *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
+	return call(ctx, s, true, func(ctx context.Context) (bool, error) {
+		return s.next.CheckCodeInUse(ctx, code)
+	})
+}
+
+func (s *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	return call(ctx, s, true, func(ctx context.Context) ([]string, error) {
+		return s.next.FindSimilarCodes(ctx, code, maxDistance)
+	})
+}
+
+// EachLink isn't retried, since a retry would repeat links already passed to fn.
+// Errors returned by fn don't count as store failures.
+func (s *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	var fnErr error
+	_, err := call(ctx, s, false, func(ctx context.Context) (struct{}, error) {
+		err := s.next.EachLink(ctx, func(l shorty.Link) error {
+			fnErr = fn(l)
+			return fnErr
+		})
+		if fnErr != nil {
+			return struct{}{}, nil
+		}
+		return struct{}{}, err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// SaveLink isn't retried, since a write that succeeded before the error would make the retry fail with ErrCodeInUse.
+func (s *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	return call(ctx, s, false, func(ctx context.Context) (shorty.Link, error) {
+		return s.next.SaveLink(ctx, newLink)
+	})
+}
+
+func (s *Store) UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	updated, err := call(ctx, s, false, func(ctx context.Context) (shorty.Link, error) {
+		return s.next.UpdateLink(ctx, code, toUpdate, opts)
+	})
+	if err == nil {
+		s.forget(code)
+		s.forget(toUpdate.CustomCode)
+	}
+	return updated, err
+}
+
+func (s *Store) DeleteLink(ctx context.Context, code string) (int, error) {
+	n, err := call(ctx, s, false, func(ctx context.Context) (int, error) {
+		return s.next.DeleteLink(ctx, code)
+	})
+	if err == nil {
+		s.forget(code)
+	}
+	return n, err
+}
+
+func (s *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
+	return call(ctx, s, false, func(ctx context.Context) (int, error) {
+		return s.next.IncrementTotalClicks(ctx, code)
+	})
+}
+
+func (s *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	_, err := call(ctx, s, false, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, s.next.IncrementTotalClicksBatch(ctx, counts)
+	})
+	return err
+}
+
+// Ping bypasses the breaker so readiness checks report the store's actual state.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// Forget drops the stale copy of a link that was changed or deleted.
+func (s *Store) forget(code string) {
+	s.staleLock.Lock()
+	defer s.staleLock.Unlock()
+	s.stale.Remove(code)
+}
+
+// Call runs op through the circuit breaker, retrying transient failures when retry is set.
+func call[T any](ctx context.Context, s *Store, retry bool, op func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if !s.breaker.allow() {
+		return zero, ErrCircuitOpen
+	}
+
+	attempts := 1
+	if retry {
+		attempts = s.maxAttempts
+	}
+
+	var res T
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if sleepErr := s.sleep(ctx, s.backoff(attempt)); sleepErr != nil {
+				break
+			}
+		}
+		res, err = op(ctx)
+		if !transient(ctx, err) {
+			break
+		}
+	}
+
+	// A call the caller gave up on says nothing about the store's health
+	if err != nil && ctx.Err() != nil {
+		s.breaker.cancel()
+		return res, err
+	}
+	s.breaker.done(transient(ctx, err))
+	return res, err
+}
+
+// Backoff returns a random delay up to baseDelay * 2^(attempt-1), capped at maxDelay.
+func (s *Store) backoff(attempt int) time.Duration {
+	limit := s.baseDelay << (attempt - 1)
+	if limit <= 0 || limit > s.maxDelay {
+		limit = s.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// Transient reports whether err is a store failure worth retrying, rather than an expected
+// result like an unknown code, or the caller giving up.
+func transient(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, shorty.ErrLinkNotFound) &&
+		!errors.Is(err, shorty.ErrCodeInUse) &&
+		!errors.Is(err, shorty.ErrInvalidLink)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
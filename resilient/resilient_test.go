@@ -0,0 +1,205 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
+)
+
+var errUnavailable = errors.New("server selection timeout")
+
+// FlakyStore fails the next `failures` FindLink and SaveLink calls, and counts the calls that reach it.
+type flakyStore struct {
+	*inmem.Store
+	failures int
+	calls    int
+}
+
+func (f *flakyStore) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return shorty.Link{}, errUnavailable
+	}
+	return f.Store.FindLink(ctx, code)
+}
+
+func (f *flakyStore) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return shorty.Link{}, errUnavailable
+	}
+	return f.Store.SaveLink(ctx, newLink)
+}
+
+func newTestStore(t *testing.T) (*Store, *flakyStore) {
+	t.Helper()
+	backing := inmem.NewStore()
+	backing.Store = map[string]shorty.Link{
+		"abc123": {Code: "abc123", OriginalUrl: "https://operationspark.org"},
+	}
+	flaky := &flakyStore{Store: backing}
+	store := NewStore(flaky, Opts{MaxAttempts: 3, FailureThreshold: 2, OpenTimeout: time.Minute})
+	store.sleep = func(context.Context, time.Duration) error { return nil }
+	return store, flaky
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries transient read failures", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		flaky.failures = 2
+
+		link, err := store.FindLink(ctx, "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, link.OriginalUrl, "https://operationspark.org")
+		testutil.AssertEqual(t, flaky.calls, 3)
+	})
+
+	t.Run("doesn't retry unknown codes", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+
+		_, err := store.FindLink(ctx, "nope")
+		testutil.AssertEqual(t, err, shorty.ErrLinkNotFound)
+		testutil.AssertEqual(t, flaky.calls, 1)
+	})
+
+	t.Run("doesn't retry writes", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		flaky.failures = 1
+
+		_, err := store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		testutil.AssertEqual(t, err, errUnavailable)
+		testutil.AssertEqual(t, flaky.calls, 1)
+	})
+
+	t.Run("keeps backoff within the limits", func(t *testing.T) {
+		store := NewStore(inmem.NewStore(), Opts{BaseDelay: 10 * time.Millisecond, MaxDelay: 30 * time.Millisecond})
+		for attempt := 1; attempt < 10; attempt++ {
+			if d := store.backoff(attempt); d < 0 || d > 30*time.Millisecond {
+				t.Fatalf("attempt %d: backoff %v out of range", attempt, d)
+			}
+		}
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("opens after repeated failures and fails fast", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		flaky.failures = 100
+
+		for i := 0; i < 2; i++ {
+			_, err := store.SaveLink(ctx, shorty.Link{Code: "def456"})
+			testutil.AssertEqual(t, err, errUnavailable)
+		}
+		testutil.AssertEqual(t, store.State(), Open)
+
+		calls := flaky.calls
+		_, err := store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		testutil.AssertEqual(t, err, ErrCircuitOpen)
+		testutil.AssertEqual(t, flaky.calls, calls)
+	})
+
+	t.Run("serves stale links while open", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		if _, err := store.FindLink(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+
+		flaky.failures = 100
+		for i := 0; i < 2; i++ {
+			link, err := store.FindLink(ctx, "abc123")
+			if err != nil {
+				t.Fatalf("want stale link, got %v", err)
+			}
+			testutil.AssertEqual(t, link.OriginalUrl, "https://operationspark.org")
+		}
+		testutil.AssertEqual(t, store.State(), Open)
+
+		link, err := store.FindLink(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("want stale link, got %v", err)
+		}
+		testutil.AssertEqual(t, link.OriginalUrl, "https://operationspark.org")
+
+		// Nothing to fall back on for codes that were never found
+		_, err = store.FindLink(ctx, "def456")
+		testutil.AssertEqual(t, err, ErrCircuitOpen)
+	})
+
+	t.Run("closes once a trial call succeeds", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		now := time.Now()
+		store.breaker.now = func() time.Time { return now }
+
+		flaky.failures = 6
+		store.FindLink(ctx, "abc123")
+		store.FindLink(ctx, "abc123")
+		testutil.AssertEqual(t, store.State(), Open)
+
+		now = now.Add(2 * time.Minute)
+		if _, err := store.FindLink(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, store.State(), Closed)
+	})
+
+	t.Run("reopens when the trial call fails", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		now := time.Now()
+		store.breaker.now = func() time.Time { return now }
+
+		flaky.failures = 100
+		store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		testutil.AssertEqual(t, store.State(), Open)
+
+		now = now.Add(2 * time.Minute)
+		_, err := store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		testutil.AssertEqual(t, err, errUnavailable)
+		testutil.AssertEqual(t, store.State(), Open)
+	})
+
+	t.Run("lets another call probe when the trial call's caller gives up", func(t *testing.T) {
+		store, flaky := newTestStore(t)
+		now := time.Now()
+		store.breaker.now = func() time.Time { return now }
+
+		flaky.failures = 2
+		store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		store.SaveLink(ctx, shorty.Link{Code: "def456"})
+		testutil.AssertEqual(t, store.State(), Open)
+
+		now = now.Add(2 * time.Minute)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		flaky.failures = 1
+		_, err := store.SaveLink(cancelled, shorty.Link{Code: "def456"})
+		testutil.AssertEqual(t, err, errUnavailable)
+		testutil.AssertEqual(t, store.State(), HalfOpen)
+
+		if _, err := store.SaveLink(ctx, shorty.Link{Code: "def456"}); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertEqual(t, store.State(), Closed)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+		return NewStore(inmem.NewStore(), Opts{})
+	})
+}
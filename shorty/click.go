@@ -0,0 +1,48 @@
+package shorty
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type (
+	// Click is one redirect through a short link.
+	Click struct {
+		// Code of the link that was clicked.
+		Code string `json:"code" bson:"code"`
+		// DateTime of the redirect.
+		At time.Time `json:"at" bson:"at"`
+		// Page the click came from, without its query string. Empty when the browser didn't send one.
+		Referrer string `json:"referrer,omitempty" bson:"referrer,omitempty"`
+		// Parsed User-Agent header.
+		UserAgent UserAgent `json:"userAgent" bson:"userAgent"`
+		// Salted hash of the client IP, so unique visitors can be counted without storing addresses.
+		IPHash string `json:"ipHash,omitempty" bson:"ipHash,omitempty"`
+		// ISO 3166 country code, when the platform provides one.
+		Country string `json:"country,omitempty" bson:"country,omitempty"`
+		// Set when the click came from a crawler, link preview unfurler or prefetch.
+		Bot bool `json:"bot,omitempty" bson:"bot,omitempty"`
+	}
+)
+
+// VisitorKey identifies the person who clicked, as far as click events allow: the IP hash and the raw User-Agent.
+// It is empty for bots and clicks without an IP, which aren't counted as visitors.
+func (c Click) VisitorKey() string {
+	if c.Bot || len(c.IPHash) == 0 {
+		return ""
+	}
+	return c.IPHash + " " + c.UserAgent.Raw
+}
+
+// HashIP returns a keyed hash of ip. Hashes are only comparable between clicks hashed with the same salt.
+func HashIP(ip, salt string) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(ip))
+	// 128 bits is plenty to tell visitors apart
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
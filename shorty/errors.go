@@ -9,3 +9,22 @@ var ErrJSONUnmarshal = errors.New("cannot parse Link JSON")
 var ErrCodeInUse = errors.New("code already in use")
 var ErrRelativeURL = errors.New("URL is relative")
 var ErrInvalidURL = errors.New("URL improperly formatted")
+var ErrInvalidLink = errors.New("link failed validation")
+
+// ValidationError is returned by stores when a Link is rejected by the database's schema validation.
+// It matches ErrInvalidLink with errors.Is.
+type ValidationError struct {
+	// Explanation of why the link was rejected, as reported by the database.
+	Details string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Details) == 0 {
+		return ErrInvalidLink.Error()
+	}
+	return ErrInvalidLink.Error() + ": " + e.Details
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidLink
+}
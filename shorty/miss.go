@@ -0,0 +1,60 @@
+package shorty
+
+import (
+	"time"
+)
+
+// Number of referrers listed with each MissCount
+const MissTopReferrers = 5
+
+type (
+	// Miss is a request for a code that has no link, ex: a typo on a printed flyer.
+	Miss struct {
+		Code string `json:"code" bson:"code"`
+		// Page the request came from, without its query string. Empty for direct traffic, like a typed URL.
+		Referrer string    `json:"referrer" bson:"referrer"`
+		At       time.Time `json:"at" bson:"at"`
+	}
+
+	// MissTally is the misses of one code from one referrer, ex: the misses buffered since the last write.
+	MissTally struct {
+		Code      string
+		Referrer  string
+		Count     int
+		FirstSeen time.Time
+		LastSeen  time.Time
+	}
+
+	// MissQuery selects the unknown codes requested at or after Since, most requested first.
+	// Only the misses from referrers that sent one at or after Since are counted.
+	MissQuery struct {
+		Since time.Time
+		Limit int
+	}
+
+	// MissCount is how often an unknown code has been requested.
+	MissCount struct {
+		Code      string    `json:"code"`
+		Misses    int       `json:"misses"`
+		FirstSeen time.Time `json:"firstSeen"`
+		LastSeen  time.Time `json:"lastSeen"`
+		// The most common referrers of the misses.
+		Referrers []StatsCount `json:"referrers"`
+	}
+)
+
+// NewMissTally starts a tally with m.
+func NewMissTally(m Miss) MissTally {
+	return MissTally{Code: m.Code, Referrer: m.Referrer, Count: 1, FirstSeen: m.At, LastSeen: m.At}
+}
+
+// Add counts another tally of the same code and referrer.
+func (t *MissTally) Add(other MissTally) {
+	t.Count += other.Count
+	if other.FirstSeen.Before(t.FirstSeen) {
+		t.FirstSeen = other.FirstSeen
+	}
+	if other.LastSeen.After(t.LastSeen) {
+		t.LastSeen = other.LastSeen
+	}
+}
@@ -17,8 +17,16 @@ type (
 		CustomCode string `json:"customCode" bson:"customCode"`
 		// The URL where the short URL redirects.
 		OriginalUrl string `json:"originalUrl" bson:"originalUrl"`
-		// Count of times the short URL has been used.
+		// Private links redirect like any other, but are never suggested on the not found page.
+		Private bool `json:"private" bson:"private"`
+		// Count of times people used the short URL. Bots aren't counted, except before they were told apart.
 		TotalClicks int `json:"totalClicks" bson:"totalClicks"`
+		// Clicks by people. Clicks from before bots were told apart are only in TotalClicks.
+		HumanClicks int `json:"humanClicks" bson:"humanClicks"`
+		// Clicks by crawlers, link preview unfurlers and prefetches.
+		BotClicks int `json:"botClicks" bson:"botClicks"`
+		// Approximate number of people who clicked. Only set when fetching a single link, and not stored with it.
+		UniqueVisitors int `json:"uniqueVisitors,omitempty" bson:"-"`
 		// Identifier of the entity that created the short URL.
 		CreatedBy string `json:"createdBy" bson:"createdBy"`
 		// DateTime the URL was created.
@@ -28,8 +36,32 @@ type (
 	}
 
 	Links []*Link
+
+	// UpdateOpts are changes UpdateLink makes that can't be given as fields of the link.
+	UpdateOpts struct {
+		// Sets Private when not nil. Private is left alone otherwise, since false can't be told apart from unset.
+		Private *bool
+	}
+
+	// ClickCounts are clicks on a link, split by whether a bot made them.
+	ClickCounts struct {
+		Human int
+		Bot   int
+	}
 )
 
+// Total returns the human and bot clicks together.
+func (c ClickCounts) Total() int {
+	return c.Human + c.Bot
+}
+
+// AddClicks adds counts to the Link's click totals. Only human clicks count toward TotalClicks.
+func (sl *Link) AddClicks(counts ClickCounts) {
+	sl.TotalClicks += counts.Human
+	sl.HumanClicks += counts.Human
+	sl.BotClicks += counts.Bot
+}
+
 // FromJSON unmarshals a request's JSON body into a Link.
 func (sl *Link) FromJSON(r io.Reader) error {
 	if err := json.NewDecoder(r).Decode(sl); err != nil {
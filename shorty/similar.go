@@ -0,0 +1,137 @@
+package shorty
+
+import (
+	"sort"
+	"strings"
+)
+
+// Most edits allowed between a requested code and a similar one
+const MaxSimilarDistance = 2
+
+// Number of similar codes suggested for an unknown code
+const MaxSuggestions = 3
+
+// Longest skeleton whose deletions are indexed. Longer codes are only similar to codes with the same skeleton.
+const maxIndexedSkeletonLen = 24
+
+// Characters that are easily mistaken for each other on a flyer or when typed, mapped to one of them
+var confusables = map[rune]rune{
+	'0': 'o',
+	'1': 'l',
+	'i': 'l',
+	'|': 'l',
+	'5': 's',
+	'2': 'z',
+}
+
+// Skeleton returns code lowercased, with confusable characters replaced and separators removed,
+// so case and confusable variants of a code have the same skeleton. Ex: "Fall-C0hort" and "fallcohort".
+func Skeleton(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(code) {
+		if r == '-' || r == '_' || r == '.' {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// EditDistance returns the number of insertions, deletions, substitutions and swaps of adjacent characters that turn a into b.
+func EditDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// Three rows of the distance matrix are enough to count swaps
+	prev2, prev, cur := make([]int, len(t)+1), make([]int, len(t)+1), make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
+
+// SimilarityKeys returns code's skeleton with up to maxDistance characters deleted, in every way.
+// Two codes within maxDistance edits of each other share at least one key, so stores can index the keys
+// and look up candidates for RankSimilarCodes without comparing every code.
+func SimilarityKeys(code string, maxDistance int) []string {
+	skeleton := Skeleton(code)
+	keys := map[string]bool{skeleton: true}
+	if len([]rune(skeleton)) <= maxIndexedSkeletonLen {
+		level := []string{skeleton}
+		for d := 0; d < maxDistance; d++ {
+			var next []string
+			for _, k := range level {
+				r := []rune(k)
+				for i := range r {
+					del := string(r[:i]) + string(r[i+1:])
+					if !keys[del] {
+						keys[del] = true
+						next = append(next, del)
+					}
+				}
+			}
+			level = next
+		}
+	}
+
+	out := make([]string, 0, len(keys))
+	for k := range keys {
+		if len(k) > 0 {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// RankSimilarCodes returns the candidates whose skeletons are within maxDistance edits of code's, closest first.
+// At most MaxSuggestions codes are returned, and never code itself.
+func RankSimilarCodes(code string, candidates []string, maxDistance int) []string {
+	type ranked struct {
+		code     string
+		distance int
+		exact    int
+	}
+	skeleton := Skeleton(code)
+	var similar []ranked
+	seen := map[string]bool{code: true}
+	for _, c := range candidates {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		if d := EditDistance(skeleton, Skeleton(c)); d <= maxDistance {
+			similar = append(similar, ranked{c, d, EditDistance(code, c)})
+		}
+	}
+
+	sort.Slice(similar, func(a, b int) bool {
+		if similar[a].distance != similar[b].distance {
+			return similar[a].distance < similar[b].distance
+		}
+		if similar[a].exact != similar[b].exact {
+			return similar[a].exact < similar[b].exact
+		}
+		return similar[a].code < similar[b].code
+	})
+	out := []string{}
+	for n := 0; n < len(similar) && n < MaxSuggestions; n++ {
+		out = append(out, similar[n].code)
+	}
+	return out
+}
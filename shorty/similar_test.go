@@ -0,0 +1,67 @@
+package shorty
+
+import (
+	"testing"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestSkeleton(t *testing.T) {
+	testutil.AssertEqual(t, Skeleton("Fall-C0hort"), Skeleton("fallcohort"))
+	testutil.AssertEqual(t, Skeleton("I1l"), "lll")
+	testutil.AssertEqual(t, Skeleton("abc") == Skeleton("abd"), false)
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"fallcohort", "fal-cohort", 1},
+		// A swap of adjacent characters is one edit
+		{"cohort", "chohrt", 2},
+		{"cohort", "cohrot", 1},
+	}
+	for _, c := range tests {
+		testutil.AssertEqual(t, EditDistance(c.a, c.b), c.want)
+		testutil.AssertEqual(t, EditDistance(c.b, c.a), c.want)
+	}
+}
+
+func TestSimilarCodes(t *testing.T) {
+	t.Run("codes within the distance share a key", func(t *testing.T) {
+		shared := func(a, b string) bool {
+			keys := map[string]bool{}
+			for _, k := range SimilarityKeys(a, 2) {
+				keys[k] = true
+			}
+			for _, k := range SimilarityKeys(b, 2) {
+				if keys[k] {
+					return true
+				}
+			}
+			return false
+		}
+
+		testutil.AssertEqual(t, shared("fallcohort", "fal-cohort"), true)
+		testutil.AssertEqual(t, shared("fallcohort", "falcohrot"), true)
+		testutil.AssertEqual(t, shared("FALLCOHORT", "fallc0hort"), true)
+		testutil.AssertEqual(t, shared("fallcohort", "springcohort"), false)
+	})
+
+	t.Run("ranks the closest codes first", func(t *testing.T) {
+		candidates := []string{"fallcohorts", "FallCohort", "ballcohort", "spring", "fall-cohort", "fallc0hort"}
+
+		similar := RankSimilarCodes("fallc0hort", candidates, 1)
+
+		testutil.AssertEqual(t, len(similar), 3)
+		// Case and confusable variants first, those with fewer exact edits first, then by code
+		testutil.AssertEqual(t, similar[0], "fall-cohort")
+		testutil.AssertEqual(t, similar[1], "FallCohort")
+		testutil.AssertEqual(t, similar[2], "ballcohort")
+	})
+}
@@ -0,0 +1,162 @@
+package shorty
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Stats intervals
+const (
+	IntervalHour = "hour"
+	IntervalDay  = "day"
+	// Weeks start on Monday, UTC.
+	IntervalWeek = "week"
+)
+
+// Number of values in each top list of ClickStats
+const StatsTopN = 10
+
+// Most buckets a stats query may return
+const maxStatsBuckets = 2000
+
+var ErrInvalidStatsQuery = errors.New("invalid stats query")
+
+type (
+	// StatsQuery selects the click events of one link in [From, To), counted per Interval.
+	StatsQuery struct {
+		Code     string
+		From     time.Time
+		To       time.Time
+		Interval string
+		// Count clicks from bots and link previews too.
+		IncludeBots bool
+	}
+
+	// ClickStats are a link's clicks over time, with the most common sources of those clicks.
+	ClickStats struct {
+		Code     string    `json:"code"`
+		From     time.Time `json:"from"`
+		To       time.Time `json:"to"`
+		Interval string    `json:"interval"`
+		// Clicks between From and To.
+		Total int `json:"total"`
+		// Approximate number of people who clicked, over the whole UTC days between From and To. Bots are never counted.
+		UniqueVisitors int `json:"uniqueVisitors"`
+		// One bucket per interval from From to To, including those without clicks.
+		Series    []StatsBucket `json:"series"`
+		Referrers []StatsCount  `json:"referrers"`
+		Browsers  []StatsCount  `json:"browsers"`
+		OS        []StatsCount  `json:"os"`
+		Devices   []StatsCount  `json:"devices"`
+	}
+
+	StatsBucket struct {
+		// Start of the interval, UTC.
+		Start  time.Time `json:"start"`
+		Clicks int       `json:"clicks"`
+	}
+
+	// StatsCount is the number of clicks with a value, ex: a browser. An empty referrer means direct traffic.
+	StatsCount struct {
+		Value  string `json:"value"`
+		Clicks int    `json:"clicks"`
+	}
+)
+
+// Matches reports whether the query selects c.
+func (q StatsQuery) Matches(c Click) bool {
+	if c.Code != q.Code || c.At.Before(q.From) || !c.At.Before(q.To) {
+		return false
+	}
+	return q.IncludeBots || !c.Bot
+}
+
+// Validate checks the interval and range, and that the range isn't too long for the interval.
+func (q StatsQuery) Validate() error {
+	var step time.Duration
+	switch q.Interval {
+	case IntervalHour:
+		step = time.Hour
+	case IntervalDay:
+		step = 24 * time.Hour
+	case IntervalWeek:
+		step = 7 * 24 * time.Hour
+	default:
+		return fmt.Errorf("%w: interval: want %q, %q, or %q", ErrInvalidStatsQuery, IntervalHour, IntervalDay, IntervalWeek)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidStatsQuery)
+	}
+	if q.To.Sub(q.From)/step > maxStatsBuckets {
+		return fmt.Errorf("%w: more than %d %ss", ErrInvalidStatsQuery, maxStatsBuckets, q.Interval)
+	}
+	return nil
+}
+
+// TruncateInterval returns the start of the interval containing t, in UTC.
+func TruncateInterval(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	switch interval {
+	case IntervalHour:
+		return t.Truncate(time.Hour)
+	case IntervalWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		// Weekday is 0 on Sunday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// NewClickStats returns the stats for q from the click counts of each bucket start, which may leave out empty buckets.
+// The top lists are left to the caller.
+func NewClickStats(q StatsQuery, counts map[time.Time]int) ClickStats {
+	stats := ClickStats{
+		Code:      q.Code,
+		From:      q.From.UTC(),
+		To:        q.To.UTC(),
+		Interval:  q.Interval,
+		Series:    []StatsBucket{},
+		Referrers: []StatsCount{},
+		Browsers:  []StatsCount{},
+		OS:        []StatsCount{},
+		Devices:   []StatsCount{},
+	}
+	for start := TruncateInterval(q.From, q.Interval); start.Before(q.To); start = nextInterval(start, q.Interval) {
+		n := counts[start]
+		stats.Series = append(stats.Series, StatsBucket{Start: start, Clicks: n})
+		stats.Total += n
+	}
+	return stats
+}
+
+// TopCounts returns the n values with the most clicks, ties in order of value.
+func TopCounts(counts map[string]int, n int) []StatsCount {
+	top := make([]StatsCount, 0, len(counts))
+	for v, c := range counts {
+		top = append(top, StatsCount{Value: v, Clicks: c})
+	}
+	sort.Slice(top, func(a, b int) bool {
+		if top[a].Clicks != top[b].Clicks {
+			return top[a].Clicks > top[b].Clicks
+		}
+		return top[a].Value < top[b].Value
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func nextInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalHour:
+		return t.Add(time.Hour)
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
@@ -0,0 +1,23 @@
+package shorty
+
+import (
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestTruncateInterval(t *testing.T) {
+	t.Run("starts weeks on Monday, UTC", func(t *testing.T) {
+		monday := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+		for _, at := range []time.Time{
+			monday,
+			time.Date(2023, 10, 18, 13, 30, 0, 0, time.UTC),
+			time.Date(2023, 10, 22, 23, 59, 0, 0, time.UTC),
+			// Sunday evening in New York is Monday in UTC
+			time.Date(2023, 10, 15, 21, 0, 0, 0, time.FixedZone("EDT", -4*60*60)),
+		} {
+			testutil.AssertEqual(t, TruncateInterval(at, IntervalWeek), monday)
+		}
+	})
+}
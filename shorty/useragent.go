@@ -0,0 +1,113 @@
+package shorty
+
+import "strings"
+
+// Longest User-Agent kept in UserAgent.Raw
+const maxUserAgentLen = 512
+
+type (
+	// UserAgent is the browser, OS and device type parsed from a User-Agent header.
+	UserAgent struct {
+		// Ex: "Chrome", "Safari", or "Other"
+		Browser string `json:"browser" bson:"browser"`
+		// Major version, ex: "118"
+		BrowserVersion string `json:"browserVersion,omitempty" bson:"browserVersion,omitempty"`
+		// Ex: "Windows", "iOS", or "Other"
+		OS string `json:"os" bson:"os"`
+		// "desktop", "mobile", "tablet", or "other"
+		Device string `json:"device" bson:"device"`
+		// The header, truncated, so clicks can be classified again later.
+		Raw string `json:"raw,omitempty" bson:"raw,omitempty"`
+	}
+
+	// UARule matches a User-Agent that contains any of Tokens.
+	uaRule struct {
+		name   string
+		tokens []string
+		// Token followed by the version number. Empty if the version isn't reported.
+		version string
+	}
+)
+
+// Browsers are checked in order, since most User-Agents also claim to be the browsers they're built on.
+// Ex: Edge includes "Chrome/" and "Safari/".
+var browserRules = []uaRule{
+	{name: "Edge", tokens: []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}, version: "Edg"},
+	{name: "Opera", tokens: []string{"OPR/", "Opera"}, version: "OPR/"},
+	{name: "Samsung Internet", tokens: []string{"SamsungBrowser/"}, version: "SamsungBrowser/"},
+	{name: "Firefox", tokens: []string{"Firefox/", "FxiOS/"}, version: "Firefox/"},
+	{name: "Chrome", tokens: []string{"CriOS/", "Chrome/"}, version: "Chrome/"},
+	{name: "Safari", tokens: []string{"Safari/"}, version: "Version/"},
+	{name: "Internet Explorer", tokens: []string{"MSIE ", "Trident/"}, version: "MSIE "},
+}
+
+var osRules = []uaRule{
+	{name: "Windows", tokens: []string{"Windows"}},
+	{name: "iOS", tokens: []string{"iPhone", "iPad", "iPod"}},
+	{name: "Android", tokens: []string{"Android"}},
+	{name: "ChromeOS", tokens: []string{"CrOS"}},
+	{name: "macOS", tokens: []string{"Macintosh", "Mac OS X"}},
+	{name: "Linux", tokens: []string{"Linux"}},
+}
+
+// ParseUserAgent classifies a User-Agent header. Unrecognized values are "Other".
+func ParseUserAgent(header string) UserAgent {
+	ua := UserAgent{Browser: "Other", OS: "Other", Device: "other"}
+	if len(header) > maxUserAgentLen {
+		ua.Raw = header[:maxUserAgentLen]
+	} else {
+		ua.Raw = header
+	}
+
+	for _, rule := range browserRules {
+		if containsAny(header, rule.tokens) {
+			ua.Browser = rule.name
+			ua.BrowserVersion = majorVersion(header, rule.version)
+			break
+		}
+	}
+	for _, rule := range osRules {
+		if containsAny(header, rule.tokens) {
+			ua.OS = rule.name
+			break
+		}
+	}
+
+	switch {
+	case containsAny(header, []string{"iPad", "Tablet"}) || (ua.OS == "Android" && !strings.Contains(header, "Mobile")):
+		ua.Device = "tablet"
+	case containsAny(header, []string{"Mobi", "iPhone", "iPod"}):
+		ua.Device = "mobile"
+	case ua.OS != "Other":
+		ua.Device = "desktop"
+	}
+	return ua
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, t := range tokens {
+		if strings.Contains(s, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// MajorVersion returns the digits after token, ex: "118" for "Chrome/118.0.0.0".
+func majorVersion(header, token string) string {
+	if len(token) == 0 {
+		return ""
+	}
+	i := strings.Index(header, token)
+	if i < 0 {
+		return ""
+	}
+	rest := header[i+len(token):]
+	// Skip the rest of the product name, ex: "A/" after Edge's "Edg"
+	rest = strings.TrimLeftFunc(rest, func(r rune) bool { return r != ' ' && (r < '0' || r > '9') })
+	end := 0
+	for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+		end++
+	}
+	return rest[:end]
+}
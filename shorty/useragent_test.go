@@ -0,0 +1,47 @@
+package shorty
+
+import (
+	"testing"
+
+	"github.com/operationspark/shorty/testutil"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	t.Run("classifies common browsers", func(t *testing.T) {
+		tests := []struct {
+			header string
+			want   UserAgent
+		}{
+			{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+				UserAgent{Browser: "Chrome", BrowserVersion: "118", OS: "Windows", Device: "desktop"},
+			},
+			{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46",
+				UserAgent{Browser: "Edge", BrowserVersion: "118", OS: "Windows", Device: "desktop"},
+			},
+			{
+				"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+				UserAgent{Browser: "Safari", BrowserVersion: "17", OS: "iOS", Device: "mobile"},
+			},
+			{
+				"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36",
+				UserAgent{Browser: "Chrome", BrowserVersion: "117", OS: "Android", Device: "tablet"},
+			},
+			{
+				"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:109.0) Gecko/20100101 Firefox/119.0",
+				UserAgent{Browser: "Firefox", BrowserVersion: "119", OS: "macOS", Device: "desktop"},
+			},
+			{
+				"curl/8.1.2",
+				UserAgent{Browser: "Other", OS: "Other", Device: "other"},
+			},
+		}
+
+		for _, c := range tests {
+			got := ParseUserAgent(c.header)
+			c.want.Raw = c.header
+			testutil.AssertEqual(t, got, c.want)
+		}
+	})
+}
@@ -0,0 +1,468 @@
+// Package storetest is a conformance suite for handlers.LinkStore implementations.
+//
+// Every store is expected to behave the same way, so a store's tests only need to call Run:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+//			return inmem.NewStore()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+)
+
+// Run runs the conformance suite. newStore must return an empty store, and is called once per subtest.
+func Run(t *testing.T, newStore func(t *testing.T) handlers.LinkStore) {
+	ctx := context.Background()
+
+	t.Run("pings", func(t *testing.T) {
+		store := newStore(t)
+
+		if err := store.Ping(ctx); err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+	})
+
+	t.Run("saves and finds a link", func(t *testing.T) {
+		store := newStore(t)
+		want := newLink("abc123")
+
+		saved, err := store.SaveLink(ctx, want)
+		if err != nil {
+			t.Fatalf("SaveLink: %v", err)
+		}
+		assertLink(t, saved, want)
+
+		got, err := store.FindLink(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("FindLink: %v", err)
+		}
+		assertLink(t, got, want)
+	})
+
+	t.Run("rejects saving a code that is in use", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+
+		_, err := store.SaveLink(ctx, newLink("abc123"))
+		assertErr(t, err, shorty.ErrCodeInUse)
+	})
+
+	t.Run("returns ErrLinkNotFound for unknown codes", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.FindLink(ctx, "nope")
+		assertErr(t, err, shorty.ErrLinkNotFound)
+	})
+
+	t.Run("finds all links", func(t *testing.T) {
+		store := newStore(t)
+
+		links, err := store.FindAllLinks(ctx)
+		if err != nil {
+			t.Fatalf("FindAllLinks: %v", err)
+		}
+		testutil.AssertEqual(t, len(links), 0)
+
+		want := map[string]shorty.Link{}
+		for _, code := range []string{"abc123", "def456", "ghi789"} {
+			want[code] = mustSave(t, store, newLink(code))
+		}
+
+		links, err = store.FindAllLinks(ctx)
+		if err != nil {
+			t.Fatalf("FindAllLinks: %v", err)
+		}
+		testutil.AssertEqual(t, len(links), len(want))
+		for _, l := range links {
+			w, ok := want[l.Code]
+			if !ok {
+				t.Fatalf("unexpected or repeated link %q", l.Code)
+			}
+			assertLink(t, *l, w)
+			delete(want, l.Code)
+		}
+	})
+
+	t.Run("updates the originalUrl", func(t *testing.T) {
+		store := newStore(t)
+		saved := mustSave(t, store, newLink("abc123"))
+		mustIncrement(t, store, "abc123")
+
+		updated, err := store.UpdateLink(ctx, "abc123", shorty.Link{OriginalUrl: "https://example.com/new"}, shorty.UpdateOpts{})
+		if err != nil {
+			t.Fatalf("UpdateLink: %v", err)
+		}
+
+		want := saved
+		want.OriginalUrl = "https://example.com/new"
+		want.TotalClicks = 1
+		want.UpdatedAt = updated.UpdatedAt
+		assertLink(t, updated, want)
+		if !updated.UpdatedAt.After(saved.UpdatedAt) {
+			t.Errorf("updatedAt should advance past %v, got %v", saved.UpdatedAt, updated.UpdatedAt)
+		}
+
+		found, err := store.FindLink(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("FindLink: %v", err)
+		}
+		assertLink(t, found, updated)
+	})
+
+	t.Run("moves a link to its new customCode", func(t *testing.T) {
+		store := newStore(t)
+		saved := mustSave(t, store, newLink("abc123"))
+		mustIncrement(t, store, "abc123")
+
+		input := shorty.Link{CustomCode: "moved"}
+		input.GenCode("https://ospk.org")
+		updated, err := store.UpdateLink(ctx, "abc123", input, shorty.UpdateOpts{})
+		if err != nil {
+			t.Fatalf("UpdateLink: %v", err)
+		}
+
+		want := saved
+		want.Code = "moved"
+		want.CustomCode = "moved"
+		want.ShortURL = "https://ospk.org/moved"
+		want.TotalClicks = 1
+		want.UpdatedAt = updated.UpdatedAt
+		assertLink(t, updated, want)
+
+		_, err = store.FindLink(ctx, "abc123")
+		assertErr(t, err, shorty.ErrLinkNotFound)
+
+		found, err := store.FindLink(ctx, "moved")
+		if err != nil {
+			t.Fatalf("FindLink: %v", err)
+		}
+		assertLink(t, found, updated)
+
+		links, err := store.FindAllLinks(ctx)
+		if err != nil {
+			t.Fatalf("FindAllLinks: %v", err)
+		}
+		testutil.AssertEqual(t, len(links), 1)
+	})
+
+	t.Run("rejects moving a link to a code in use", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+		mustSave(t, store, newLink("def456"))
+
+		input := shorty.Link{CustomCode: "def456"}
+		input.GenCode("https://ospk.org")
+		_, err := store.UpdateLink(ctx, "abc123", input, shorty.UpdateOpts{})
+		assertErr(t, err, shorty.ErrCodeInUse)
+
+		if _, err := store.FindLink(ctx, "abc123"); err != nil {
+			t.Errorf("FindLink(abc123): %v", err)
+		}
+	})
+
+	t.Run("updates private only when it's given", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+
+		private := true
+		updated, err := store.UpdateLink(ctx, "abc123", shorty.Link{}, shorty.UpdateOpts{Private: &private})
+		if err != nil {
+			t.Fatalf("UpdateLink: %v", err)
+		}
+		testutil.AssertEqual(t, updated.Private, true)
+
+		updated, err = store.UpdateLink(ctx, "abc123", shorty.Link{OriginalUrl: "https://example.com/new"}, shorty.UpdateOpts{})
+		if err != nil {
+			t.Fatalf("UpdateLink: %v", err)
+		}
+		testutil.AssertEqual(t, updated.Private, true)
+	})
+
+	t.Run("returns ErrLinkNotFound when updating an unknown code", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.UpdateLink(ctx, "nope", shorty.Link{OriginalUrl: "https://example.com"}, shorty.UpdateOpts{})
+		assertErr(t, err, shorty.ErrLinkNotFound)
+	})
+
+	t.Run("deletes a link", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+
+		n, err := store.DeleteLink(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("DeleteLink: %v", err)
+		}
+		testutil.AssertEqual(t, n, 1)
+
+		_, err = store.FindLink(ctx, "abc123")
+		assertErr(t, err, shorty.ErrLinkNotFound)
+
+		n, err = store.DeleteLink(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("DeleteLink: %v", err)
+		}
+		testutil.AssertEqual(t, n, 0)
+	})
+
+	t.Run("streams every link in order of code", func(t *testing.T) {
+		store := newStore(t)
+		for _, code := range []string{"ghi789", "abc123", "def456"} {
+			mustSave(t, store, newLink(code))
+		}
+
+		var codes []string
+		err := store.EachLink(ctx, func(l shorty.Link) error {
+			codes = append(codes, l.Code)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("EachLink: %v", err)
+		}
+		testutil.AssertEqual(t, strings.Join(codes, ","), "abc123,def456,ghi789")
+
+		stop := errors.New("stop")
+		calls := 0
+		err = store.EachLink(ctx, func(l shorty.Link) error {
+			calls++
+			return stop
+		})
+		assertErr(t, err, stop)
+		testutil.AssertEqual(t, calls, 1)
+	})
+
+	t.Run("finds similar public codes", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("fall-cohort"))
+		mustSave(t, store, newLink("FallCohort"))
+		mustSave(t, store, newLink("spring-cohort"))
+		secret := newLink("fall-cohorts")
+		secret.Private = true
+		mustSave(t, store, secret)
+
+		similar, err := store.FindSimilarCodes(ctx, "fal-c0hort", 1)
+		if err != nil {
+			t.Fatalf("FindSimilarCodes: %v", err)
+		}
+		testutil.AssertEqual(t, len(similar), 2)
+		testutil.AssertEqual(t, similar[0], "fall-cohort")
+		testutil.AssertEqual(t, similar[1], "FallCohort")
+
+		// Case and confusable variants only
+		similar, err = store.FindSimilarCodes(ctx, "FALL-C0HORT", 0)
+		if err != nil {
+			t.Fatalf("FindSimilarCodes: %v", err)
+		}
+		testutil.AssertEqual(t, len(similar), 2)
+
+		// Moved links are found by their new code
+		input := shorty.Link{CustomCode: "winter-cohort"}
+		input.GenCode("https://ospk.org")
+		if _, err := store.UpdateLink(ctx, "spring-cohort", input, shorty.UpdateOpts{}); err != nil {
+			t.Fatalf("UpdateLink: %v", err)
+		}
+		similar, err = store.FindSimilarCodes(ctx, "winter-cohrot", 1)
+		if err != nil {
+			t.Fatalf("FindSimilarCodes: %v", err)
+		}
+		testutil.AssertEqual(t, len(similar), 1)
+		testutil.AssertEqual(t, similar[0], "winter-cohort")
+	})
+
+	t.Run("checks whether a code is in use", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+
+		inUse, err := store.CheckCodeInUse(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("CheckCodeInUse: %v", err)
+		}
+		testutil.AssertEqual(t, inUse, true)
+
+		inUse, err = store.CheckCodeInUse(ctx, "nope")
+		if err != nil {
+			t.Fatalf("CheckCodeInUse: %v", err)
+		}
+		testutil.AssertEqual(t, inUse, false)
+	})
+
+	t.Run("increments clicks and returns the new total", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+
+		for want := 1; want <= 3; want++ {
+			testutil.AssertEqual(t, mustIncrement(t, store, "abc123"), want)
+		}
+
+		_, err := store.IncrementTotalClicks(ctx, "nope")
+		assertErr(t, err, shorty.ErrLinkNotFound)
+	})
+
+	t.Run("increments clicks in batches", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+		mustSave(t, store, newLink("def456"))
+		mustIncrement(t, store, "abc123")
+
+		err := store.IncrementTotalClicksBatch(ctx, map[string]shorty.ClickCounts{
+			"abc123": {Human: 2},
+			"def456": {Human: 3, Bot: 2},
+			"nope":   {Human: 1},
+		})
+		if err != nil {
+			t.Fatalf("IncrementTotalClicksBatch: %v", err)
+		}
+		assertClicks(t, store, "abc123", 3)
+		// Bot clicks aren't part of the total
+		assertClicks(t, store, "def456", 3)
+
+		link, err := store.FindLink(ctx, "def456")
+		if err != nil {
+			t.Fatalf("FindLink: %v", err)
+		}
+		testutil.AssertEqual(t, link.HumanClicks, 3)
+		testutil.AssertEqual(t, link.BotClicks, 2)
+
+		// Unknown codes are skipped, not created
+		_, err = store.FindLink(ctx, "nope")
+		assertErr(t, err, shorty.ErrLinkNotFound)
+	})
+
+	t.Run("counts concurrent clicks", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, newLink("abc123"))
+
+		const workers, clicks = 8, 25
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for c := 0; c < clicks; c++ {
+					if _, err := store.IncrementTotalClicks(ctx, "abc123"); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("IncrementTotalClicks: %v", err)
+		}
+		assertClicks(t, store, "abc123", workers*clicks)
+	})
+
+	t.Run("saves a contended code once", func(t *testing.T) {
+		store := newStore(t)
+
+		const workers = 8
+		var wg sync.WaitGroup
+		results := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				link := newLink("abc123")
+				link.OriginalUrl = fmt.Sprintf("https://example.com/%d", w)
+				_, err := store.SaveLink(ctx, link)
+				results <- err
+			}(w)
+		}
+		wg.Wait()
+		close(results)
+
+		saved := 0
+		for err := range results {
+			switch {
+			case err == nil:
+				saved++
+			case !errors.Is(err, shorty.ErrCodeInUse):
+				t.Errorf("SaveLink: want nil or %v, got %v", shorty.ErrCodeInUse, err)
+			}
+		}
+		testutil.AssertEqual(t, saved, 1)
+	})
+}
+
+// NewLink returns a link with every field set. Times are truncated to milliseconds, the precision MongoDB stores.
+func newLink(code string) shorty.Link {
+	// In the past so updates always advance updatedAt
+	now := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	return shorty.Link{
+		Code:        code,
+		CustomCode:  code,
+		ShortURL:    "https://ospk.org/" + code,
+		OriginalUrl: "https://operationspark.org/" + code,
+		CreatedBy:   "storetest",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+func mustSave(t *testing.T, store handlers.LinkStore, link shorty.Link) shorty.Link {
+	t.Helper()
+	saved, err := store.SaveLink(context.Background(), link)
+	if err != nil {
+		t.Fatalf("SaveLink(%s): %v", link.Code, err)
+	}
+	return saved
+}
+
+func mustIncrement(t *testing.T, store handlers.LinkStore, code string) int {
+	t.Helper()
+	n, err := store.IncrementTotalClicks(context.Background(), code)
+	if err != nil {
+		t.Fatalf("IncrementTotalClicks(%s): %v", code, err)
+	}
+	return n
+}
+
+func assertClicks(t *testing.T, store handlers.LinkStore, code string, want int) {
+	t.Helper()
+	link, err := store.FindLink(context.Background(), code)
+	if err != nil {
+		t.Fatalf("FindLink(%s): %v", code, err)
+	}
+	testutil.AssertEqual(t, link.TotalClicks, want)
+}
+
+func assertErr(t *testing.T, got, want error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Fatalf("want error %v, got %v", want, got)
+	}
+}
+
+// AssertLink compares links field by field, comparing times with Equal so time zones don't matter.
+func assertLink(t *testing.T, got, want shorty.Link) {
+	t.Helper()
+	testutil.AssertEqual(t, got.Code, want.Code)
+	testutil.AssertEqual(t, got.CustomCode, want.CustomCode)
+	testutil.AssertEqual(t, got.ShortURL, want.ShortURL)
+	testutil.AssertEqual(t, got.OriginalUrl, want.OriginalUrl)
+	testutil.AssertEqual(t, got.CreatedBy, want.CreatedBy)
+	testutil.AssertEqual(t, got.TotalClicks, want.TotalClicks)
+	testutil.AssertEqual(t, got.Private, want.Private)
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("createdAt: want %v, got %v", want.CreatedAt, got.CreatedAt)
+	}
+	if !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Fatalf("updatedAt: want %v, got %v", want.UpdatedAt, got.UpdatedAt)
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CommandKey identifies a command between its started and finished events.
+type commandKey struct {
+	connectionID string
+	requestID    int64
+}
+
+// CommandMonitor records a client span for every MongoDB command, as a child of the span in the command's context.
+// Commands are described by name and collection. Their documents aren't recorded, since they hold links and click data.
+func CommandMonitor(tp trace.TracerProvider) *event.CommandMonitor {
+	tracer := tp.Tracer(tracerName)
+	var lock sync.Mutex
+	spans := map[commandKey]trace.Span{}
+
+	finish := func(e event.CommandFinishedEvent, failure string) {
+		key := commandKey{e.ConnectionID, e.RequestID}
+		lock.Lock()
+		span, ok := spans[key]
+		delete(spans, key)
+		lock.Unlock()
+		if !ok {
+			return
+		}
+		if len(failure) > 0 {
+			span.SetStatus(codes.Error, failure)
+		}
+		span.End()
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			name := e.CommandName
+			attrs := []attribute.KeyValue{
+				semconv.DBSystemMongoDB,
+				semconv.DBNamespace(e.DatabaseName),
+				semconv.DBOperationName(e.CommandName),
+			}
+			// Collection commands, like find and insert, name the collection as the command's value
+			if coll, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+				name += " " + coll
+				attrs = append(attrs, semconv.DBCollectionName(coll))
+			}
+			_, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+			lock.Lock()
+			defer lock.Unlock()
+			spans[commandKey{e.ConnectionID, e.RequestID}] = span
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			finish(e.CommandFinishedEvent, "")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			finish(e.CommandFinishedEvent, e.Failure)
+		},
+	}
+}
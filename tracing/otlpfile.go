@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// FileExporter appends spans to a file in the OTLP JSON encoding, one ExportTraceServiceRequest per line,
+// like the OpenTelemetry Collector's file exporter. The collector's otlpjsonfile receiver can read it back.
+type FileExporter struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// The OTLP JSON types. Trace and span IDs are hex, and 64 bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	otlpSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId,omitempty"`
+		Name         string `json:"name"`
+		// trace.SpanKind values match the OTLP enum
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string         `json:"stringValue,omitempty"`
+		BoolValue   *bool           `json:"boolValue,omitempty"`
+		IntValue    *string         `json:"intValue,omitempty"`
+		DoubleValue *float64        `json:"doubleValue,omitempty"`
+		ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+	}
+
+	otlpArrayValue struct {
+		Values []otlpValue `json:"values"`
+	}
+)
+
+// NewFileExporter opens path for appending, creating it if needed.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open: %v", err)
+	}
+	return &FileExporter{file: f}, nil
+}
+
+// ExportSpans writes spans as one line.
+func (e *FileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	line, err := json.Marshal(otlpRequestOf(spans))
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	if _, err := e.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	return nil
+}
+
+// Shutdown closes the file. Spans exported afterward are dropped.
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
+
+// OtlpRequestOf groups spans by resource, then by instrumentation scope.
+func otlpRequestOf(spans []sdktrace.ReadOnlySpan) otlpRequest {
+	var req otlpRequest
+	resources := map[attribute.Distinct]int{}
+	scopes := map[attribute.Distinct]map[instrumentation.Scope]int{}
+	for _, s := range spans {
+		res := s.Resource().Equivalent()
+		r, ok := resources[res]
+		if !ok {
+			r = len(req.ResourceSpans)
+			resources[res] = r
+			scopes[res] = map[instrumentation.Scope]int{}
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: otlpAttributes(s.Resource().Attributes())},
+			})
+		}
+
+		scope := s.InstrumentationScope()
+		n, ok := scopes[res][scope]
+		if !ok {
+			n = len(req.ResourceSpans[r].ScopeSpans)
+			scopes[res][scope] = n
+			req.ResourceSpans[r].ScopeSpans = append(req.ResourceSpans[r].ScopeSpans, otlpScopeSpans{
+				Scope: otlpScope{Name: scope.Name, Version: scope.Version},
+			})
+		}
+		ss := &req.ResourceSpans[r].ScopeSpans[n]
+		ss.Spans = append(ss.Spans, otlpSpanOf(s))
+	}
+	return req
+}
+
+func otlpSpanOf(s sdktrace.ReadOnlySpan) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.SpanContext().TraceID().String(),
+		SpanID:            s.SpanContext().SpanID().String(),
+		Name:              s.Name(),
+		Kind:              int(s.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+		Attributes:        otlpAttributes(s.Attributes()),
+		Status:            otlpStatus{Message: s.Status().Description},
+	}
+	if s.Parent().HasSpanID() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+	// OTLP numbers the status codes differently
+	switch s.Status().Code {
+	case codes.Ok:
+		span.Status.Code = 1
+	case codes.Error:
+		span.Status.Code = 2
+	}
+	for _, e := range s.Events() {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+			Name:         e.Name,
+			Attributes:   otlpAttributes(e.Attributes),
+		})
+	}
+	return span
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	out := make([]otlpKeyValue, len(attrs))
+	for n, a := range attrs {
+		out[n] = otlpKeyValue{Key: string(a.Key), Value: otlpValueOf(a.Value)}
+	}
+	return out
+}
+
+func otlpValueOf(v attribute.Value) otlpValue {
+	var out otlpValue
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		out.BoolValue = &b
+	case attribute.INT64:
+		i := strconv.FormatInt(v.AsInt64(), 10)
+		out.IntValue = &i
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		out.DoubleValue = &f
+	case attribute.BOOLSLICE:
+		out.ArrayValue = &otlpArrayValue{}
+		for _, b := range v.AsBoolSlice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, otlpValueOf(attribute.BoolValue(b)))
+		}
+	case attribute.INT64SLICE:
+		out.ArrayValue = &otlpArrayValue{}
+		for _, i := range v.AsInt64Slice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, otlpValueOf(attribute.Int64Value(i)))
+		}
+	case attribute.FLOAT64SLICE:
+		out.ArrayValue = &otlpArrayValue{}
+		for _, f := range v.AsFloat64Slice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, otlpValueOf(attribute.Float64Value(f)))
+		}
+	case attribute.STRINGSLICE:
+		out.ArrayValue = &otlpArrayValue{}
+		for _, s := range v.AsStringSlice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, otlpValueOf(attribute.StringValue(s)))
+		}
+	default:
+		s := v.Emit()
+		out.StringValue = &s
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/shorty"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute with the link code a store call is for
+const codeKey = attribute.Key("shorty.link.code")
+
+// Store records a span for every call to another LinkStore.
+type Store struct {
+	next   handlers.LinkStore
+	tracer trace.Tracer
+}
+
+// NewStore wraps a LinkStore with spans from tp.
+func NewStore(next handlers.LinkStore, tp trace.TracerProvider) *Store {
+	return &Store{next: next, tracer: tp.Tracer(tracerName)}
+}
+
+func (s *Store) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "LinkStore."+op, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed if err is. Unknown and taken codes are answers, not failures.
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, shorty.ErrLinkNotFound) && !errors.Is(err, shorty.ErrCodeInUse) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *Store) FindLink(ctx context.Context, code string) (shorty.Link, error) {
+	ctx, span := s.start(ctx, "FindLink", codeKey.String(code))
+	link, err := s.next.FindLink(ctx, code)
+	end(span, err)
+	return link, err
+}
+
+func (s *Store) FindAllLinks(ctx context.Context) (shorty.Links, error) {
+	ctx, span := s.start(ctx, "FindAllLinks")
+	links, err := s.next.FindAllLinks(ctx)
+	end(span, err)
+	return links, err
+}
+
+// EachLink spans the whole stream. Errors returned by fn don't fail the span.
+func (s *Store) EachLink(ctx context.Context, fn func(shorty.Link) error) error {
+	ctx, span := s.start(ctx, "EachLink")
+	var fnErr error
+	err := s.next.EachLink(ctx, func(l shorty.Link) error {
+		fnErr = fn(l)
+		return fnErr
+	})
+	storeErr := err
+	if fnErr != nil {
+		storeErr = nil
+	}
+	end(span, storeErr)
+	return err
+}
+
+func (s *Store) SaveLink(ctx context.Context, newLink shorty.Link) (shorty.Link, error) {
+	ctx, span := s.start(ctx, "SaveLink", codeKey.String(newLink.Code))
+	link, err := s.next.SaveLink(ctx, newLink)
+	end(span, err)
+	return link, err
+}
+
+func (s *Store) UpdateLink(ctx context.Context, code string, toUpdate shorty.Link, opts shorty.UpdateOpts) (shorty.Link, error) {
+	ctx, span := s.start(ctx, "UpdateLink", codeKey.String(code))
+	link, err := s.next.UpdateLink(ctx, code, toUpdate, opts)
+	end(span, err)
+	return link, err
+}
+
+func (s *Store) DeleteLink(ctx context.Context, code string) (int, error) {
+	ctx, span := s.start(ctx, "DeleteLink", codeKey.String(code))
+	n, err := s.next.DeleteLink(ctx, code)
+	end(span, err)
+	return n, err
+}
+
+func (s *Store) CheckCodeInUse(ctx context.Context, code string) (bool, error) {
+	ctx, span := s.start(ctx, "CheckCodeInUse", codeKey.String(code))
+	inUse, err := s.next.CheckCodeInUse(ctx, code)
+	end(span, err)
+	return inUse, err
+}
+
+func (s *Store) FindSimilarCodes(ctx context.Context, code string, maxDistance int) ([]string, error) {
+	ctx, span := s.start(ctx, "FindSimilarCodes", codeKey.String(code))
+	codes, err := s.next.FindSimilarCodes(ctx, code, maxDistance)
+	end(span, err)
+	return codes, err
+}
+
+func (s *Store) IncrementTotalClicks(ctx context.Context, code string) (int, error) {
+	ctx, span := s.start(ctx, "IncrementTotalClicks", codeKey.String(code))
+	n, err := s.next.IncrementTotalClicks(ctx, code)
+	end(span, err)
+	return n, err
+}
+
+func (s *Store) IncrementTotalClicksBatch(ctx context.Context, counts map[string]shorty.ClickCounts) error {
+	ctx, span := s.start(ctx, "IncrementTotalClicksBatch", attribute.Int("shorty.link.count", len(counts)))
+	err := s.next.IncrementTotalClicksBatch(ctx, counts)
+	end(span, err)
+	return err
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping")
+	err := s.next.Ping(ctx)
+	end(span, err)
+	return err
+}
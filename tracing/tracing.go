@@ -0,0 +1,69 @@
+// Package tracing records OpenTelemetry spans for LinkStore calls and MongoDB commands,
+// and exports them to stdout or an OTLP JSON file for local use.
+package tracing
+
+import (
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exporters accepted by Opts.Exporter
+const (
+	ExporterStdout   = "stdout"
+	ExporterOTLPFile = "otlp-file"
+)
+
+// Name of the tracer that records store and MongoDB spans
+const tracerName = "github.com/operationspark/shorty/tracing"
+
+// Opts configures NewProvider.
+type Opts struct {
+	// "stdout" or "otlp-file".
+	Exporter string
+	// File the otlp-file exporter appends to. Defaults to "traces.jsonl".
+	Path string
+	// Fraction of new traces that are sampled. Requests with a traceparent follow the caller's decision. Defaults to 1.
+	SampleRatio float64
+	// Reported as service.name. Defaults to "url-shortener".
+	ServiceName string
+}
+
+// NewProvider returns a TracerProvider that samples and exports spans as configured.
+// Shut it down to flush spans that haven't been exported yet.
+func NewProvider(o Opts) (*sdktrace.TracerProvider, error) {
+	if o.SampleRatio <= 0 || o.SampleRatio > 1 {
+		o.SampleRatio = 1
+	}
+	if len(o.ServiceName) == 0 {
+		o.ServiceName = "url-shortener"
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch o.Exporter {
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLPFile:
+		path := o.Path
+		if len(path) == 0 {
+			path = "traces.jsonl"
+		}
+		exporter, err = NewFileExporter(path)
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", o.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s exporter: %v", o.Exporter, err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(o.ServiceName))),
+	), nil
+}
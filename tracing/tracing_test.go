@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/operationspark/shorty/handlers"
+	"github.com/operationspark/shorty/inmem"
+	"github.com/operationspark/shorty/shorty"
+	"github.com/operationspark/shorty/testutil"
+	"github.com/operationspark/shorty/testutil/storetest"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// FailingStore fails every Ping.
+type failingStore struct {
+	handlers.LinkStore
+}
+
+func (failingStore) Ping(ctx context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("records a span for each call", func(t *testing.T) {
+		spans := tracetest.NewSpanRecorder()
+		store := NewStore(failingStore{inmem.NewStore()}, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+		if _, err := store.FindLink(ctx, "nope"); err != shorty.ErrLinkNotFound {
+			t.Fatalf("want ErrLinkNotFound, got %v", err)
+		}
+		if err := store.Ping(ctx); err == nil {
+			t.Fatal("want an error")
+		}
+
+		ended := spans.Ended()
+		testutil.AssertEqual(t, len(ended), 2)
+		testutil.AssertEqual(t, ended[0].Name(), "LinkStore.FindLink")
+		// Unknown codes aren't errors
+		testutil.AssertEqual(t, ended[0].Status().Code, codes.Unset)
+		testutil.AssertEqual(t, ended[1].Name(), "LinkStore.Ping")
+		testutil.AssertEqual(t, ended[1].Status().Code, codes.Error)
+	})
+}
+
+func TestStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) handlers.LinkStore {
+		return NewStore(inmem.NewStore(), sdktrace.NewTracerProvider())
+	})
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "GET /")
+	_, child := tp.Tracer("test").Start(ctx, "LinkStore.FindLink")
+	child.End()
+	parent.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each export is one request line
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var spans []otlpSpan
+	for dec.More() {
+		var req otlpRequest
+		if err := dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}
+
+	testutil.AssertEqual(t, len(spans), 2)
+	testutil.AssertEqual(t, spans[0].Name, "LinkStore.FindLink")
+	testutil.AssertEqual(t, spans[0].TraceID, parent.SpanContext().TraceID().String())
+	testutil.AssertEqual(t, spans[0].ParentSpanID, parent.SpanContext().SpanID().String())
+	testutil.AssertEqual(t, spans[1].Name, "GET /")
+	testutil.AssertEqual(t, spans[1].ParentSpanID, "")
+}